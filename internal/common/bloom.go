@@ -0,0 +1,59 @@
+package common
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomBitsPerKey gives ~1% of false positives with the optimal number of hashes
+const bloomBitsPerKey = 10
+
+// BloomFilter tells that a key is definitely not in the set it was built from (or may be in it).
+// The first byte is the number of hashes, the rest are bits, so the filter is stored as is.
+type BloomFilter []byte
+
+// NewBloomFilter builds the filter of the keys, an empty set has no bits and contains nothing.
+func NewBloomFilter(keys [][]byte) BloomFilter {
+	hashes := int(math.Round(bloomBitsPerKey * math.Ln2))
+	f := make(BloomFilter, 1+(len(keys)*bloomBitsPerKey+7)/8)
+	f[0] = byte(hashes)
+	for _, key := range keys {
+		f.positions(key, func(byteIdx int, mask byte) bool {
+			f[byteIdx] |= mask
+			return true
+		})
+	}
+	return f
+}
+
+// MayContain returns false if the key was not added to the filter
+func (f BloomFilter) MayContain(key []byte) bool {
+	if len(f) < 2 {
+		return false
+	}
+	contains := true
+	f.positions(key, func(byteIdx int, mask byte) bool {
+		contains = f[byteIdx]&mask != 0
+		return contains
+	})
+	return contains
+}
+
+// positions calls fn with bits of the key until it returns false.
+// Bits are derived from two halves of one hash (double hashing).
+func (f BloomFilter) positions(key []byte, fn func(byteIdx int, mask byte) bool) {
+	bits := uint32((len(f) - 1) * 8)
+	if bits == 0 {
+		return
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	for i := uint32(0); i < uint32(f[0]); i++ {
+		bit := (h1 + i*h2) % bits
+		if !fn(1+int(bit/8), 1<<(bit%8)) {
+			return
+		}
+	}
+}
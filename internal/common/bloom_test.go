@@ -0,0 +1,30 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key%d", i)))
+	}
+	f := NewBloomFilter(keys)
+	for _, key := range keys {
+		require.True(t, f.MayContain(key))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if f.MayContain([]byte(fmt.Sprintf("other%d", i))) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 50)
+
+	require.False(t, NewBloomFilter(nil).MayContain([]byte("key")))
+	require.False(t, BloomFilter(nil).MayContain([]byte("key")))
+}
@@ -0,0 +1,735 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// File is a random access reader over the (uncompressed) content of a log file.
+type File interface {
+	io.ReaderAt
+	io.Closer
+	// Len is the size of the content
+	Len() int
+}
+
+// Opener opens log files, compressed files are read by their uncompressed offsets.
+// Seek indexes of compressed files are kept in the store, so a file is decompressed in full only once.
+type Opener struct {
+	store SeekIndexStore
+	// sizes of recently opened compressed files, so they are checked without loading seek indexes
+	sizes *recentCache[SeekIndex]
+}
+
+// NewOpener keeps seek indexes in the store, or in memory for the recently opened files if the store is nil.
+func NewOpener(store SeekIndexStore) *Opener {
+	if store == nil {
+		store = &memorySeekIndexStore{indexes: newRecentCache[SeekIndex](maxMemorySeekIndexes)}
+	}
+	return &Opener{store: store, sizes: newRecentCache[SeekIndex](maxCachedSizes)}
+}
+
+// OpenFile opens a log file, the seek index of a compressed file is built on the first open.
+func (o *Opener) OpenFile(path string) (File, error) {
+	if !IsCompressed(path) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &plainFile{File: f, size: int(info.Size())}, nil
+	}
+
+	index, err := o.SeekIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	window := func(checkpoint int) ([]byte, error) { return o.store.GetSeekWindow(path, checkpoint) }
+	return &compressedFile{f: f, index: index, window: window, newDecoder: decoderFor(path)}, nil
+}
+
+// IsCompressed tells if the file is compressed (by its extension).
+// Compressed files are considered immutable: they do not grow.
+func IsCompressed(path string) bool {
+	return decoderFor(path) != nil
+}
+
+// decoderFor picks the decompressor by the file extension, nil for plain files.
+func decoderFor(path string) func(io.Reader) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+	case ".zst":
+		return func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	}
+	return nil
+}
+
+type plainFile struct {
+	*os.File
+	size int
+}
+
+func (f *plainFile) Len() int { return f.size }
+
+// checkpointSpan is the uncompressed distance between checkpoints inside gzip members
+const checkpointSpan = 4 << 20
+
+const (
+	// maxMemorySeekIndexes limits seek indexes kept by an opener without a store
+	maxMemorySeekIndexes = 16
+	// maxCachedSizes limits uncompressed sizes cached by an opener
+	maxCachedSizes = 4096
+)
+
+// Checkpoint is a position in a compressed file where decompression can start from:
+// the beginning of a gzip member, a deflate block inside a member, or a zstd frame.
+// zstd blocks depend on the state of previous blocks, so decompression resumes only at frames.
+type Checkpoint struct {
+	Compressed, Uncompressed int
+	// Block is a deflate block inside a gzip member, it starts at the Bits of the Compressed byte
+	Block bool
+	Bits  uint8
+	// the last 32KB of output before the deflate block, stores load it separately (see SeekIndexStore)
+	Window []byte
+}
+
+// SeekIndex maps uncompressed offsets to positions in a compressed file.
+type SeekIndex struct {
+	// Size is the uncompressed size of the file
+	Size        int
+	Checkpoints []Checkpoint
+	// the size and the modification time of the compressed file that the index was built for
+	FileSize int64
+	ModTime  time.Time
+}
+
+// checkpointAt returns the closest checkpoint before the uncompressed offset and its number.
+func (s SeekIndex) checkpointAt(offset int) (int, Checkpoint) {
+	i, found := slices.BinarySearchFunc(
+		s.Checkpoints, offset, func(c Checkpoint, offset int) int { return c.Uncompressed - offset },
+	)
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return -1, Checkpoint{}
+	}
+	return i, s.Checkpoints[i]
+}
+
+// nextMember returns the checkpoint of the gzip member that follows the checkpoint
+func (s SeekIndex) nextMember(checkpoint Checkpoint) (Checkpoint, bool) {
+	for _, c := range s.Checkpoints {
+		if c.Compressed > checkpoint.Compressed && !c.Block {
+			return c, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// Seekable tells if a read decompresses at most 2*checkpointSpan bytes before its offset.
+// A zstd file compressed as a single frame (as the zstd tool does) is decompressed from the start on every read.
+func (s SeekIndex) Seekable() bool {
+	for i, c := range s.Checkpoints {
+		end := s.Size
+		if i+1 < len(s.Checkpoints) {
+			end = s.Checkpoints[i+1].Uncompressed
+		}
+		if end-c.Uncompressed > 2*checkpointSpan {
+			return false
+		}
+	}
+	return true
+}
+
+// builtFor tells if the index is still valid for the file
+func (s SeekIndex) builtFor(info os.FileInfo) bool {
+	return s.FileSize == info.Size() && s.ModTime.Equal(info.ModTime())
+}
+
+// SeekIndexStore keeps seek indexes between runs.
+// Windows of checkpoints are large, so indexes may be returned without them:
+// a window is loaded when decompression resumes at its checkpoint.
+type SeekIndexStore interface {
+	GetSeekIndex(file string) (index SeekIndex, found bool, err error)
+	// GetSeekWindow returns the window of the checkpoint by its number in the index
+	GetSeekWindow(file string, checkpoint int) ([]byte, error)
+	PutSeekIndex(file string, index SeekIndex) error
+}
+
+// memorySeekIndexStore keeps seek indexes of the recently opened files with their windows
+type memorySeekIndexStore struct {
+	indexes *recentCache[SeekIndex]
+}
+
+func (s *memorySeekIndexStore) GetSeekIndex(file string) (SeekIndex, bool, error) {
+	index, ok := s.indexes.get(file)
+	return index, ok, nil
+}
+
+func (s *memorySeekIndexStore) GetSeekWindow(file string, checkpoint int) ([]byte, error) {
+	index, ok := s.indexes.get(file)
+	if !ok || checkpoint >= len(index.Checkpoints) {
+		return nil, fmt.Errorf("no seek index for %s", file)
+	}
+	return index.Checkpoints[checkpoint].Window, nil
+}
+
+func (s *memorySeekIndexStore) PutSeekIndex(file string, index SeekIndex) error {
+	s.indexes.put(file, index)
+	return nil
+}
+
+// SeekIndex returns the seek index of the compressed file, it is built once per file.
+func (o *Opener) SeekIndex(path string) (SeekIndex, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return SeekIndex{}, err
+	}
+	index, found, err := o.store.GetSeekIndex(path)
+	if err != nil {
+		return SeekIndex{}, fmt.Errorf("load seek index %s: %w", path, err)
+	}
+	if !found || !index.builtFor(info) {
+		index, err = BuildSeekIndex(path)
+		if err != nil {
+			return SeekIndex{}, fmt.Errorf("seek index %s: %w", path, err)
+		}
+		index.FileSize, index.ModTime = info.Size(), info.ModTime()
+		err = o.store.PutSeekIndex(path, index)
+		if err != nil {
+			return SeekIndex{}, fmt.Errorf("store seek index %s: %w", path, err)
+		}
+	}
+	o.sizes.put(path, SeekIndex{Size: index.Size, FileSize: index.FileSize, ModTime: index.ModTime})
+	return index, nil
+}
+
+// UncompressedSize returns the size of the content of the compressed file,
+// the seek index is loaded only if the file is not among the recently opened ones.
+func (o *Opener) UncompressedSize(path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if size, ok := o.sizes.get(path); ok && size.builtFor(info) {
+		return size.Size, nil
+	}
+	index, err := o.SeekIndex(path)
+	if err != nil {
+		return 0, err
+	}
+	return index.Size, nil
+}
+
+// Forget drops cached data of the file, it is called when the file is removed or renamed.
+func (o *Opener) Forget(path string) {
+	o.sizes.delete(path)
+	if s, ok := o.store.(*memorySeekIndexStore); ok {
+		s.indexes.delete(path)
+	}
+}
+
+// recentCache keeps up to max recently used values, the least recently used one is evicted first
+type recentCache[V any] struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]V
+	keys   []string // the least recently used first
+}
+
+func newRecentCache[V any](max int) *recentCache[V] {
+	return &recentCache[V]{max: max, values: make(map[string]V)}
+}
+
+func (c *recentCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if ok {
+		c.touch(key)
+	}
+	return v, ok
+}
+
+func (c *recentCache[V]) put(key string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok && len(c.keys) == c.max {
+		delete(c.values, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.values[key] = v
+	c.touch(key)
+}
+
+func (c *recentCache[V]) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+	if i := slices.Index(c.keys, key); i >= 0 {
+		c.keys = slices.Delete(c.keys, i, i+1)
+	}
+}
+
+// touch moves the key to the end of keys
+func (c *recentCache[V]) touch(key string) {
+	if i := slices.Index(c.keys, key); i >= 0 {
+		c.keys = slices.Delete(c.keys, i, i+1)
+	}
+	c.keys = append(c.keys, key)
+}
+
+// BuildSeekIndex decompresses the entire file and records its checkpoints.
+func BuildSeekIndex(path string) (SeekIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SeekIndex{}, err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		return buildGzipSeekIndex(f)
+	case ".zst":
+		return buildZstdSeekIndex(f)
+	}
+	return SeekIndex{}, fmt.Errorf("not a compressed file")
+}
+
+// buildGzipSeekIndex puts a checkpoint at every gzip member, and at deflate blocks every checkpointSpan bytes.
+// The flate decoder returns at every block end, the bit where the next block starts is found
+// by resuming decompression from each bit of the current byte with the window and comparing the output.
+func buildGzipSeekIndex(f *os.File) (SeekIndex, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return SeekIndex{}, err
+	}
+	var (
+		index SeekIndex
+		cr    = &countingReader{r: bufio.NewReaderSize(f, 1<<16)}
+		buf   = make([]byte, 1<<16)
+	)
+	for members := 0; members == 0 || !cr.atEnd(); members++ {
+		memberStart := cr.n
+		gz, err := gzip.NewReader(cr) // reads the header only, cr is a flate.Reader so it is not buffered
+		if err != nil {
+			return SeekIndex{}, fmt.Errorf("member at %d: %w", memberStart, err)
+		}
+		gz.Multistream(false)
+		index.Checkpoints = append(index.Checkpoints, Checkpoint{Compressed: memberStart, Uncompressed: index.Size})
+
+		var (
+			dec            = flate.NewReaderOpts(cr, flate.WithPartialBlock())
+			crc            = crc32.NewIEEE()
+			size           int
+			window         []byte // the last 32KB of output, or more
+			lastCheckpoint = index.Size
+			candidate      *blockCandidate
+		)
+		for {
+			n, err := dec.Read(buf)
+			out := buf[:n]
+			crc.Write(out)
+			size += n
+			if candidate != nil {
+				candidate.ahead = append(candidate.ahead, out[:min(n, blockProbeLen-len(candidate.ahead))]...)
+				if len(candidate.ahead) == blockProbeLen || err != nil {
+					if c, ok := candidate.probe(f, info.Size()); ok {
+						index.Checkpoints = append(index.Checkpoints, c)
+						lastCheckpoint = c.Uncompressed
+					}
+					candidate = nil
+				}
+			}
+			window = append(window, out...)
+			if len(window) > 2*maxWindow {
+				window = append(window[:0], window[len(window)-maxWindow:]...)
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return SeekIndex{}, fmt.Errorf("member at %d: %w", memberStart, err)
+			}
+			if uncompressed := index.Size + size; candidate == nil && uncompressed-lastCheckpoint >= checkpointSpan {
+				candidate = &blockCandidate{
+					compressed:   cr.n,
+					uncompressed: uncompressed,
+					window:       slices.Clone(window[max(0, len(window)-maxWindow):]),
+				}
+			}
+		}
+
+		var trailer [8]byte
+		_, err = io.ReadFull(cr, trailer[:])
+		if err != nil {
+			return SeekIndex{}, fmt.Errorf("member at %d: %w", memberStart, err)
+		}
+		if binary.LittleEndian.Uint32(trailer[:4]) != crc.Sum32() || binary.LittleEndian.Uint32(trailer[4:]) != uint32(size) {
+			return SeekIndex{}, fmt.Errorf("member at %d: %w", memberStart, gzip.ErrChecksum)
+		}
+		index.Size += size
+	}
+	return index, nil
+}
+
+const (
+	// maxWindow is the longest distance a deflate match refers back to
+	maxWindow = 32 << 10
+	// blockProbeLen is the output compared to confirm where a deflate block starts
+	blockProbeLen = 4 << 10
+)
+
+// blockCandidate is a position where the flate decoder returned, possibly at the end of a deflate block.
+type blockCandidate struct {
+	compressed, uncompressed int // the decoder has read the input up to compressed
+	window                   []byte
+	ahead                    []byte // the output that follows
+}
+
+// probe finds the bit where the next block starts: the decoder buffers less than 3 bytes of the input,
+// so the block starts at one of the bits before the compressed byte.
+// Returns false if the decoder returned inside a block.
+func (b *blockCandidate) probe(f io.ReaderAt, fileSize int64) (Checkpoint, bool) {
+	out := make([]byte, len(b.ahead))
+	for buffered := 0; buffered < 24 && buffered <= 8*b.compressed; buffered++ {
+		at, bits := (8*b.compressed-buffered)/8, uint8((8*b.compressed-buffered)%8)
+		var stream io.Reader = bufio.NewReader(io.NewSectionReader(f, int64(at), fileSize-int64(at)))
+		if bits > 0 {
+			stream = &bitShiftReader{r: stream.(*bufio.Reader), shift: bits}
+		}
+		n, _ := io.ReadFull(flate.NewReaderDict(stream, b.window), out)
+		if n == len(out) && bytes.Equal(out, b.ahead) {
+			return Checkpoint{Compressed: at, Uncompressed: b.uncompressed, Block: true, Bits: bits, Window: b.window}, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// countingReader counts the bytes read, it implements flate.Reader so decoders read it byte by byte
+type countingReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (c *countingReader) atEnd() bool {
+	_, err := c.r.Peek(1)
+	return err != nil
+}
+
+const (
+	zstdMagic              = 0xFD2FB528
+	zstdSkippableMagicMask = 0xFFFFFFF0
+	zstdSkippableMagic     = 0x184D2A50
+)
+
+// buildZstdSeekIndex puts a checkpoint at every zstd frame.
+// Files compressed in many small frames (e.g. the seekable zstd format) get dense checkpoints.
+func buildZstdSeekIndex(f *os.File) (SeekIndex, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return SeekIndex{}, err
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return SeekIndex{}, err
+	}
+	defer dec.Close()
+
+	var index SeekIndex
+	for pos := 0; pos < int(info.Size()); {
+		frameLen, skippable, err := zstdFrameLen(f, pos)
+		if err != nil {
+			return SeekIndex{}, fmt.Errorf("frame at %d: %w", pos, err)
+		}
+		if !skippable {
+			err = dec.Reset(io.NewSectionReader(f, int64(pos), int64(frameLen)))
+			if err != nil {
+				return SeekIndex{}, err
+			}
+			n, err := io.Copy(io.Discard, dec)
+			if err != nil {
+				return SeekIndex{}, fmt.Errorf("frame at %d: %w", pos, err)
+			}
+			if n > 0 {
+				index.Checkpoints = append(index.Checkpoints, Checkpoint{Compressed: pos, Uncompressed: index.Size})
+			}
+			index.Size += int(n)
+		}
+		pos += frameLen
+	}
+	return index, nil
+}
+
+// zstdFrameLen walks the frame's blocks to find its compressed length.
+func zstdFrameLen(r io.ReaderAt, pos int) (frameLen int, skippable bool, err error) {
+	buf := make([]byte, 8)
+	read := func(off, n int) ([]byte, error) {
+		_, err := r.ReadAt(buf[:n], int64(off))
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf[:n], err
+	}
+
+	b, err := read(pos, 4)
+	if err != nil {
+		return 0, false, err
+	}
+	magic := binary.LittleEndian.Uint32(b)
+	if magic&zstdSkippableMagicMask == zstdSkippableMagic {
+		b, err = read(pos+4, 4)
+		if err != nil {
+			return 0, false, err
+		}
+		return 8 + int(binary.LittleEndian.Uint32(b)), true, nil
+	} else if magic != zstdMagic {
+		return 0, false, fmt.Errorf("unknown zstd magic %x", magic)
+	}
+
+	b, err = read(pos+4, 1)
+	if err != nil {
+		return 0, false, err
+	}
+	descriptor := b[0]
+	singleSegment := descriptor&0x20 != 0
+	hasChecksum := descriptor&0x04 != 0
+	headerLen := 1 + []int{0, 1, 2, 4}[descriptor&0x03] // descriptor + dictionary id
+	if !singleSegment {
+		headerLen++ // window descriptor
+	}
+	switch fcs := descriptor >> 6; {
+	case fcs == 0 && singleSegment:
+		headerLen += 1
+	case fcs > 0:
+		headerLen += []int{0, 2, 4, 8}[fcs]
+	}
+
+	frameLen = 4 + headerLen
+	for {
+		b, err = read(pos+frameLen, 3)
+		if err != nil {
+			return 0, false, err
+		}
+		blockHeader := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		last, blockType, blockSize := blockHeader&1 == 1, (blockHeader>>1)&3, blockHeader>>3
+		frameLen += 3
+		if blockType == 1 { // RLE block holds a single byte
+			blockSize = 1
+		}
+		frameLen += blockSize
+		if last {
+			break
+		}
+	}
+	if hasChecksum {
+		frameLen += 4
+	}
+	return frameLen, false, nil
+}
+
+// compressedFile reads the uncompressed content of the file by offsets.
+// Decompression starts at the closest checkpoint, sequential reads continue the current decompressor.
+type compressedFile struct {
+	mu         sync.Mutex
+	f          *os.File
+	index      SeekIndex
+	window     func(checkpoint int) ([]byte, error) // loads the window of a checkpoint of the index
+	newDecoder func(io.Reader) (io.ReadCloser, error)
+
+	dec io.ReadCloser
+	pos int // uncompressed position of the decompressor
+}
+
+func (c *compressedFile) Len() int { return c.index.Size }
+
+func (c *compressedFile) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset := int(off)
+	if offset >= c.index.Size {
+		return 0, io.EOF
+	}
+
+	i, checkpoint := c.index.checkpointAt(offset)
+	if c.dec == nil || offset < c.pos || checkpoint.Uncompressed > c.pos {
+		err := c.restartAt(i, checkpoint)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := io.CopyN(io.Discard, c.dec, int64(offset-c.pos))
+	if err != nil {
+		c.closeDecoder()
+		return 0, err
+	}
+	c.pos = offset
+
+	n, err := io.ReadFull(c.dec, p)
+	c.pos += n
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if err != nil {
+		c.closeDecoder()
+	}
+	return n, err
+}
+
+func (c *compressedFile) restartAt(i int, checkpoint Checkpoint) error {
+	c.closeDecoder()
+	info, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	section := func(at Checkpoint) *io.SectionReader {
+		return io.NewSectionReader(c.f, int64(at.Compressed), info.Size()-int64(at.Compressed))
+	}
+	if !checkpoint.Block {
+		c.dec, err = c.newDecoder(section(checkpoint))
+		if err != nil {
+			return err
+		}
+		c.pos = checkpoint.Uncompressed
+		return nil
+	}
+
+	// inside a gzip member: the deflate stream resumes with the window, following members need a gzip decoder
+	window := checkpoint.Window
+	if window == nil {
+		window, err = c.window(i)
+		if err != nil {
+			return fmt.Errorf("load window: %w", err)
+		}
+	}
+	var stream io.Reader = bufio.NewReader(section(checkpoint))
+	if checkpoint.Bits > 0 {
+		stream = &bitShiftReader{r: bufio.NewReader(section(checkpoint)), shift: checkpoint.Bits}
+	}
+	dec := &chainedReader{ReadCloser: flate.NewReaderDict(stream, window)}
+	if next, ok := c.index.nextMember(checkpoint); ok {
+		dec.next = func() (io.ReadCloser, error) { return c.newDecoder(section(next)) }
+	}
+	c.dec = dec
+	c.pos = checkpoint.Uncompressed
+	return nil
+}
+
+// chainedReader continues with the next reader at the end of the current one
+type chainedReader struct {
+	io.ReadCloser
+	next func() (io.ReadCloser, error) // nil if there is nothing after the current reader
+}
+
+func (c *chainedReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if !errors.Is(err, io.EOF) || c.next == nil {
+		return n, err
+	}
+	_ = c.ReadCloser.Close()
+	c.ReadCloser, err = c.next()
+	c.next = nil
+	if err != nil {
+		c.ReadCloser = io.NopCloser(eofReader{})
+		return n, err
+	}
+	return n, nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// bitShiftReader reads a stream that starts at the given bit of the first byte of r
+type bitShiftReader struct {
+	r       io.ByteReader
+	shift   uint8
+	cur     byte
+	started bool
+	err     error
+}
+
+func (s *bitShiftReader) ReadByte() (byte, error) {
+	if !s.started {
+		s.started = true
+		s.cur, s.err = s.r.ReadByte()
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	next, err := s.r.ReadByte() // zero at the end of the stream
+	b := s.cur>>s.shift | next<<(8-s.shift)
+	s.cur, s.err = next, err
+	return b, nil
+}
+
+func (s *bitShiftReader) Read(p []byte) (n int, err error) {
+	for ; n < len(p); n++ {
+		p[n], err = s.ReadByte()
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *compressedFile) closeDecoder() {
+	if c.dec != nil {
+		_ = c.dec.Close()
+		c.dec = nil
+	}
+}
+
+func (c *compressedFile) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeDecoder()
+	return c.f.Close()
+}
@@ -0,0 +1,286 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedFile(t *testing.T) {
+	content := strings.Repeat(SampleLog1+SampleLog2, 20)
+	parts := []string{content[:1000], content[1000:5000], content[5000:]} // compressed separately
+	dir := t.TempDir()
+
+	compressGzip := func(parts []string) []byte {
+		var buf bytes.Buffer
+		for _, p := range parts {
+			w := gzip.NewWriter(&buf) // a member per part
+			_, err := w.Write([]byte(p))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+		return buf.Bytes()
+	}
+	compressZstd := func(parts []string) []byte {
+		var buf bytes.Buffer
+		for _, p := range parts {
+			w, err := zstd.NewWriter(&buf) // a frame per part
+			require.NoError(t, err)
+			_, err = w.Write([]byte(p))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+		return buf.Bytes()
+	}
+
+	type test struct {
+		file                string
+		compressed          []byte
+		expectedCheckpoints int
+	}
+	tests := []test{
+		{"single.gz", compressGzip([]string{content}), 1},
+		{"members.gz", compressGzip(parts), 3},
+		{"single.zst", compressZstd([]string{content}), 1},
+		{"frames.zst", compressZstd(parts), 3},
+		{"plain.log", []byte(content), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.file, func(t *testing.T) {
+				path := filepath.Join(dir, tt.file)
+				require.NoError(t, os.WriteFile(path, tt.compressed, 0644))
+
+				opener := NewOpener(nil)
+				if IsCompressed(path) {
+					index, err := opener.SeekIndex(path)
+					require.NoError(t, err)
+					require.Equal(t, len(content), index.Size)
+					require.Len(t, index.Checkpoints, tt.expectedCheckpoints)
+				}
+
+				f, err := opener.OpenFile(path)
+				require.NoError(t, err)
+				defer f.Close()
+				require.Equal(t, len(content), f.Len())
+
+				// random access by uncompressed offsets, forward and backward
+				for _, loc := range []Location{{0, 10}, {990, 1010}, {4000, 6000}, {100, 200}, {len(content) - 5, len(content)}} {
+					buf := make([]byte, loc.Len())
+					_, err = f.ReadAt(buf, int64(loc.From))
+					require.NoError(t, err)
+					require.Equal(t, content[loc.From:loc.To], string(buf))
+				}
+
+				// reading past the end
+				buf := make([]byte, 10)
+				n, err := f.ReadAt(buf, int64(len(content)-5))
+				require.ErrorIs(t, err, io.EOF)
+				require.Equal(t, 5, n)
+			},
+		)
+	}
+}
+
+// logOfSpans makes a log of many checkpoint spans
+func logOfSpans(spans int) string {
+	var sb strings.Builder
+	for i := 0; sb.Len() < spans*checkpointSpan; i++ {
+		_, _ = fmt.Fprintf(&sb, "2024-01-01T00:00:00.%06d+00:00 request %d took %dms user=%x\n", i%1000000, i, i*7%1000, i*31)
+	}
+	return sb.String()
+}
+
+func TestCompressedFileCheckpoints(t *testing.T) {
+	content := logOfSpans(3)
+	dir := t.TempDir()
+
+	compressGzip := func(level int, parts ...string) []byte {
+		var buf bytes.Buffer
+		for _, p := range parts {
+			w, err := gzip.NewWriterLevel(&buf, level)
+			require.NoError(t, err)
+			_, err = w.Write([]byte(p))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+		return buf.Bytes()
+	}
+
+	store := &mapSeekIndexStore{indexes: make(map[string]SeekIndex)}
+	opener := NewOpener(store)
+
+	tests := map[string][]byte{
+		"default.gz": compressGzip(gzip.DefaultCompression, content),
+		"speed.gz":   compressGzip(gzip.BestSpeed, content),
+		"stored.gz":  compressGzip(gzip.NoCompression, content),
+		"members.gz": compressGzip(gzip.DefaultCompression, content[:checkpointSpan/2], content[checkpointSpan/2:]),
+	}
+	for name, compressed := range tests {
+		t.Run(
+			name, func(t *testing.T) {
+				path := filepath.Join(dir, name)
+				require.NoError(t, os.WriteFile(path, compressed, 0644))
+
+				index, err := opener.SeekIndex(path)
+				require.NoError(t, err)
+				require.Equal(t, len(content), index.Size)
+				windows := 0
+				for _, c := range index.Checkpoints {
+					if c.Block {
+						windows++
+					}
+				}
+				require.GreaterOrEqual(t, windows, 2)
+				require.True(t, index.Seekable())
+				require.Contains(t, store.indexes, path)
+
+				f, err := opener.OpenFile(path)
+				require.NoError(t, err)
+				defer f.Close()
+
+				// reads across checkpoints, backward from the end
+				for i := len(index.Checkpoints) - 1; i >= 0; i-- {
+					c := index.Checkpoints[i]
+					loc := Location{From: max(0, c.Uncompressed-100), To: min(len(content), c.Uncompressed+100)}
+					buf := make([]byte, loc.Len())
+					_, err = f.ReadAt(buf, int64(loc.From))
+					require.NoError(t, err)
+					require.Equal(t, content[loc.From:loc.To], string(buf))
+				}
+				// windows are loaded per checkpoint, reads start before checkpoints, so the last window is not needed
+				require.Equal(t, windows-1, store.windowLoads[path])
+
+				// the persisted index is used as long as the file is the same
+				store.indexes[path] = SeekIndex{Size: 1, FileSize: index.FileSize, ModTime: index.ModTime}
+				size, err := opener.UncompressedSize(path)
+				require.NoError(t, err)
+				require.Equal(t, len(content), size) // cached in the process
+				index, err = opener.SeekIndex(path)
+				require.NoError(t, err)
+				require.Equal(t, 1, index.Size)
+
+				// a removed file is not cached anymore
+				opener.Forget(path)
+				store.indexes[path] = SeekIndex{Size: 2, FileSize: index.FileSize, ModTime: index.ModTime}
+				size, err = opener.UncompressedSize(path)
+				require.NoError(t, err)
+				require.Equal(t, 2, size)
+			},
+		)
+	}
+}
+
+func TestZstdCheckpoints(t *testing.T) {
+	content := logOfSpans(3)
+	dir := t.TempDir()
+
+	compressZstd := func(frameLen int) []byte {
+		var buf bytes.Buffer
+		for p := range slices.Chunk([]byte(content), frameLen) {
+			w, err := zstd.NewWriter(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(p)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+		// the seek table of the seekable format is a skippable frame at the end
+		seekTable := []byte{0x5e, 0x2a, 0x4d, 0x18, 4, 0, 0, 0, 1, 2, 3, 4}
+		return append(buf.Bytes(), seekTable...)
+	}
+
+	tests := []struct {
+		file     string
+		frameLen int
+		seekable bool
+	}{
+		{"seekable.zst", 1 << 20, true},
+		{"single.zst", len(content), false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.file, func(t *testing.T) {
+				path := filepath.Join(dir, tt.file)
+				require.NoError(t, os.WriteFile(path, compressZstd(tt.frameLen), 0644))
+
+				opener := NewOpener(nil)
+				index, err := opener.SeekIndex(path)
+				require.NoError(t, err)
+				require.Equal(t, len(content), index.Size)
+				require.Len(t, index.Checkpoints, (len(content)+tt.frameLen-1)/tt.frameLen)
+				require.Equal(t, tt.seekable, index.Seekable())
+
+				f, err := opener.OpenFile(path)
+				require.NoError(t, err)
+				defer f.Close()
+				for i := len(index.Checkpoints) - 1; i >= 0; i-- {
+					c := index.Checkpoints[i]
+					loc := Location{From: max(0, c.Uncompressed-100), To: min(len(content), c.Uncompressed+100)}
+					buf := make([]byte, loc.Len())
+					_, err = f.ReadAt(buf, int64(loc.From))
+					require.NoError(t, err)
+					require.Equal(t, content[loc.From:loc.To], string(buf))
+				}
+			},
+		)
+	}
+}
+
+func TestRecentCache(t *testing.T) {
+	c := newRecentCache[int](2)
+	c.put("a", 1)
+	c.put("b", 2)
+	_, ok := c.get("a") // b is the least recently used now
+	require.True(t, ok)
+	c.put("c", 3)
+	_, ok = c.get("b")
+	require.False(t, ok)
+	v, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	c.delete("a")
+	_, ok = c.get("a")
+	require.False(t, ok)
+	c.put("d", 4)
+	_, ok = c.get("c")
+	require.True(t, ok)
+}
+
+// mapSeekIndexStore returns indexes without windows, like persistent stores
+type mapSeekIndexStore struct {
+	indexes     map[string]SeekIndex
+	windowLoads map[string]int
+}
+
+func (s *mapSeekIndexStore) GetSeekIndex(file string) (SeekIndex, bool, error) {
+	index, ok := s.indexes[file]
+	index.Checkpoints = slices.Clone(index.Checkpoints)
+	for i := range index.Checkpoints {
+		index.Checkpoints[i].Window = nil
+	}
+	return index, ok, nil
+}
+
+func (s *mapSeekIndexStore) GetSeekWindow(file string, checkpoint int) ([]byte, error) {
+	if s.windowLoads == nil {
+		s.windowLoads = make(map[string]int)
+	}
+	s.windowLoads[file]++
+	return s.indexes[file].Checkpoints[checkpoint].Window, nil
+}
+
+func (s *mapSeekIndexStore) PutSeekIndex(file string, index SeekIndex) error {
+	s.indexes[file] = index
+	return nil
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Built-in layouts for Unix epoch timestamps, a fraction is allowed (example: "1722297600.123")
+const (
+	LayoutUnix      = "unix"
+	LayoutUnixMilli = "unix_ms"
+	LayoutUnixMicro = "unix_us"
+	LayoutUnixNano  = "unix_ns"
+)
+
+var epochUnits = map[string]time.Duration{
+	LayoutUnix:      time.Second,
+	LayoutUnixMilli: time.Millisecond,
+	LayoutUnixMicro: time.Microsecond,
+	LayoutUnixNano:  time.Nanosecond,
+}
+
+// DateParser parses dates with a list of layouts tried in order.
+// The layout that matched last is tried first, as files rarely change their format mid-way,
+// so a parser is meant for one file (it is safe for concurrent use).
+type DateParser struct {
+	layouts []string
+	// dates without an offset are in this location
+	loc *time.Location
+	// index of the layout that matched last
+	last atomic.Int32
+}
+
+// NewDateParser makes a parser for Go time layouts (see https://go.dev/src/time/format.go)
+// and built-in epoch layouts ("unix", "unix_ms", "unix_us", "unix_ns").
+// Dates without an offset are in loc (UTC if nil), dates without a year are within the last 12 months.
+func NewDateParser(layouts []string, loc *time.Location) (*DateParser, error) {
+	if len(layouts) == 0 {
+		return nil, errors.New("no date layouts")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &DateParser{layouts: layouts, loc: loc}, nil
+}
+
+// Parse returns the date in UTC
+func (p *DateParser) Parse(b []byte) (time.Time, error) {
+	s := string(b)
+	last := int(p.last.Load())
+	t, firstErr := p.parse(p.layouts[last], s)
+	if firstErr == nil {
+		return t, nil
+	}
+	for i, layout := range p.layouts {
+		if i == last {
+			continue
+		}
+		t, err := p.parse(layout, s)
+		if err == nil {
+			p.last.Store(int32(i))
+			return t, nil
+		}
+	}
+	if len(p.layouts) == 1 {
+		return time.Time{}, firstErr
+	}
+	return time.Time{}, fmt.Errorf("date %q matches none of %d layouts: %w", s, len(p.layouts), firstErr)
+}
+
+func (p *DateParser) parse(layout, s string) (time.Time, error) {
+	if unit, ok := epochUnits[layout]; ok {
+		return parseEpoch(s, unit)
+	}
+	t, err := time.ParseInLocation(layout, s, p.loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t.Year() == 0 {
+		t = withRecentYear(t, time.Now())
+	}
+	return t.UTC(), nil
+}
+
+// withRecentYear puts a date without a year (like in syslog) in the last 12 months before now
+func withRecentYear(t, now time.Time) time.Time {
+	y := time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if y.After(now.AddDate(0, 0, 1)) { // a day of slack for clocks and timezones
+		y = y.AddDate(-1, 0, 0)
+	}
+	return y
+}
+
+// parseEpoch parses the number of units since the Unix epoch, the fraction is truncated to nanoseconds
+func parseEpoch(s string, unit time.Duration) (time.Time, error) {
+	intPart, fracPart, _ := strings.Cut(strings.TrimSpace(s), ".")
+	n, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse epoch %q: %w", s, err)
+	}
+	var frac time.Duration
+	if fracPart != "" {
+		digits := fracPart[:min(len(fracPart), 9)]
+		f, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil || f < 0 {
+			return time.Time{}, fmt.Errorf("parse epoch %q: invalid fraction", s)
+		}
+		scale := time.Duration(1)
+		for range len(digits) {
+			scale *= 10
+		}
+		frac = unit * time.Duration(f) / scale
+		if strings.HasPrefix(intPart, "-") {
+			frac = -frac
+		}
+	}
+	return time.Unix(0, 0).Add(time.Duration(n)*unit + frac).UTC(), nil
+}
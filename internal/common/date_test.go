@@ -0,0 +1,93 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateParser(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		layouts []string
+		loc     *time.Location
+		date    string
+		want    string // RFC3339Nano in UTC, empty if an error is expected
+	}{
+		{"offset", []string{time.RFC3339}, nil, "2024-07-30T02:00:00+02:00", "2024-07-30T00:00:00Z"},
+		{"no offset is UTC", []string{time.DateTime}, nil, "2024-07-30 02:00:00", "2024-07-30T02:00:00Z"},
+		{"no offset in timezone", []string{time.DateTime}, berlin, "2024-07-30 02:00:00", "2024-07-30T00:00:00Z"},
+		{"offset wins timezone", []string{time.RFC3339}, berlin, "2024-07-30T02:00:00Z", "2024-07-30T02:00:00Z"},
+		{"second layout", []string{time.RFC3339, time.DateTime}, nil, "2024-07-30 02:00:00", "2024-07-30T02:00:00Z"},
+		{"no layout matches", []string{time.RFC3339, time.DateTime}, nil, "30/07/2024", ""},
+		{"unix", []string{LayoutUnix}, nil, "1722297600", "2024-07-30T00:00:00Z"},
+		{"unix fraction", []string{LayoutUnix}, nil, "1722297600.25", "2024-07-30T00:00:00.25Z"},
+		{"unix millis", []string{LayoutUnixMilli}, nil, "1722297600123", "2024-07-30T00:00:00.123Z"},
+		{"unix micros", []string{LayoutUnixMicro}, nil, "1722297600123456", "2024-07-30T00:00:00.123456Z"},
+		{"unix nanos", []string{LayoutUnixNano}, nil, "1722297600123456789", "2024-07-30T00:00:00.123456789Z"},
+		{"unix invalid", []string{LayoutUnix}, nil, "2024-07-30", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				p, err := NewDateParser(tt.layouts, tt.loc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := p.Parse([]byte(tt.date))
+				if tt.want == "" {
+					if err == nil {
+						t.Errorf("Parse(%q) = %v, want an error", tt.date, got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Parse(%q) error: %v", tt.date, err)
+				}
+				if got.Location() != time.UTC || got.Format(time.RFC3339Nano) != tt.want {
+					t.Errorf("Parse(%q) = %v, want %s", tt.date, got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestDateParserRemembersLayout(t *testing.T) {
+	p, err := NewDateParser([]string{time.RFC3339, time.DateTime}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, date := range []string{"2024-07-30 02:00:00", "2024-07-30T02:00:00Z", "2024-07-30 03:00:00"} {
+		if _, err := p.Parse([]byte(date)); err != nil {
+			t.Fatalf("Parse(%q) error: %v", date, err)
+		}
+	}
+	if got := p.last.Load(); got != 1 {
+		t.Errorf("the last matched layout is %d, want 1", got)
+	}
+}
+
+func TestWithRecentYear(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date, want string
+	}{
+		{"Mar 10 11:00:00", "2024-03-10T11:00:00Z"},
+		{"Mar 11 11:00:00", "2024-03-11T11:00:00Z"}, // within a day of slack
+		{"Dec 31 23:00:00", "2023-12-31T23:00:00Z"},
+	}
+	for _, tt := range tests {
+		d, err := time.Parse(time.Stamp, tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := withRecentYear(d, now).Format(time.RFC3339); got != tt.want {
+			t.Errorf("withRecentYear(%q) = %s, want %s", tt.date, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// minCodeLen is the min length of numbers indexed as codes (e.g. HTTP statuses) if they are shorter than terms
+	minCodeLen = 3
+	// maxEntityLen limits terms of entities (in runes), longer ones are cut like other terms
+	maxEntityLen = 64
+)
+
+// entityRE finds values that separators would split into fragments (the input is lowercased).
+// Messages and query literals are matched by the same rules, and a query literal is assumed to start a word.
+// So entities are bounded only on the left: the end of an entity in a literal may be followed by more characters
+// in a message ("10.0.0.5" is a prefix of "10.0.0.55"). Paths must not follow a word, so "and/or" is not a path,
+// but they may be glued to other separators ("path:/var/log").
+var entityRE = regexp.MustCompile(
+	`\b(?P<uuid>[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})` +
+		`|\b(?P<ip>\d{1,3}(?:\.\d{1,3}){2}\.\d+)` +
+		`|\b[a-z][a-z0-9+.-]*://(?:[^\s/@"'<>]*@)?(?P<host>[^\s/:?#"'<>]+)(?::\d+)?(?P<urlpath>/[^\s?#"'<>]*)?` +
+		`|(?:^|[^\w/])(?P<path>(?:/[\w.~%+-]+)+)` +
+		`|\b(?P<code>\d+)`,
+)
+
+var (
+	entityHost    = entityRE.SubexpIndex("host")
+	entityURLPath = entityRE.SubexpIndex("urlpath")
+	entityPath    = entityRE.SubexpIndex("path")
+	entityCode    = entityRE.SubexpIndex("code")
+)
+
+// entityTerms returns whole terms of IPs, UUIDs, URLs (the host and the path) and numeric codes in the lowercased input.
+// Paths are also indexed from every segment ("/api/v1" and "/v1"), so any trailing part of a path is found.
+// Codes are numbers of at least minCodeLen digits that are too short to be terms (numbers of minSize are terms).
+// Terms are copied, so the input is not referenced.
+func entityTerms(input []byte, minSize int) [][]byte {
+	var terms [][]byte
+	add := func(term []byte) {
+		terms = append(terms, append([]byte{}, cutRunes(term, maxEntityLen)...))
+	}
+	addPath := func(path []byte) {
+		path = bytes.TrimRight(path, "./")
+		for len(path) > 1 {
+			add(path)
+			next := bytes.IndexByte(path[1:], '/')
+			if next < 0 {
+				break
+			}
+			path = path[next+1:]
+		}
+	}
+
+	for _, m := range entityRE.FindAllSubmatchIndex(input, -1) {
+		group := func(i int) []byte {
+			if m[2*i] < 0 {
+				return nil
+			}
+			return input[m[2*i]:m[2*i+1]]
+		}
+		switch {
+		case group(entityHost) != nil:
+			add(group(entityHost))
+			addPath(group(entityURLPath))
+		case group(entityPath) != nil:
+			addPath(group(entityPath))
+		case group(entityCode) != nil:
+			if code := group(entityCode); len(code) >= minCodeLen && len(code) < minSize {
+				add(code)
+			}
+		default: // uuid, ip
+			add(input[m[0]:m[1]])
+		}
+	}
+	return terms
+}
+
+// cutRunes returns the beginning of b that is at most n runes long
+func cutRunes(b []byte, n int) []byte {
+	cut := 0
+	for i := 0; i < n && cut < len(b); i++ {
+		_, size := utf8.DecodeRune(b[cut:])
+		cut += size
+	}
+	return b[:cut]
+}
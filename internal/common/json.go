@@ -0,0 +1,201 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// JSONField is a scalar value found in a JSON object
+type JSONField struct {
+	// dot-separated keys leading to the value, array elements share the path of the array
+	Path string
+	// location of the value in the input, quotes of strings are excluded
+	Loc Location
+	// the value is a JSON string (it may contain escape sequences)
+	IsString bool
+}
+
+// Value returns the value as text, strings are unescaped
+func (f JSONField) Value(input []byte) string {
+	raw := input[f.Loc.From:f.Loc.To]
+	if !f.IsString || bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw)
+	}
+	var s string
+	quoted := make([]byte, 0, len(raw)+2)
+	quoted = append(append(append(quoted, '"'), raw...), '"')
+	if json.Unmarshal(quoted, &s) != nil {
+		return string(raw)
+	}
+	return s
+}
+
+// ScanJSONFields visits scalar values of the JSON object in the input (a single line of NDJSON).
+// The scan is lenient: it stops at the first syntax error, fields visited before it are reported anyway.
+// An empty value (like in `{"a":,"b":1}`) is visited as an empty scalar, so a message with its date cut out
+// is still scanned. Returns false if the input does not start with a JSON object.
+func ScanJSONFields(input []byte, visit func(f JSONField) bool) bool {
+	s := jsonScanner{in: input, visit: visit}
+	s.skipSpace()
+	if s.pos >= len(s.in) || s.in[s.pos] != '{' {
+		return false
+	}
+	s.object("")
+	return true
+}
+
+// IsJSONObject tells if the input looks like a JSON object (the first non-space byte is "{")
+func IsJSONObject(input []byte) bool {
+	input = bytes.TrimLeft(input, " \t\r\n")
+	return len(input) > 0 && input[0] == '{'
+}
+
+type jsonScanner struct {
+	in      []byte
+	pos     int
+	visit   func(JSONField) bool
+	stopped bool
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.in) && strings.IndexByte(" \t\r\n", s.in[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// expect consumes the byte if it is next in the input
+func (s *jsonScanner) expect(b byte) bool {
+	s.skipSpace()
+	if s.pos < len(s.in) && s.in[s.pos] == b {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// object scans the object at the current position ("{" is next)
+func (s *jsonScanner) object(path string) bool {
+	s.expect('{')
+	if s.expect('}') {
+		return true
+	}
+	for !s.stopped {
+		s.skipSpace()
+		keyLoc, ok := s.string()
+		if !ok || !s.expect(':') {
+			return false
+		}
+		key := JSONField{Loc: keyLoc, IsString: true}.Value(s.in)
+		if path != "" {
+			key = path + "." + key
+		}
+		if !s.value(key) {
+			return false
+		}
+		if s.expect('}') {
+			return true
+		}
+		if !s.expect(',') {
+			return false
+		}
+	}
+	return false
+}
+
+// array scans the array at the current position ("[" is next)
+func (s *jsonScanner) array(path string) bool {
+	s.expect('[')
+	if s.expect(']') {
+		return true
+	}
+	for !s.stopped {
+		if !s.value(path) {
+			return false
+		}
+		if s.expect(']') {
+			return true
+		}
+		if !s.expect(',') {
+			return false
+		}
+	}
+	return false
+}
+
+func (s *jsonScanner) value(path string) bool {
+	s.skipSpace()
+	if s.pos >= len(s.in) {
+		return false
+	}
+	switch s.in[s.pos] {
+	case '{':
+		return s.object(path)
+	case '[':
+		return s.array(path)
+	case '"':
+		loc, ok := s.string()
+		if !ok {
+			return false
+		}
+		s.stopped = !s.visit(JSONField{Path: path, Loc: loc, IsString: true})
+		return true
+	}
+
+	// numbers, booleans and null end at a delimiter
+	from := s.pos
+	for s.pos < len(s.in) && strings.IndexByte(",}] \t\r\n", s.in[s.pos]) < 0 {
+		s.pos++
+	}
+	s.stopped = !s.visit(JSONField{Path: path, Loc: Location{From: from, To: s.pos}})
+	return true
+}
+
+// string scans the string at the current position and returns the location of its content
+func (s *jsonScanner) string() (Location, bool) {
+	if s.pos >= len(s.in) || s.in[s.pos] != '"' {
+		return Location{}, false
+	}
+	from := s.pos + 1
+	for i := from; i < len(s.in); i++ {
+		switch s.in[i] {
+		case '\\':
+			i++ // skip the escaped byte
+		case '"':
+			s.pos = i + 1
+			return Location{From: from, To: i}, true
+		}
+	}
+	return Location{}, false
+}
+
+// TokenizeJSON tokenizes values of a JSON object, so JSON punctuation is not indexed.
+// Keys are tokenized only if indexKeys is set. Input that is not a JSON object is tokenized as text.
+func TokenizeJSON(input []byte, minSize, maxSize int, indexKeys bool) [][]byte {
+	return TokenizeJSONWith(input, func(b []byte) [][]byte { return Tokenize(b, minSize, maxSize) }, indexKeys)
+}
+
+// TokenizeJSONWith is TokenizeJSON with the given tokenizer of values
+func TokenizeJSONWith(input []byte, tokenize func([]byte) [][]byte, indexKeys bool) [][]byte {
+	if !IsJSONObject(input) {
+		return tokenize(input)
+	}
+
+	var (
+		tokens [][]byte
+		keys   = make(map[string]struct{})
+	)
+	ScanJSONFields(
+		input, func(f JSONField) bool {
+			tokens = append(tokens, tokenize([]byte(f.Value(input)))...)
+			if indexKeys {
+				if _, ok := keys[f.Path]; !ok {
+					keys[f.Path] = struct{}{}
+					tokens = append(tokens, tokenize([]byte(f.Path))...)
+				}
+			}
+			return true
+		},
+	)
+	return tokens
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanJSONFields(t *testing.T) {
+	input := []byte(`{"time": "2024-07-30T00:00:04Z", "msg":"say \"hi\"", "ctx":{"id":42,"tags":["a",true]},"empty":{}}`)
+
+	type field struct {
+		path, value string
+	}
+	var fields []field
+	ok := ScanJSONFields(
+		input, func(f JSONField) bool {
+			fields = append(fields, field{f.Path, f.Value(input)})
+			return true
+		},
+	)
+	require.True(t, ok)
+	require.Equal(
+		t, []field{
+			{"time", "2024-07-30T00:00:04Z"},
+			{"msg", `say "hi"`},
+			{"ctx.id", "42"},
+			{"ctx.tags", "a"},
+			{"ctx.tags", "true"},
+		}, fields,
+	)
+
+	// the location points to the raw value
+	ScanJSONFields(
+		input, func(f JSONField) bool {
+			require.Equal(t, "2024-07-30T00:00:04Z", string(input[f.Loc.From:f.Loc.To]))
+			return false
+		},
+	)
+
+	// lenient scanning: values before the broken part are visited
+	fields = nil
+	ScanJSONFields(
+		[]byte(`{"time":,"level":"info","msg":"unterminated`), func(f JSONField) bool {
+			fields = append(fields, field{f.Path, f.Value(input)})
+			return true
+		},
+	)
+	require.Len(t, fields, 2)
+	require.Equal(t, "time", fields[0].path)
+	require.Equal(t, "", fields[0].value)
+
+	require.False(t, ScanJSONFields([]byte(`[1,2]`), func(f JSONField) bool { return true }))
+}
+
+func TestTokenizeJSON(t *testing.T) {
+	input := []byte(`{"level":"error","message":"payment failed","context":{"gateway":"stripe"}}`)
+
+	tokens := TokenizeJSON(input, 4, 10, false)
+	require.ElementsMatch(t, [][]byte{[]byte("error"), []byte("payment"), []byte("failed"), []byte("stripe")}, tokens)
+
+	tokens = TokenizeJSON(input, 4, 10, true)
+	require.ElementsMatch(
+		t, [][]byte{
+			[]byte("error"), []byte("level"),
+			[]byte("payment"), []byte("failed"), []byte("message"),
+			[]byte("stripe"), []byte("context"), []byte("gateway"),
+		}, tokens,
+	)
+
+	// not a JSON object
+	require.Equal(t, Tokenize([]byte("plain text"), 4, 10), TokenizeJSON([]byte("plain text"), 4, 10, false))
+}
@@ -47,30 +47,30 @@ Estimated duration: 30 minutes
 `
 
 var LayoutsSampleLog1 = []Message{
-	{MessageLayout{Loc: Location{1, 78}, DateLoc: Location{2, 34}}, MakeTimeV("2024-07-29T00:02:49.231231+00:00")},
-	{MessageLayout{Loc: Location{78, 134}, DateLoc: Location{79, 111}}, MakeTimeV("2024-07-29T01:07:21.923832+00:00")},
-	{MessageLayout{Loc: Location{134, 235}, DateLoc: Location{135, 167}}, MakeTimeV("2024-07-29T01:11:38.258712+00:00")},
-	{MessageLayout{Loc: Location{235, 302}, DateLoc: Location{236, 268}}, MakeTimeV("2024-07-30T00:12:22.799234+00:00")},
-	{MessageLayout{Loc: Location{302, 365}, DateLoc: Location{303, 335}}, MakeTimeV("2024-07-30T01:16:57.293873+00:00")},
-	{MessageLayout{Loc: Location{365, 418}, DateLoc: Location{366, 398}}, MakeTimeV("2024-07-30T02:20:36.908172+00:00")},
-	{MessageLayout{Loc: Location{418, 469}, DateLoc: Location{419, 451}}, MakeTimeV("2024-07-30T03:24:47.245671+00:00")},
-	{MessageLayout{Loc: Location{469, 522}, DateLoc: Location{470, 502}}, MakeTimeV("2024-07-30T04:25:27.789664+00:00")},
-	{MessageLayout{Loc: Location{522, 571}, DateLoc: Location{523, 555}}, MakeTimeV("2024-07-30T05:28:56.918273+00:00")},
-	{MessageLayout{Loc: Location{571, 623}, DateLoc: Location{572, 604}}, MakeTimeV("2024-07-30T06:29:23.685562+00:00")},
+	{MessageLayout{Loc: Location{1, 78}, DateLoc: Location{2, 34}}, MakeTimeV("2024-07-29T00:02:49.231231+00:00"), nil},
+	{MessageLayout{Loc: Location{78, 134}, DateLoc: Location{79, 111}}, MakeTimeV("2024-07-29T01:07:21.923832+00:00"), nil},
+	{MessageLayout{Loc: Location{134, 235}, DateLoc: Location{135, 167}}, MakeTimeV("2024-07-29T01:11:38.258712+00:00"), nil},
+	{MessageLayout{Loc: Location{235, 302}, DateLoc: Location{236, 268}}, MakeTimeV("2024-07-30T00:12:22.799234+00:00"), nil},
+	{MessageLayout{Loc: Location{302, 365}, DateLoc: Location{303, 335}}, MakeTimeV("2024-07-30T01:16:57.293873+00:00"), nil},
+	{MessageLayout{Loc: Location{365, 418}, DateLoc: Location{366, 398}}, MakeTimeV("2024-07-30T02:20:36.908172+00:00"), nil},
+	{MessageLayout{Loc: Location{418, 469}, DateLoc: Location{419, 451}}, MakeTimeV("2024-07-30T03:24:47.245671+00:00"), nil},
+	{MessageLayout{Loc: Location{469, 522}, DateLoc: Location{470, 502}}, MakeTimeV("2024-07-30T04:25:27.789664+00:00"), nil},
+	{MessageLayout{Loc: Location{522, 571}, DateLoc: Location{523, 555}}, MakeTimeV("2024-07-30T05:28:56.918273+00:00"), nil},
+	{MessageLayout{Loc: Location{571, 623}, DateLoc: Location{572, 604}}, MakeTimeV("2024-07-30T06:29:23.685562+00:00"), nil},
 }
 var LayoutsSampleLog2 = []Message{
-	{MessageLayout{Loc: Location{1, 101}, DateLoc: Location{2, 34}}, MakeTimeV("2024-07-31T00:02:49.231231+00:00")},
-	{MessageLayout{Loc: Location{101, 208}, DateLoc: Location{102, 134}}, MakeTimeV("2024-07-31T01:07:21.923832+00:00")},
-	{MessageLayout{Loc: Location{208, 334}, DateLoc: Location{209, 241}}, MakeTimeV("2024-07-31T01:11:38.258712+00:00")},
-	{MessageLayout{Loc: Location{334, 438}, DateLoc: Location{335, 367}}, MakeTimeV("2024-07-31T02:15:22.799234+00:00")},
-	{MessageLayout{Loc: Location{438, 547}, DateLoc: Location{439, 471}}, MakeTimeV("2024-07-31T03:20:57.293873+00:00")},
-	{MessageLayout{Loc: Location{547, 646}, DateLoc: Location{548, 580}}, MakeTimeV("2024-07-31T04:25:36.908172+00:00")},
-	{MessageLayout{Loc: Location{646, 764}, DateLoc: Location{647, 679}}, MakeTimeV("2024-07-31T05:30:47.245671+00:00")},
+	{MessageLayout{Loc: Location{1, 101}, DateLoc: Location{2, 34}}, MakeTimeV("2024-07-31T00:02:49.231231+00:00"), nil},
+	{MessageLayout{Loc: Location{101, 208}, DateLoc: Location{102, 134}}, MakeTimeV("2024-07-31T01:07:21.923832+00:00"), nil},
+	{MessageLayout{Loc: Location{208, 334}, DateLoc: Location{209, 241}}, MakeTimeV("2024-07-31T01:11:38.258712+00:00"), nil},
+	{MessageLayout{Loc: Location{334, 438}, DateLoc: Location{335, 367}}, MakeTimeV("2024-07-31T02:15:22.799234+00:00"), nil},
+	{MessageLayout{Loc: Location{438, 547}, DateLoc: Location{439, 471}}, MakeTimeV("2024-07-31T03:20:57.293873+00:00"), nil},
+	{MessageLayout{Loc: Location{547, 646}, DateLoc: Location{548, 580}}, MakeTimeV("2024-07-31T04:25:36.908172+00:00"), nil},
+	{MessageLayout{Loc: Location{646, 764}, DateLoc: Location{647, 679}}, MakeTimeV("2024-07-31T05:30:47.245671+00:00"), nil},
 }
 
 func MakeFileMessages(file string, messages []Message) (fm []FileMessage) {
 	for _, m := range messages {
-		fm = append(fm, FileMessage{file, m})
+		fm = append(fm, FileMessage{File: file, Message: m})
 	}
 	return
 }
@@ -2,12 +2,146 @@ package common
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"unicode/utf8"
 )
 
-// Tokenizer splits a sequence of bytes in normalized tokens
-type Tokenizer func([]byte, int, int) [][]byte
+// TermStrategy tells which terms of words are indexed, it decides what parts of words queries can find in the index.
+type TermStrategy string
+
+const (
+	// TermsPrefix indexes words cut to the max length, queries find words by their beginning
+	TermsPrefix TermStrategy = "prefix"
+	// TermsSuffix indexes all suffixes of words (cut to the max length), so queries find any part of a word.
+	// The index is several times larger.
+	TermsSuffix TermStrategy = "suffix"
+	// TermsNgram indexes n-grams of words, queries find any part of a word that is at least n runes long
+	TermsNgram TermStrategy = "ngram"
+)
+
+// Tokenizer splits messages into normalized terms of the strategy.
+// Terms of a query are looked up in the index by prefix, messages that contain the query have all of them.
+type Tokenizer struct {
+	TokenizerOptions
+	seps map[rune]struct{}
+}
+
+// TokenizerOptions describe terms of a Tokenizer
+type TokenizerOptions struct {
+	Strategy TermStrategy
+	// characters that separate words, the default set if empty
+	Separators string
+	// length of n-grams of the ngram strategy (in runes)
+	Ngram int
+	// IPs, UUIDs, URLs and numeric codes are also indexed as whole terms regardless of separators and lengths
+	Entities bool
+	// prefixes and suffixes are MinSize..MaxSize runes long
+	MinSize, MaxSize int
+}
+
+func NewTokenizer(opts TokenizerOptions) (*Tokenizer, error) {
+	if opts.MinSize <= 0 || opts.MinSize > opts.MaxSize {
+		return nil, fmt.Errorf("invalid term length: min %d, max %d", opts.MinSize, opts.MaxSize)
+	}
+	t := &Tokenizer{TokenizerOptions: opts, seps: sepRunesSet}
+	switch opts.Strategy {
+	case TermsPrefix, TermsSuffix:
+	case TermsNgram:
+		if opts.Ngram < 2 {
+			return nil, fmt.Errorf("invalid n-gram length: %d", opts.Ngram)
+		}
+	default:
+		return nil, fmt.Errorf("unknown term strategy %q", opts.Strategy)
+	}
+	if opts.Separators != "" {
+		t.seps = make(map[rune]struct{}, len(opts.Separators))
+		for _, r := range opts.Separators {
+			t.seps[r] = struct{}{}
+		}
+	}
+	return t, nil
+}
+
+// Index returns terms of the input to put in the index
+func (t *Tokenizer) Index(input []byte) [][]byte {
+	input = bytes.ToLower(input)
+	words := splitBy(input, t.seps)
+	var terms [][]byte
+	switch t.Strategy {
+	case TermsSuffix:
+		terms = filterShortTokensInPlaceCutLongTokens(suffixes(words), t.MinSize, t.MaxSize)
+	case TermsNgram:
+		terms = ngrams(words, t.Ngram)
+	default:
+		terms = filterShortTokensInPlaceCutLongTokens(words, t.MinSize, t.MaxSize)
+	}
+	if t.Entities {
+		terms = append(terms, entityTerms(input, t.MinSize)...)
+	}
+	return terms
+}
+
+// Query returns terms to look up in the index for the literal.
+// No terms means that the index can't tell which messages contain the literal.
+func (t *Tokenizer) Query(literal []byte) [][]byte {
+	literal = bytes.ToLower(literal)
+	words := splitBy(literal, t.seps)
+	var terms [][]byte
+	if t.Strategy == TermsNgram {
+		terms = ngrams(words, t.Ngram)
+	} else {
+		// words are looked up by prefix, with suffixes indexed any part of a word is a prefix of one of them
+		terms = filterShortTokensInPlaceCutLongTokens(words, t.MinSize, t.MaxSize)
+	}
+	if t.Entities {
+		terms = append(terms, entityTerms(literal, t.MinSize)...)
+	}
+	return terms
+}
+
+// ShortIndex returns parts of words that are too short to be terms, they go to filters of segments (see BloomFilter).
+// With the prefix strategy these are beginnings of words, otherwise any parts of words.
+// Terms reference a lowercased copy of the input.
+func (t *Tokenizer) ShortIndex(input []byte) [][]byte {
+	short := t.shortLen()
+	var terms [][]byte
+	for _, w := range splitBy(bytes.ToLower(input), t.seps) {
+		starts := []int{0}
+		if t.Strategy != TermsPrefix {
+			starts = runeStarts(w)
+		}
+		for _, from := range starts {
+			to := from
+			for n := 1; n < short && to < len(w); n++ {
+				_, size := utf8.DecodeRune(w[to:])
+				to += size
+				terms = append(terms, w[from:to])
+			}
+		}
+	}
+	return terms
+}
+
+// ShortQuery returns words of the literal that are too short to be terms (see ShortIndex)
+func (t *Tokenizer) ShortQuery(literal []byte) [][]byte {
+	short := t.shortLen()
+	var words [][]byte
+	for _, w := range splitBy(bytes.ToLower(literal), t.seps) {
+		if utf8.RuneCount(w) < short {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// shortLen is the length of words (in runes) from which they are terms of the strategy
+func (t *Tokenizer) shortLen() int {
+	if t.Strategy == TermsNgram {
+		return t.Ngram
+	}
+	return t.MinSize
+}
 
 var (
 	seps        = " \r\n\t!()-[]{};:`'\"\\,<>./?@#$%^&*_~"
@@ -72,17 +206,64 @@ func filterShortTokensInPlaceCutLongTokens(tokens [][]byte, minRunes, maxRunes i
 // It returns nil if the input slice is empty. The function uses bytes.FieldsFunc
 // with a separator set defined in sepRunesSet to split the input into tokens.
 func split(s []byte) [][]byte {
+	return splitBy(s, sepRunesSet)
+}
+
+// splitBy divides a byte slice into tokens using the given separators, nil if the input is empty.
+func splitBy(s []byte, seps map[rune]struct{}) [][]byte {
 	if len(s) == 0 {
 		return nil
 	}
 
 	f := func(r rune) bool {
-		_, ok := sepRunesSet[r]
+		_, ok := seps[r]
 		return ok
 	}
 	return bytes.FieldsFunc(s, f)
 }
 
+// suffixes returns all suffixes of the words (words included), they start at every rune.
+func suffixes(words [][]byte) [][]byte {
+	var out [][]byte
+	for _, w := range words {
+		for i := 0; i < len(w); {
+			out = append(out, w[i:])
+			_, size := utf8.DecodeRune(w[i:])
+			i += size
+		}
+	}
+	return out
+}
+
+// runeStarts returns positions of runes in the word
+func runeStarts(w []byte) []int {
+	var starts []int
+	for i := range string(w) {
+		starts = append(starts, i)
+	}
+	return starts
+}
+
+// ngrams returns all n-grams of the words (n runes long), words shorter than n have none.
+// N-grams are copied, so the words are not referenced.
+func ngrams(words [][]byte, n int) [][]byte {
+	var (
+		out    [][]byte
+		starts []int // positions of runes in the word
+	)
+	for _, w := range words {
+		starts = starts[:0]
+		for i := range string(w) {
+			starts = append(starts, i)
+		}
+		starts = append(starts, len(w))
+		for i := 0; i+n < len(starts); i++ {
+			out = append(out, append([]byte{}, w[starts[i]:starts[i+n]]...))
+		}
+	}
+	return out
+}
+
 // filterDuplicatedTokensInPlaceNoAlloc removes duplicate tokens from the input slice without
 // allocating additional memory. It modifies the input slice in-place and returns a new slice
 // that shares the same underlying array but only contains unique tokens.
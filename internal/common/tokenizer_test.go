@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -101,6 +102,130 @@ func TestTokenizer(t *testing.T) {
 	}
 }
 
+func TestTokenizerStrategies(t *testing.T) {
+	type test struct {
+		strategy      TermStrategy
+		separators    string
+		entities      bool
+		input, query  string
+		indexed       []string
+		queried       []string
+		expectedError bool
+	}
+	tests := []test{
+		{
+			strategy: TermsPrefix,
+			input:    "localhost:80 Permission",
+			indexed:  []string{"localhos", "permissi"},
+			query:    "host",
+			queried:  []string{"host"},
+		},
+		{
+			strategy: TermsSuffix,
+			input:    "localhost Ошибка",
+			indexed: []string{
+				"localhos", "ocalhost", "calhost", "alhost", "lhost", "host", "ошибка", "шибка", "ибка",
+			},
+			query:   "calho",
+			queried: []string{"calho"},
+		},
+		{
+			strategy: TermsNgram,
+			input:    "Host ab",
+			indexed:  []string{"hos", "ost"},
+			query:    "lhost ab",
+			queried:  []string{"lho", "hos", "ost"},
+		},
+		{
+			strategy:   TermsPrefix,
+			separators: " =",
+			input:      "user_id=some.value",
+			indexed:    []string{"user_id", "some.val"},
+			query:      "some.value",
+			queried:    []string{"some.val"},
+		},
+		{
+			strategy: TermsPrefix,
+			entities: true,
+			input:    "GET /api/v1/orders from 10.0.12.7: 502",
+			indexed:  []string{"orders", "from", "/api/v1/orders", "/v1/orders", "/orders", "10.0.12.7", "502"},
+			query:    "10.0.12.7",
+			queried:  []string{"10.0.12.7"},
+		},
+		{
+			strategy: TermsNgram,
+			entities: true,
+			input:    "ip 10.0.12.7",
+			indexed:  []string{"10.0.12.7"},
+			query:    "10.0.12.7",
+			queried:  []string{"10.0.12.7"},
+		},
+		{strategy: "unknown", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			string(tt.strategy)+" "+tt.input, func(t *testing.T) {
+				tokenizer, err := NewTokenizer(
+					TokenizerOptions{
+						Strategy:   tt.strategy,
+						Separators: tt.separators,
+						Ngram:      3,
+						Entities:   tt.entities,
+						MinSize:    4,
+						MaxSize:    8,
+					},
+				)
+				if tt.expectedError {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+
+				asStrings := func(tokens [][]byte) (out []string) {
+					for _, t := range tokens {
+						out = append(out, string(t))
+					}
+					return
+				}
+				require.Equal(t, tt.indexed, asStrings(tokenizer.Index([]byte(tt.input))))
+				require.Equal(t, tt.queried, asStrings(tokenizer.Query([]byte(tt.query))))
+			},
+		)
+	}
+}
+
+func TestEntityTerms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"from 10.0.12.7:8080 to 192.168.1.255.", []string{"10.0.12.7", "192.168.1.255"}},
+		{"request 6f85c55a-2b1e-4c7d-9f3a-0123456789ab failed", []string{"6f85c55a-2b1e-4c7d-9f3a-0123456789ab"}},
+		{
+			"fetch https://user@example.com:8443/api/v1/items?id=1 done",
+			[]string{"example.com", "/api/v1/items", "/v1/items", "/items"},
+		},
+		{"see /var/log/app.log.", []string{"/var/log/app.log", "/log/app.log", "/app.log"}},
+		{"opened path:/var/log ./tmp/x", []string{"/var/log", "/log", "/tmp/x", "/x"}},
+		{"from 10.0.0.5123 code 502ms", []string{"10.0.0.5123", "502"}},
+		{"and/or 1/2", nil},
+		{"status=500 took 12 ms, id 12345 code 4040", []string{"500"}},
+		{"/" + strings.Repeat("a", 100), []string{"/" + strings.Repeat("a", maxEntityLen-1)}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.input, func(t *testing.T) {
+				var terms []string
+				for _, term := range entityTerms([]byte(tt.input), 4) {
+					terms = append(terms, string(term))
+				}
+				require.Equal(t, tt.expected, terms)
+			},
+		)
+	}
+}
+
 var tokens [][]byte
 
 func BenchmarkTokenize(b *testing.B) {
@@ -124,3 +249,51 @@ The purpose of a tool is to enable and facilitate thought; not to replace it.
 
 	log.Printf("found %d tokens", len(tokens))
 }
+
+func TestTokenizerShortTerms(t *testing.T) {
+	tests := []struct {
+		strategy     TermStrategy
+		input, query string
+		indexed      []string
+		queried      []string
+	}{
+		{
+			strategy: TermsPrefix,
+			input:    "Job 42 failed",
+			indexed:  []string{"j", "jo", "job", "4", "42", "f", "fa", "fai"},
+			query:    "job 42 fail",
+			queried:  []string{"job", "42"},
+		},
+		{
+			strategy: TermsSuffix,
+			input:    "Jöb",
+			indexed:  []string{"j", "jö", "jöb", "ö", "öb", "b"},
+			query:    "öb",
+			queried:  []string{"öb"},
+		},
+		{
+			strategy: TermsNgram,
+			input:    "job",
+			indexed:  []string{"j", "jo", "o", "ob", "b"},
+			query:    "ob jobs",
+			queried:  []string{"ob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			string(tt.strategy), func(t *testing.T) {
+				tokenizer, err := NewTokenizer(TokenizerOptions{Strategy: tt.strategy, Ngram: 3, MinSize: 4, MaxSize: 8})
+				require.NoError(t, err)
+
+				asStrings := func(tokens [][]byte) (out []string) {
+					for _, t := range tokens {
+						out = append(out, string(t))
+					}
+					return
+				}
+				require.Equal(t, tt.indexed, asStrings(tokenizer.ShortIndex([]byte(tt.input))))
+				require.Equal(t, tt.queried, asStrings(tokenizer.ShortQuery([]byte(tt.query))))
+			},
+		)
+	}
+}
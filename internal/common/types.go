@@ -2,8 +2,36 @@ package common
 
 import "time"
 
+// Segment is an indexed range of a file
 type Segment struct {
 	Location
+	DateErrors DateErrors // messages of the segment whose dates could not be parsed
+	// terms of the messages that are too short for the inverted index, nil if the segment has no filter
+	ShortTerms BloomFilter
+}
+
+// DateErrors counts messages whose dates could not be parsed, the first failure is kept as a sample
+type DateErrors struct {
+	Count  int    `json:"count"`
+	Sample string `json:"sample"` // the date of the first failed message
+	Error  string `json:"error"`  // the parse error of the first failed message
+}
+
+// Add counts a failure, the first one becomes the sample
+func (e *DateErrors) Add(date []byte, err error) {
+	if e.Count == 0 {
+		e.Sample, e.Error = string(date), err.Error()
+	}
+	e.Count++
+}
+
+// DegradedFile is an indexed file with messages whose dates could not be parsed
+type DegradedFile struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+	DateErrors
+	// indexing of the file stopped at the failure (see DateErrors for the sample, read failures have none)
+	Blacklisted bool `json:"blacklisted"`
 }
 
 type FileMessageBody struct {
@@ -12,26 +40,35 @@ type FileMessageBody struct {
 }
 
 type FileMessage struct {
-	File string
+	File   string
+	Source string // the source of the file
 	Message
 }
 
 type Message struct {
 	MessageLayout
-	Date time.Time
+	Date   time.Time
+	Fields map[string]string // values of named capture groups, nil if none
 }
 
 type MessageLayout struct {
-	Loc     Location // body in the stream
-	DateLoc Location // date in the stream
+	Loc       Location            // body in the stream
+	DateLoc   Location            // date in the stream
+	FieldLocs map[string]Location // named capture groups in the stream
 }
 
 type UserQuery struct {
-	Query    string     `json:"query"`
-	FromDate *time.Time `json:"fromDate"`
-	ToDate   *time.Time `json:"toDate"`
+	Query    string       `json:"query"`
+	Sources  []string     `json:"sources"` // empty = all sources
+	Fields   FieldsFilter `json:"fields"`  // empty = no filter
+	FromDate *time.Time   `json:"fromDate"`
+	ToDate   *time.Time   `json:"toDate"`
 }
 
+// FieldsFilter selects messages by their fields: a message must have one of the listed values for every field.
+// A field without values only requires the message to have the field.
+type FieldsFilter map[string][]string
+
 type SearchResult struct {
 	UserQuery
 	Id        int       `json:"id"` // query id
@@ -39,3 +76,26 @@ type SearchResult struct {
 	CreatedAt time.Time `json:"createdAt"` // created at
 	Finished  bool      `json:"finished"`
 }
+
+// Fingerprint identifies a file regardless of its path, so renamed and rewritten files can be told apart.
+type Fingerprint struct {
+	Inode, Device uint64 // zero if the OS does not support them
+	HeadLen       int    // how many bytes from the beginning of the file are hashed
+	HeadHash      uint64
+}
+
+// IsZero reports that the fingerprint is unknown (e.g. the file was indexed before fingerprints existed)
+func (f Fingerprint) IsZero() bool { return f == Fingerprint{} }
+
+// SameIdentity reports that both fingerprints point to the same file on the same device
+func (f Fingerprint) SameIdentity(f2 Fingerprint) bool {
+	return f.Inode != 0 && f.Inode == f2.Inode && f.Device == f2.Device
+}
+
+// IndexedFile describes a file known to the index
+type IndexedFile struct {
+	Source      string
+	Fingerprint Fingerprint
+	// indexing of the file stopped at a failure, it is cleared when the file changes or a segment is indexed
+	Blacklisted bool
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"iter"
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"slices"
 	"syscall"
 	"time"
 
@@ -41,11 +43,12 @@ func RepeatEvery(ctx context.Context, interval time.Duration, f func()) {
 // It efficiently reads message contents from files by reusing file handles when possible.
 func ReadMessages(
 	ctx context.Context,
+	opener *Opener,
 	bufPool *BufferPool,
 	messages iter.Seq[FileMessage],
 ) iter.Seq2[FileMessageBody, error] {
 	var (
-		stream     *mmap.ReaderAt
+		stream     io.ReaderAt
 		streamName string
 		err        error
 
@@ -54,11 +57,14 @@ func ReadMessages(
 	)
 
 	return func(yield func(FileMessageBody, error) bool) {
-		defer func() {
-			if stream != nil {
-				stream.Close()
+		decoders := &openDecoders{opener: opener}
+		defer decoders.close()
+		closeStream := func() {
+			if c, ok := stream.(io.Closer); ok && !IsCompressed(streamName) {
+				c.Close()
 			}
-		}()
+		}
+		defer closeStream()
 
 		for m := range messages {
 			if ctx.Err() != nil {
@@ -66,14 +72,16 @@ func ReadMessages(
 			}
 
 			counter++
-			shouldReleaseMmap = counter%1000 == 0
+			shouldReleaseMmap = counter%1000 == 0 && !IsCompressed(m.File)
 
 			if streamName != m.File || shouldReleaseMmap {
-				if stream != nil {
-					stream.Close()
-				}
+				closeStream()
 				streamName = m.File
-				stream, err = mmap.Open(streamName)
+				if IsCompressed(streamName) {
+					stream, err = decoders.get(streamName)
+				} else {
+					stream, err = openMmap(streamName)
+				}
 				if err != nil {
 					yield(FileMessageBody{}, fmt.Errorf("file open %s: %w", m.File, err))
 					return
@@ -98,6 +106,53 @@ func ReadMessages(
 	}
 
 }
+
+// maxOpenDecoders limits compressed files that are kept open while reading messages
+const maxOpenDecoders = 8
+
+// openDecoders keeps compressed files open while messages are read,
+// so reading the next message of a file continues decompression instead of restarting it.
+type openDecoders struct {
+	opener *Opener
+	files  []File // the least recently used first
+	names  []string
+}
+
+func (d *openDecoders) get(file string) (File, error) {
+	if i := slices.Index(d.names, file); i >= 0 {
+		f := d.files[i]
+		d.files = append(slices.Delete(d.files, i, i+1), f)
+		d.names = append(slices.Delete(d.names, i, i+1), file)
+		return f, nil
+	}
+	f, err := d.opener.OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.files) == maxOpenDecoders {
+		_ = d.files[0].Close()
+		d.files, d.names = d.files[1:], d.names[1:]
+	}
+	d.files, d.names = append(d.files, f), append(d.names, file)
+	return f, nil
+}
+
+func (d *openDecoders) close() {
+	for _, f := range d.files {
+		_ = f.Close()
+	}
+	d.files, d.names = nil, nil
+}
+
+// openMmap maps a plain file to memory.
+func openMmap(file string) (io.ReaderAt, error) {
+	r, err := mmap.Open(file)
+	if err != nil {
+		return nil, err // keep the interface nil
+	}
+	return r, nil
+}
+
 func ToFileMessages(messages iter.Seq[FileMessageBody]) iter.Seq[FileMessage] {
 	return func(yield func(FileMessage) bool) {
 		for m := range messages {
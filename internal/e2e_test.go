@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"regexp"
 	"slices"
@@ -26,6 +27,7 @@ func TestSearch(t *testing.T) {
 	testCases := []struct {
 		name     string
 		query    string
+		sources  []string
 		dates    [2]*time.Time
 		expected []common.FileMessage
 	}{
@@ -52,6 +54,15 @@ func TestSearch(t *testing.T) {
 				fileMessages[fileNames[1]][6],
 			},
 		},
+		{
+			name:    "hits in one source",
+			query:   `backup`,
+			sources: []string{"worker"},
+			dates:   [2]*time.Time{nil, nil},
+			expected: []common.FileMessage{
+				fileMessages[fileNames[1]][6],
+			},
+		},
 		{
 			name:  "regexp case-insensitive",
 			query: `~conn`,
@@ -85,10 +96,13 @@ func TestSearch(t *testing.T) {
 				expr, err := query_language.ParseUserQuery(tc.query)
 				require.NoError(t, err)
 
-				messages, err := _search.Search(expr, tc.dates[0], tc.dates[1])
+				messages, err := _search.Search(expr, tc.sources, nil, tc.dates[0], tc.dates[1])
 				require.NoError(t, err)
 
-				actualMessages := slices.Collect(messages)
+				// matching runs concurrently, so messages arrive out of order
+				actualMessages := slices.SortedFunc(
+					messages, func(a, b common.FileMessageBody) int { return a.Date.Compare(b.Date) },
+				)
 				require.Equal(t, len(tc.expected), len(actualMessages))
 				for i, m := range actualMessages {
 					require.Equal(t, tc.expected[i], m.FileMessage)
@@ -99,7 +113,132 @@ func TestSearch(t *testing.T) {
 
 }
 
+func TestSearchInfix(t *testing.T) {
+	tests := []struct {
+		strategy common.TermStrategy
+		expected int
+	}{
+		{common.TermsPrefix, 0}, // "permission" is indexed as "permissi", the part in the middle is not found
+		{common.TermsSuffix, 1},
+		{common.TermsNgram, 1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			string(tt.strategy), func(t *testing.T) {
+				tokenizer, err := common.NewTokenizer(
+					common.TokenizerOptions{Strategy: tt.strategy, Ngram: 3, MinSize: 4, MaxSize: 8},
+				)
+				require.NoError(t, err)
+				ingestor, _search, _, fileMessages := prepareIndexWith(t, tokenizer)
+				require.NoError(t, ingestor.Run())
+
+				expr, err := query_language.ParseUserQuery("MISSION")
+				require.NoError(t, err)
+				messages, err := _search.Search(expr, nil, nil, nil, nil)
+				require.NoError(t, err)
+				found := slices.Collect(common.ToFileMessages(messages))
+				require.Len(t, found, tt.expected)
+				for _, m := range found {
+					require.Equal(t, fileMessages[m.File][7], m)
+				}
+			},
+		)
+	}
+}
+
+func TestSearchEntities(t *testing.T) {
+	tokenizer, err := common.NewTokenizer(
+		common.TokenizerOptions{Strategy: common.TermsPrefix, Entities: true, MinSize: 4, MaxSize: 8},
+	)
+	require.NoError(t, err)
+	ingestor, _search, fileNames, fileMessages := prepareIndexWith(t, tokenizer)
+
+	// entities glued to preceding tokens are indexed the same as separate ones
+	appendMessage := func(file, source, date, text string) common.FileMessage {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		line := "[" + date + "] " + text + "\n"
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(line)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		from := int(info.Size())
+		return common.FileMessage{
+			File:   file,
+			Source: source,
+			Message: common.Message{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: from, To: from + len(line)},
+					DateLoc: common.Location{From: from + 1, To: from + 1 + len(date)},
+				},
+				Date: common.MakeTimeV(date),
+			},
+		}
+	}
+	separate := appendMessage(fileNames[0], "app", "2024-07-30T07:00:00.000000+00:00", "rotated /var/log/app.log")
+	glued := appendMessage(
+		fileNames[1], "worker", "2024-07-31T06:00:00.000000+00:00", "opened path:/var/log/app.log from peer=10.0.0.7:443",
+	)
+	require.NoError(t, ingestor.Run())
+
+	tests := map[string][]common.FileMessage{
+		"10.0.0.55":    {fileMessages[fileNames[1]][4]},
+		"127":          {fileMessages[fileNames[1]][2], fileMessages[fileNames[0]][0]}, // a line number and an IP
+		"10.0.0.5":     {fileMessages[fileNames[1]][4]},                                // the beginning of the IP
+		"/var/log":     {separate, glued},
+		"/log/app.log": {separate, glued},
+		"10.0.0.7":     {glued},
+	}
+	for query, expected := range tests {
+		t.Run(
+			query, func(t *testing.T) {
+				expr, err := query_language.ParseUserQuery(query)
+				require.NoError(t, err)
+				messages, err := _search.Search(expr, nil, nil, nil, nil)
+				require.NoError(t, err)
+				// matching runs concurrently, so messages arrive out of order
+				require.ElementsMatch(t, expected, slices.Collect(common.ToFileMessages(messages)))
+			},
+		)
+	}
+}
+
+func TestSearchShortTerms(t *testing.T) {
+	ingestor, _search, fileNames, fileMessages := prepareIndex(t)
+	require.NoError(t, ingestor.Run())
+
+	tests := map[string][]common.FileMessage{
+		"99.9":     {fileMessages[fileNames[1]][3]},
+		"IP: 10.0": {fileMessages[fileNames[1]][4]},
+		"job":      nil,
+	}
+	for query, expected := range tests {
+		t.Run(
+			query, func(t *testing.T) {
+				expr, err := query_language.ParseUserQuery(query)
+				require.NoError(t, err)
+				messages, err := _search.Search(expr, nil, nil, nil, nil)
+				require.NoError(t, err)
+				// matching runs concurrently, so messages arrive out of order
+				require.ElementsMatch(t, expected, slices.Collect(common.ToFileMessages(messages)))
+			},
+		)
+	}
+}
+
 func prepareIndex(t *testing.T) (*ingest.Ingestor, *search.Search, []string, map[string][]common.FileMessage) {
+	tokenizer, err := common.NewTokenizer(common.TokenizerOptions{Strategy: common.TermsPrefix, MinSize: 4, MaxSize: 8})
+	require.NoError(t, err)
+	return prepareIndexWith(t, tokenizer)
+}
+
+// prepareIndexWith makes the index of two sample files with the tokenizer
+func prepareIndexWith(
+	t *testing.T,
+	tokenizer *common.Tokenizer,
+) (*ingest.Ingestor, *search.Search, []string, map[string][]common.FileMessage) {
 	dir := t.TempDir()
 	testFile1 := filepath.Join(dir, "test1.log")
 	testFile2 := filepath.Join(dir, "test2.log")
@@ -119,35 +258,58 @@ func prepareIndex(t *testing.T) (*ingest.Ingestor, *search.Search, []string, map
 	ii, err := inverted_index_2.NewInvertedIndex(dir, false)
 	require.NoError(t, err)
 
-	tokenize := func(b []byte) [][]byte { return common.Tokenize(b, 4, 8) }
+	opener := common.NewOpener(duck)
 	indexer := ingest.NewIndexer(
 		context.Background(),
 		logger,
-		tokenize,
-		func(b []byte) (time.Time, error) {
-			return time.Parse(common.TimeFormat, string(b))
+		tokenizer.Index,
+		tokenizer.ShortIndex,
+		func() func([]byte) (time.Time, error) {
+			return func(b []byte) (time.Time, error) {
+				return time.Parse(common.TimeFormat, string(b))
+			}
 		},
+		ingest.DateErrorSkip,
+		1,
+		opener,
 	)
+	scanner := ingest.NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), opener)
 
 	persistentIndex, err := persistence.NewPersistentIndex(duck, ii)
 	require.NoError(t, err)
 
 	ingestor := ingest.NewIngestor(
-		[]string{testFile1, testFile2},
-		regexp.MustCompile(common.MessageStartPattern),
-		1_000_000,
+		[]ingest.Source{
+			{Name: "app", Globs: []string{testFile1}, Scanner: scanner, Indexer: indexer},
+			{Name: "worker", Globs: []string{testFile2}, Scanner: scanner, Indexer: indexer},
+		},
+		ingest.SegmentSize{Bytes: 1_000_000},
 		1,
 		persistentIndex,
+		opener,
 		logger,
-		indexer,
 	)
 
-	_search := search.NewSearch(context.Background(), tokenize, persistentIndex, logger)
+	_search := search.NewSearch(
+		context.Background(),
+		tokenizer.Query,
+		tokenizer.ShortQuery,
+		nil,
+		opener,
+		persistentIndex,
+		logger,
+	)
 
 	fileMessages := map[string][]common.FileMessage{
 		testFile1: common.MakeFileMessages(testFile1, common.LayoutsSampleLog1),
 		testFile2: common.MakeFileMessages(testFile2, common.LayoutsSampleLog2),
 	}
+	for i := range fileMessages[testFile1] {
+		fileMessages[testFile1][i].Source = "app"
+	}
+	for i := range fileMessages[testFile2] {
+		fileMessages[testFile2][i].Source = "worker"
+	}
 
 	return ingestor, _search, []string{testFile1, testFile2}, fileMessages
 }
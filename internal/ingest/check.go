@@ -0,0 +1,125 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"heaplog_2024/internal/common"
+)
+
+// maxFailureSamples limits how many date failures of a file are reported in detail
+const maxFailureSamples = 10
+
+// DateFailure is a message whose date could not be parsed
+type DateFailure struct {
+	Offset int    `json:"offset"` // position of the message in the file
+	Line   int    `json:"line"`   // line of the message start (1-based)
+	Date   string `json:"date"`
+	Error  string `json:"error"`
+}
+
+// FileCheck reports how messages of a file are found and parsed
+type FileCheck struct {
+	File     string     `json:"file"`
+	Size     int        `json:"size"`
+	Messages int        `json:"messages"`
+	MinDate  *time.Time `json:"minDate,omitempty"`
+	MaxDate  *time.Time `json:"maxDate,omitempty"`
+	// size of the largest message in bytes, a huge message may mean that the pattern matches too rarely
+	LargestMessage int `json:"largestMessage"`
+	// how many messages have unparsable dates, the first ones are sampled
+	DateFailures   int           `json:"dateFailures"`
+	FailureSamples []DateFailure `json:"failureSamples,omitempty"`
+	// the file could not be scanned or has no messages
+	Error string `json:"error,omitempty"`
+}
+
+// OK tells that messages are found and all dates are parsed
+func (c FileCheck) OK() bool {
+	return c.Error == "" && c.DateFailures == 0
+}
+
+// CheckFile scans the whole file and parses dates of all its messages
+func CheckFile(
+	file string,
+	opener *common.Opener,
+	scanner MessageScanner,
+	parseDate func([]byte) (time.Time, error),
+) FileCheck {
+	check := FileCheck{File: file}
+
+	f, err := opener.OpenFile(file)
+	if err != nil {
+		check.Error = fmt.Sprintf("open file: %s", err)
+		return check
+	}
+	defer f.Close()
+	check.Size = f.Len()
+
+	_, messages, err := scanner.Scan(file, check.Size, nil)
+	if errors.Is(err, NoMessageStartFound) {
+		check.Error = "no messages found (check the message pattern)"
+		return check
+	} else if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	// messages follow each other, so the file is read sequentially to count lines
+	var (
+		stream = io.NewSectionReader(f, 0, int64(check.Size))
+		pos    int
+		line   = 1
+		buf    []byte
+	)
+	read := func(to int) ([]byte, error) {
+		if cap(buf) < to-pos {
+			buf = make([]byte, to-pos)
+		}
+		buf = buf[:to-pos]
+		_, err := io.ReadFull(stream, buf)
+		pos = to
+		return buf, err
+	}
+	for m := range messages {
+		gap, err := read(m.Loc.From) // bytes before the first message
+		if err != nil {
+			check.Error = fmt.Sprintf("read file: %s", err)
+			return check
+		}
+		line += bytes.Count(gap, []byte{'\n'})
+
+		body, err := read(m.Loc.To)
+		if err != nil {
+			check.Error = fmt.Sprintf("read file: %s", err)
+			return check
+		}
+		check.Messages++
+		check.LargestMessage = max(check.LargestMessage, len(body))
+
+		dateBuf := body[m.DateLoc.From-m.Loc.From : m.DateLoc.To-m.Loc.From]
+		date, err := parseDate(dateBuf)
+		if err != nil {
+			check.DateFailures++
+			if len(check.FailureSamples) < maxFailureSamples {
+				check.FailureSamples = append(
+					check.FailureSamples,
+					DateFailure{Offset: m.Loc.From, Line: line, Date: string(dateBuf), Error: err.Error()},
+				)
+			}
+		} else {
+			if check.MinDate == nil || date.Before(*check.MinDate) {
+				check.MinDate = &date
+			}
+			if check.MaxDate == nil || date.After(*check.MaxDate) {
+				check.MaxDate = &date
+			}
+		}
+		line += bytes.Count(body, []byte{'\n'})
+	}
+
+	return check
+}
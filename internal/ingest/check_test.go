@@ -0,0 +1,54 @@
+package ingest
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	goodFile, badFile, emptyFile := filepath.Join(dir, "good.log"), filepath.Join(dir, "bad.log"), filepath.Join(dir, "empty.log")
+	bad := "[2024-07-30T00:00:01.000000+00:00] first\nsecond line\n" +
+		"[2024-07-30 00:00:02] the format changed\n" +
+		"[2024-07-30T00:00:03.000000+00:00] last"
+	require.NoError(
+		t, common.PopulateFiles(
+			map[string][]byte{
+				goodFile:  []byte(common.SampleLog1),
+				badFile:   []byte(bad),
+				emptyFile: []byte("no messages here\n"),
+			},
+		),
+	)
+
+	opener := common.NewOpener(nil)
+	scanner := NewNativeScanner(regexp.MustCompile(`^\[([^\]]+)\]`), opener)
+	parseDate := func(b []byte) (time.Time, error) { return time.Parse(common.TimeFormat, string(b)) }
+
+	check := CheckFile(goodFile, opener, scanner, parseDate)
+	require.True(t, check.OK())
+	require.Equal(t, len(common.LayoutsSampleLog1), check.Messages)
+	require.True(t, common.LayoutsSampleLog1[0].Date.Equal(*check.MinDate))
+	require.True(t, common.LayoutsSampleLog1[len(common.LayoutsSampleLog1)-1].Date.Equal(*check.MaxDate))
+	require.Equal(t, 101, check.LargestMessage) // the multi-line message
+
+	check = CheckFile(badFile, opener, scanner, parseDate)
+	require.False(t, check.OK())
+	require.Equal(t, 3, check.Messages)
+	require.Equal(t, 1, check.DateFailures)
+	require.Len(t, check.FailureSamples, 1)
+	require.Equal(t, 3, check.FailureSamples[0].Line)
+	require.Equal(t, len("[2024-07-30T00:00:01.000000+00:00] first\nsecond line\n"), check.FailureSamples[0].Offset)
+	require.Equal(t, "2024-07-30 00:00:02", check.FailureSamples[0].Date)
+
+	check = CheckFile(emptyFile, opener, scanner, parseDate)
+	require.False(t, check.OK())
+	require.Zero(t, check.Messages)
+	require.NotEmpty(t, check.Error)
+}
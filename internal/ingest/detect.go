@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"heaplog_2024/internal/common"
+)
+
+// DateShape is a common shape of timestamps that start log messages
+type DateShape struct {
+	Name string
+	// pattern of the date, it must not contain capturing groups
+	Re string
+	// Go time layout (or a built-in epoch layout) to parse the date
+	Layout string
+	// patterns of the message start, "%s" is replaced with the date group, `^(%s)` and `^\[(%s)\]` if empty
+	Starts []string
+}
+
+var defaultStarts = []string{`^(%s)`, `^\[(%s)\]`}
+
+// DateShapes are tried in order, more specific shapes go first
+var DateShapes = []DateShape{
+	{
+		Name:   "RFC3339",
+		Re:     `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:\d{2})`,
+		Layout: "2006-01-02T15:04:05.999999999Z07:00",
+	},
+	{
+		Name:   "ISO8601 with numeric offset",
+		Re:     `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:[.,]\d+)?[+-]\d{4}`,
+		Layout: "2006-01-02T15:04:05.999999999-0700",
+	},
+	{
+		Name:   "ISO8601 without offset",
+		Re:     `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:[.,]\d+)?`,
+		Layout: "2006-01-02T15:04:05.999999999",
+	},
+	{
+		Name:   "date and time with offset",
+		Re:     `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:\d{2})`,
+		Layout: "2006-01-02 15:04:05.999999999Z07:00",
+	},
+	{
+		Name:   "date and time",
+		Re:     `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:[.,]\d+)?`,
+		Layout: "2006-01-02 15:04:05.999999999",
+	},
+	{
+		Name:   "slashed date and time (nginx)",
+		Re:     `\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}`,
+		Layout: "2006/01/02 15:04:05",
+	},
+	{
+		Name:   "Apache common log format",
+		Re:     `\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+		Layout: "02/Jan/2006:15:04:05 -0700",
+		Starts: []string{`^\S+ \S+ \S+ \[(%s)\]`, `^\[(%s)\]`},
+	},
+	{
+		Name:   "syslog (RFC 3164)",
+		Re:     `[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}`,
+		Layout: "Jan _2 15:04:05",
+		Starts: []string{`^(%s)`, `^<\d{1,3}>(%s)`},
+	},
+	{
+		Name:   "Unix epoch milliseconds",
+		Re:     `\d{13}`,
+		Layout: common.LayoutUnixMilli,
+	},
+	{
+		Name:   "Unix epoch seconds",
+		Re:     `\d{10}(?:\.\d+)?`,
+		Layout: common.LayoutUnix,
+	},
+}
+
+// DetectedFormat is the format of messages inferred from a sample of lines
+type DetectedFormat struct {
+	Shape DateShape
+	// the message start pattern, the date is in the first group
+	MessageStartRE string
+	// how many lines of the sample start a message with a valid date
+	Matched int
+	// how many lines were read
+	Lines int
+}
+
+// DetectFormat reads up to maxLines lines and finds the message start that matches most of them.
+// Lines that match no shape are considered to continue multi-line messages.
+// Returns NoMessageStartFound if no shape matches any line.
+func DetectFormat(r io.Reader, maxLines int) (DetectedFormat, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), nativeScannerBufSize)
+	for len(lines) < maxLines && sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	// a line longer than the buffer ends the sample
+	if err := sc.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return DetectedFormat{}, fmt.Errorf("read sample: %w", err)
+	}
+
+	best := DetectedFormat{Lines: len(lines)}
+	for _, shape := range DateShapes {
+		parser, err := common.NewDateParser([]string{shape.Layout}, nil)
+		if err != nil {
+			return DetectedFormat{}, err
+		}
+		starts := shape.Starts
+		if len(starts) == 0 {
+			starts = defaultStarts
+		}
+		for _, start := range starts {
+			pattern := fmt.Sprintf(start, shape.Re)
+			re := regexp.MustCompile(pattern)
+			matched := 0
+			for _, line := range lines {
+				m := re.FindStringSubmatch(line)
+				if m == nil {
+					continue
+				}
+				if _, err := parser.Parse([]byte(m[1])); err == nil {
+					matched++
+				}
+			}
+			if matched > best.Matched {
+				best = DetectedFormat{Shape: shape, MessageStartRE: pattern, Matched: matched, Lines: len(lines)}
+			}
+		}
+	}
+
+	if best.Matched == 0 {
+		return best, NoMessageStartFound
+	}
+	return best, nil
+}
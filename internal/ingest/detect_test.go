@@ -0,0 +1,77 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name           string
+		sample         string
+		expectedShape  string
+		expectedRe     string
+		expectedLayout string
+	}{
+		{
+			name:           "laravel",
+			sample:         common.SampleLog1,
+			expectedShape:  "RFC3339",
+			expectedRe:     `^\[(` + DateShapes[0].Re + `)\]`,
+			expectedLayout: "2006-01-02T15:04:05.999999999Z07:00",
+		},
+		{
+			name:           "date and time",
+			sample:         "2024-07-30 10:00:00,123 INFO started\n2024-07-30 10:00:01,456 ERROR failed\n  at main.go:12\n",
+			expectedShape:  "date and time",
+			expectedLayout: "2006-01-02 15:04:05.999999999",
+		},
+		{
+			name:           "nginx",
+			sample:         "2024/07/30 10:00:00 [error] 12#12: *1 open() failed\n",
+			expectedShape:  "slashed date and time (nginx)",
+			expectedLayout: "2006/01/02 15:04:05",
+		},
+		{
+			name:           "apache",
+			sample:         `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326` + "\n",
+			expectedShape:  "Apache common log format",
+			expectedLayout: "02/Jan/2006:15:04:05 -0700",
+		},
+		{
+			name:           "syslog",
+			sample:         "Jul  3 10:00:00 host sshd[12]: Accepted\nJul 30 10:00:01 host cron[13]: Started\n",
+			expectedShape:  "syslog (RFC 3164)",
+			expectedLayout: "Jan _2 15:04:05",
+		},
+		{
+			name:           "epoch",
+			sample:         "1722297600.123 started\n1722297601.456 stopped\n",
+			expectedShape:  "Unix epoch seconds",
+			expectedLayout: common.LayoutUnix,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				detected, err := DetectFormat(strings.NewReader(tt.sample), 300)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedShape, detected.Shape.Name)
+				require.Equal(t, tt.expectedLayout, detected.Shape.Layout)
+				if tt.expectedRe != "" {
+					require.Equal(t, tt.expectedRe, detected.MessageStartRE)
+				}
+			},
+		)
+	}
+}
+
+func TestDetectFormatNoMatch(t *testing.T) {
+	_, err := DetectFormat(strings.NewReader("no dates\nhere\n"), 300)
+	require.ErrorIs(t, err, NoMessageStartFound)
+}
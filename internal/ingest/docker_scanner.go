@@ -0,0 +1,264 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"heaplog_2024/internal/common"
+)
+
+// DockerScanner finds messages in files of Docker's json-file logging driver
+// (/var/lib/docker/containers/<id>/<id>-json.log). Every line of such a file is a JSON object
+// with a chunk of the container's output in "log", so a multi-line message of the app spans several lines.
+// The message start pattern is matched against the decoded "log" values at the beginning of the app's lines,
+// the same pattern reads the app's own log file. Like with the native scanner, the first group is the date
+// and named groups are fields. Messages span whole JSON lines, so their bodies must be decoded with DecodeDocker.
+type DockerScanner struct {
+	re     *regexp.Regexp
+	opener *common.Opener
+}
+
+func NewDockerScanner(re *regexp.Regexp, opener *common.Opener) *DockerScanner {
+	return &DockerScanner{re: re, opener: opener}
+}
+
+// Scan streams the file from the earliest location and returns all messages within the given locations.
+// Returns NoMessageStartFound error if no messages are found in the stream.
+func (s *DockerScanner) Scan(file string, fileSize int, locations []common.Location) (
+	count int,
+	layouts iter.Seq[ScannedMessage],
+	err error,
+) {
+	f, err := s.opener.OpenFile(file)
+	if err != nil {
+		return 0, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	start, err := lineStartAt(f, scanStart(locations))
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	stream := io.NewSectionReader(f, int64(start), int64(max(f.Len()-start, 0)))
+
+	starts, err := s.findStarts(stream, start)
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	if len(starts) == 0 && start == 0 {
+		return 0, nil, NoMessageStartFound
+	}
+
+	messages := layoutsFromStarts(starts, fileSize, locations)
+	return len(messages), slices.Values(messages), nil
+}
+
+// findStarts reads the stream line by line and matches the pattern against the decoded output in each line.
+// Docker splits long lines of the output into several JSON lines, only the first of them is matched.
+// The stream begins at pos in the file, reported positions are absolute in the file.
+func (s *DockerScanner) findStarts(r io.Reader, pos int) ([]common.MessageLayout, error) {
+	var (
+		starts      []common.MessageLayout
+		line        []byte // accumulates lines longer than the read buffer
+		outputStart = true // false when the previous chunk of the output did not end with a newline
+	)
+
+	br := bufio.NewReaderSize(r, nativeScannerBufSize)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			line = append(line, chunk...)
+			continue
+		}
+		if len(line) > 0 {
+			chunk = append(line, chunk...)
+			line = line[:0]
+		}
+
+		output, offsets, ok := dockerOutput(chunk)
+		if ok && outputStart {
+			starts = s.appendLineMatch(starts, pos, output, offsets)
+		}
+		outputStart = !ok || len(output) == 0 || output[len(output)-1] == '\n'
+		pos += len(chunk)
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return starts, nil
+}
+
+// appendLineMatch matches the pattern against the decoded output of the JSON line located at pos in the stream.
+// The message starts with the JSON line, locations of the date and fields point to their raw text in the line.
+func (s *DockerScanner) appendLineMatch(
+	starts []common.MessageLayout,
+	pos int,
+	output []byte,
+	offsets []int,
+) []common.MessageLayout {
+	// the trailing newline is not a part of the line, so "$" matches as in line-oriented tools
+	output = bytes.TrimSuffix(output, []byte{'\n'})
+
+	m := s.re.FindSubmatchIndex(output)
+	if m == nil {
+		return starts
+	}
+	raw := func(from, to int) common.Location {
+		return common.Location{From: pos + offsets[from], To: pos + offsets[to]}
+	}
+
+	l := common.MessageLayout{Loc: common.Location{From: pos}}
+	l.DateLoc = common.Location{From: pos, To: pos} // no date group
+	if len(m) >= 4 && m[2] >= 0 {
+		l.DateLoc = raw(m[2], m[3])
+	}
+	// named groups (except the date group) are fields of the message
+	for i, name := range s.re.SubexpNames() {
+		if i < 2 || name == "" || m[2*i] < 0 {
+			continue
+		}
+		if l.FieldLocs == nil {
+			l.FieldLocs = make(map[string]common.Location)
+		}
+		l.FieldLocs[name] = raw(m[2*i], m[2*i+1])
+	}
+	return append(starts, l)
+}
+
+// DecodeDocker turns lines of Docker's json-file log into the container's output.
+// Lines that are not Docker's JSON objects are kept as is.
+func DecodeDocker(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	for len(body) > 0 {
+		line := body
+		if i := bytes.IndexByte(body, '\n'); i >= 0 {
+			line = body[:i+1]
+		}
+		body = body[len(line):]
+
+		output, _, ok := dockerOutput(line)
+		if !ok {
+			output = line
+		}
+		out = append(out, output...)
+	}
+	return out
+}
+
+// DockerFileFields returns the name of the container that wrote the file,
+// it is read from config.v2.json next to the file. Returns nil if the name is unknown.
+func DockerFileFields(file string) map[string]string {
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(file), "config.v2.json"))
+	if err != nil {
+		return nil
+	}
+	var config struct {
+		Name string `json:"Name"`
+	}
+	if json.Unmarshal(b, &config) != nil || config.Name == "" {
+		return nil
+	}
+	return map[string]string{"container": strings.TrimPrefix(config.Name, "/")}
+}
+
+// dockerOutput decodes the "log" value of the JSON line.
+// offsets[i] is the position in the line of the i-th byte of the output,
+// the extra last offset is the end of the value, so any decoded range maps to the raw text.
+func dockerOutput(line []byte) (output []byte, offsets []int, ok bool) {
+	var loc common.Location
+	common.ScanJSONFields(
+		line, func(f common.JSONField) bool {
+			if f.Path != "log" || !f.IsString {
+				return true
+			}
+			loc, ok = f.Loc, true
+			return false
+		},
+	)
+	if !ok {
+		return nil, nil, false
+	}
+	output, offsets = unquoteJSON(line, loc)
+	return output, offsets, true
+}
+
+// unquoteJSON decodes escape sequences of the JSON string at loc in the input.
+// It returns the position in the input of every decoded byte followed by the end of the string.
+// Invalid escape sequences are kept as is.
+func unquoteJSON(input []byte, loc common.Location) ([]byte, []int) {
+	out := make([]byte, 0, loc.Len())
+	offsets := make([]int, 0, loc.Len()+1)
+	for i := loc.From; i < loc.To; {
+		if input[i] != '\\' || i+1 >= loc.To {
+			out, offsets = append(out, input[i]), append(offsets, i)
+			i++
+			continue
+		}
+
+		var (
+			decoded []byte
+			n       = 2 // length of the escape sequence
+		)
+		switch input[i+1] {
+		case 'b':
+			decoded = []byte{'\b'}
+		case 'f':
+			decoded = []byte{'\f'}
+		case 'n':
+			decoded = []byte{'\n'}
+		case 'r':
+			decoded = []byte{'\r'}
+		case 't':
+			decoded = []byte{'\t'}
+		case 'u':
+			r, ok := unquoteJSONRune(input[i:loc.To])
+			if !ok {
+				decoded = input[i : i+2]
+				break
+			}
+			n = 6
+			if utf16.IsSurrogate(r) {
+				if r2, ok := unquoteJSONRune(input[i+6 : loc.To]); ok {
+					r, n = utf16.DecodeRune(r, r2), 12
+				}
+			}
+			decoded = utf8.AppendRune(nil, r)
+		default: // quotes, slashes
+			decoded = input[i+1 : i+2]
+		}
+		for _, b := range decoded {
+			out, offsets = append(out, b), append(offsets, i)
+		}
+		i += n
+	}
+	return out, append(offsets, loc.To)
+}
+
+// unquoteJSONRune decodes a "\uXXXX" sequence at the beginning of the input
+func unquoteJSONRune(input []byte) (rune, bool) {
+	if len(input) < 6 || input[0] != '\\' || input[1] != 'u' {
+		return 0, false
+	}
+	r, err := strconv.ParseUint(string(input[2:6]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(r), true
+}
@@ -0,0 +1,73 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestDockerScanner(t *testing.T) {
+	lines := []string{
+		`{"log":"boot without a date\n","stream":"stdout","time":"2024-07-30T00:00:03.1Z"}`,
+		`{"log":"[2024-07-30 00:00:04] app.ERROR: failed\n","stream":"stderr","time":"2024-07-30T00:00:04.1Z"}`,
+		`{"log":"#0 trace \"line\"\n","stream":"stderr","time":"2024-07-30T00:00:04.2Z"}`,
+		`{"log":"[2024-07-30 00:00:05] app.INFO: long line split by docker ","stream":"stdout","time":"2024-07-30T00:00:05.1Z"}`,
+		`{"log":"[2024-07-30 00:00:06] is not a message\n","stream":"stdout","time":"2024-07-30T00:00:05.2Z"}`,
+		`{"log":"[2024-07-30 00:00:07] \"app\".WARN: café\n","stream":"stdout","time":"2024-07-30T00:00:07.1Z"}`,
+	}
+	stream := strings.Join(lines, "\n") + "\n"
+	filePath := filepath.Join(t.TempDir(), "abc-json.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	re := regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\] \S+\.(?P<level>\w+):`)
+	count, messages, err := NewDockerScanner(re, common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	layouts := toMessageLayouts(slices.Collect(messages))
+
+	text := func(l common.Location) string { return stream[l.From:l.To] }
+	lineStart := func(i int) int { return strings.Index(stream, lines[i]) }
+
+	// messages span whole JSON lines, the date and fields point to the raw text
+	require.Equal(t, common.Location{From: lineStart(1), To: lineStart(3)}, layouts[0].Loc)
+	require.Equal(t, "2024-07-30 00:00:04", text(layouts[0].DateLoc))
+	require.Equal(t, "ERROR", text(layouts[0].FieldLocs["level"]))
+	require.Equal(t, "[2024-07-30 00:00:04] app.ERROR: failed\n#0 trace \"line\"\n", string(DecodeDocker([]byte(text(layouts[0].Loc)))))
+
+	// the continuation of a split line is not matched
+	require.Equal(t, common.Location{From: lineStart(3), To: lineStart(5)}, layouts[1].Loc)
+	require.Equal(
+		t,
+		"[2024-07-30 00:00:05] app.INFO: long line split by docker [2024-07-30 00:00:06] is not a message\n",
+		string(DecodeDocker([]byte(text(layouts[1].Loc)))),
+	)
+
+	// escaped values are located in the raw text
+	require.Equal(t, len(stream), layouts[2].Loc.To)
+	require.Equal(t, "WARN", text(layouts[2].FieldLocs["level"]))
+	require.Equal(t, "[2024-07-30 00:00:07] \"app\".WARN: café\n", string(DecodeDocker([]byte(text(layouts[2].Loc)))))
+}
+
+func TestDecodeDocker(t *testing.T) {
+	body := `{"log":"tab\there \ud83d\ude00 \u00e9 \/ \\ \u","stream":"stdout","time":"2024-07-30T00:00:03Z"}` + "\n" +
+		"not docker\n" +
+		`{"stream":"stdout"}` + "\n"
+	require.Equal(t, "tab\there 😀 é / \\ \\unot docker\n{\"stream\":\"stdout\"}\n", string(DecodeDocker([]byte(body))))
+}
+
+func TestDockerFileFields(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "abc-json.log")
+	require.Nil(t, DockerFileFields(file))
+
+	config := `{"ID":"abc","Name":"/web-1","Config":{"Image":"nginx"}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.v2.json"), []byte(config), 0644))
+	require.Equal(t, map[string]string{"container": "web-1"}, DockerFileFields(file))
+}
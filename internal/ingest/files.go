@@ -1,14 +1,37 @@
 package ingest
 
 import (
+	"errors"
+	"io/fs"
 	"iter"
 	"os"
 	"path/filepath"
 )
 
-type fileSize struct {
-	path string
-	size int
+// FileMatch is a file found by glob patterns
+type FileMatch struct {
+	Path string
+	Size int
+	// ExcludedBy is the exclude pattern that rejected the file, empty for included files
+	ExcludedBy string
+}
+
+// DiscoverFiles searches for files that match the given glob patterns and yields their paths and sizes.
+// Patterns support "**" to match any number of nested directories.
+// Files that match any exclude pattern are yielded with the pattern in ExcludedBy.
+// Recursive patterns enter symlinked directories only if followSymlinks is set.
+// Inaccessible files and invalid patterns are yielded with an error. Directories are skipped.
+func DiscoverFiles(globs, excludes []string, followSymlinks bool) iter.Seq2[FileMatch, error] {
+	return func(yield func(FileMatch, error) bool) {
+		for fm, err := range discoverFilesAt(globs, followSymlinks) {
+			if err == nil {
+				fm.ExcludedBy, _ = matchExclude(excludes, fm.Path)
+			}
+			if !yield(fm, err) {
+				return
+			}
+		}
+	}
 }
 
 // discoverFilesAt searches for files that match the given glob patterns and returns a map containing
@@ -16,12 +39,19 @@ type fileSize struct {
 // ErrVal containing either the file size or an error if the file is inaccessible. Directories
 // are skipped during processing. The function returns an error if any of the provided glob
 // patterns are invalid.
-func discoverFilesAt(globs []string) iter.Seq2[fileSize, error] {
-	return func(yield func(fileSize, error) bool) {
+func discoverFilesAt(globs []string, followSymlinks bool) iter.Seq2[FileMatch, error] {
+	return func(yield func(FileMatch, error) bool) {
 		for _, pattern := range globs {
+			if isRecursiveGlob(pattern) {
+				if !discoverRecursive(pattern, followSymlinks, yield) {
+					return
+				}
+				continue
+			}
+
 			matches, err := filepath.Glob(pattern)
 			if err != nil {
-				if !yield(fileSize{path: pattern}, err) {
+				if !yield(FileMatch{Path: pattern}, err) {
 					return
 				}
 				continue
@@ -29,13 +59,13 @@ func discoverFilesAt(globs []string) iter.Seq2[fileSize, error] {
 			for _, path := range matches {
 				info, err := os.Stat(path)
 				if err != nil {
-					if !yield(fileSize{path: path}, err) {
+					if !yield(FileMatch{Path: path}, err) {
 						return
 					}
 					continue
 				}
 				if !info.IsDir() {
-					if !yield(fileSize{path: path, size: int(info.Size())}, err) {
+					if !yield(FileMatch{Path: path, Size: int(info.Size())}, err) {
 						return
 					}
 				}
@@ -43,3 +73,34 @@ func discoverFilesAt(globs []string) iter.Seq2[fileSize, error] {
 		}
 	}
 }
+
+// discoverRecursive walks the directory tree under the static part of the pattern.
+// Returns false if the consumer stopped the iteration.
+func discoverRecursive(pattern string, followSymlinks bool, yield func(FileMatch, error) bool) bool {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return yield(FileMatch{Path: pattern}, err)
+	}
+
+	base := globBase(pattern)
+	if _, err := os.Stat(base); errors.Is(err, fs.ErrNotExist) {
+		return true // nothing to match, like filepath.Glob
+	}
+
+	stopped := false
+	walkFiles(
+		base, followSymlinks, func(path string, info os.FileInfo, err error) {
+			if stopped {
+				return
+			}
+			if err != nil {
+				stopped = !yield(FileMatch{Path: path}, err)
+				return
+			}
+			if !matchGlob(pattern, path) {
+				return
+			}
+			stopped = !yield(FileMatch{Path: path, Size: int(info.Size())}, nil)
+		},
+	)
+	return !stopped
+}
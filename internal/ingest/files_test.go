@@ -19,12 +19,13 @@ func TestDiscoverAt(t *testing.T) {
 
 	// Create test files
 	files := map[string][]byte{
-		filepath.Join(tempDir, "file1.txt"):          []byte("content1"),
-		filepath.Join(tempDir, "file2.txt"):          []byte(""), // empty
-		filepath.Join(tempDir, "test.log"):           []byte("log content"),
-		filepath.Join(tempDir, "subdir/file3.txt"):   []byte("content3"),
-		filepath.Join(tempDir, "subdir/test.config"): []byte("config"),
-		filepath.Join(tempDir, "wrong/inaccessible"): []byte("config"),
+		filepath.Join(tempDir, "file1.txt"):             []byte("content1"),
+		filepath.Join(tempDir, "file2.txt"):             []byte(""), // empty
+		filepath.Join(tempDir, "test.log"):              []byte("log content"),
+		filepath.Join(tempDir, "subdir/file3.txt"):      []byte("content3"),
+		filepath.Join(tempDir, "subdir/test.config"):    []byte("config"),
+		filepath.Join(tempDir, "subdir/deep/file4.txt"): []byte("content4"),
+		filepath.Join(tempDir, "wrong/inaccessible"):    []byte("config"),
 	}
 
 	for path, content := range files {
@@ -59,6 +60,15 @@ func TestDiscoverAt(t *testing.T) {
 				filepath.Join(tempDir, "file1.txt"),
 				filepath.Join(tempDir, "file2.txt"),
 				filepath.Join(tempDir, "subdir/file3.txt"),
+				filepath.Join(tempDir, "subdir/deep/file4.txt"),
+			},
+		},
+		{
+			name:     "nested txt files",
+			patterns: []string{filepath.Join(tempDir, "subdir", "**", "*.txt")},
+			expected: []string{
+				filepath.Join(tempDir, "subdir/file3.txt"),
+				filepath.Join(tempDir, "subdir/deep/file4.txt"),
 			},
 		},
 		{
@@ -87,23 +97,23 @@ func TestDiscoverAt(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(
 			tc.name, func(t *testing.T) {
-				discovered := maps.Collect(discoverFilesAt(tc.patterns))
+				discovered := maps.Collect(DiscoverFiles(tc.patterns, nil, false))
 				if err != nil {
 					t.Fatal(err)
 				}
 				if len(discovered) != len(tc.expected) {
-					t.Errorf("discoverFilesAt() found %d files, want %d", len(discovered), len(tc.expected))
+					t.Errorf("DiscoverFiles() found %d files, want %d", len(discovered), len(tc.expected))
 				}
 				for fs, err := range discovered {
-					if !slices.Contains(tc.expected, fs.path) {
-						t.Errorf("File %s is unexpected", fs.path)
+					if !slices.Contains(tc.expected, fs.Path) {
+						t.Errorf("File %s is unexpected", fs.Path)
 					}
 					if err != nil {
-						t.Errorf("File %s has error: %v", fs.path, err)
+						t.Errorf("File %s has error: %v", fs.Path, err)
 						continue
 					}
-					if fs.size != len(files[fs.path]) {
-						t.Errorf("File %s has size %d, want %d", fs.path, err, len(files[fs.path]))
+					if fs.Size != len(files[fs.Path]) {
+						t.Errorf("File %s has size %d, want %d", fs.Path, err, len(files[fs.Path]))
 					}
 				}
 			},
@@ -111,3 +121,82 @@ func TestDiscoverAt(t *testing.T) {
 	}
 
 }
+
+func TestDiscoverExcludes(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"app.log", "debug-1.log", "app.tmp", "archive/old.log"} {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	excluded := make(map[string]string)
+	var included []string
+	for fm, err := range DiscoverFiles([]string{filepath.Join(dir, "**")}, []string{"*.tmp", "debug-*.log", "archive/*"}, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fm.ExcludedBy != "" {
+			excluded[fm.Path] = fm.ExcludedBy
+			continue
+		}
+		included = append(included, fm.Path)
+	}
+
+	if want := []string{filepath.Join(dir, "app.log")}; !slices.Equal(want, included) {
+		t.Errorf("included %v, want %v", included, want)
+	}
+	wantExcluded := map[string]string{
+		filepath.Join(dir, "debug-1.log"):     "debug-*.log",
+		filepath.Join(dir, "app.tmp"):         "*.tmp",
+		filepath.Join(dir, "archive/old.log"): "archive/*",
+	}
+	if !maps.Equal(wantExcluded, excluded) {
+		t.Errorf("excluded %v, want %v", excluded, wantExcluded)
+	}
+}
+
+func TestDiscoverSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	logsDir := filepath.Join(dir, "logs")
+	linkedDir := filepath.Join(dir, "linked")
+	for _, path := range []string{filepath.Join(logsDir, "app.log"), filepath.Join(linkedDir, "linked.log")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a link to another directory and a loop
+	if err := os.Symlink(linkedDir, filepath.Join(logsDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(logsDir, filepath.Join(logsDir, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	discover := func(followSymlinks bool) []string {
+		var found []string
+		for fm, err := range DiscoverFiles([]string{filepath.Join(logsDir, "**", "*.log")}, nil, followSymlinks) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			found = append(found, fm.Path)
+		}
+		slices.Sort(found)
+		return found
+	}
+
+	if want, found := []string{filepath.Join(logsDir, "app.log")}, discover(false); !slices.Equal(want, found) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+	want := []string{filepath.Join(logsDir, "app.log"), filepath.Join(logsDir, "link", "linked.log")}
+	if found := discover(true); !slices.Equal(want, found) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+}
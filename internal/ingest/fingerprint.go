@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"heaplog_2024/internal/common"
+)
+
+// fingerprintHeadLen is how many bytes from the beginning of a file make up its fingerprint
+const fingerprintHeadLen = 1024
+
+// fileFingerprint identifies the file by its inode and the hash of its head.
+func fileFingerprint(file string) (common.Fingerprint, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return common.Fingerprint{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return common.Fingerprint{}, err
+	}
+
+	fp := common.Fingerprint{HeadLen: int(min(info.Size(), fingerprintHeadLen))}
+	fp.Inode, fp.Device = fileIdentity(info)
+	fp.HeadHash, err = hashHead(f, fp.HeadLen)
+	if err != nil {
+		return common.Fingerprint{}, fmt.Errorf("hash %s: %w", file, err)
+	}
+	return fp, nil
+}
+
+// hasSameHead checks if the file still starts with the bytes that were fingerprinted.
+func hasSameHead(file string, fp common.Fingerprint) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h, err := hashHead(f, fp.HeadLen)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil // the file is shorter than the head
+	} else if err != nil {
+		return false, err
+	}
+	return h == fp.HeadHash, nil
+}
+
+// hashHead hashes the first n bytes of the stream, fails if the stream is shorter
+func hashHead(r io.ReaderAt, n int) (uint64, error) {
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, 0)
+	if read < n {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(buf)
+	return h.Sum64(), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package ingest
+
+import "os"
+
+// fileIdentity is not supported on this OS, so renamed files are indexed as new ones
+func fileIdentity(info os.FileInfo) (inode, device uint64) {
+	return 0, 0
+}
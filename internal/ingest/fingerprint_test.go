@@ -0,0 +1,40 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{file: []byte(common.SampleLog1)}))
+
+	fp, err := fileFingerprint(file)
+	require.NoError(t, err)
+	require.Equal(t, min(len(common.SampleLog1), fingerprintHeadLen), fp.HeadLen)
+
+	// appending does not change the head
+	require.NoError(t, common.PopulateFiles(map[string][]byte{file: []byte(common.SampleLog1 + common.SampleLog2)}))
+	same, err := hasSameHead(file, fp)
+	require.NoError(t, err)
+	require.True(t, same)
+
+	// renaming keeps the identity
+	renamed := filepath.Join(dir, "test.log.1")
+	require.NoError(t, os.Rename(file, renamed))
+	fp2, err := fileFingerprint(renamed)
+	require.NoError(t, err)
+	require.True(t, fp.SameIdentity(fp2))
+
+	// truncation changes the head
+	require.NoError(t, os.Truncate(renamed, 10))
+	same, err = hasSameHead(renamed, fp)
+	require.NoError(t, err)
+	require.False(t, same)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package ingest
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the inode and the device of the file
+func fileIdentity(info os.FileInfo) (inode, device uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(st.Ino), uint64(st.Dev)
+}
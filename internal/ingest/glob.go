@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recursiveWildcard matches any number of nested directories in a glob pattern (doublestar-style)
+const recursiveWildcard = "**"
+
+// isRecursiveGlob checks if the pattern contains the "**" path segment
+func isRecursiveGlob(pattern string) bool {
+	for _, segment := range splitPath(pattern) {
+		if segment == recursiveWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// globBase returns the longest leading directory of the pattern that has no wildcards,
+// that is where the search for matching files starts.
+func globBase(pattern string) string {
+	segments := splitPath(pattern)
+	for i, segment := range segments {
+		if hasGlobMeta(segment) {
+			base := strings.Join(segments[:i], string(filepath.Separator))
+			if base == "" && filepath.IsAbs(pattern) {
+				base = string(filepath.Separator)
+			} else if base == "" {
+				base = "."
+			}
+			return base
+		}
+	}
+	return filepath.Dir(filepath.Clean(pattern))
+}
+
+// matchGlob matches the path against the pattern. Segments are matched with filepath.Match,
+// while the "**" segment matches zero or more directories.
+func matchGlob(pattern, path string) bool {
+	return matchSegments(splitPath(pattern), splitPath(path))
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == recursiveWildcard {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// matchExclude returns the first exclude pattern that matches the file.
+// Relative patterns match at any depth, so "*.tmp" matches by the file name.
+func matchExclude(excludes []string, file string) (string, bool) {
+	for _, pattern := range excludes {
+		p := pattern
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(recursiveWildcard, p)
+		}
+		if matchGlob(p, file) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(filepath.Clean(path), string(filepath.Separator))
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// walkFiles visits files in the directory tree. Symlinked directories are entered only if followSymlinks is set,
+// each directory is visited once, so symlink loops are not followed.
+func walkFiles(dir string, followSymlinks bool, visit func(path string, info os.FileInfo, err error)) {
+	visited := make(map[string]struct{})
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			visit(dir, nil, err)
+			return
+		}
+		if _, ok := visited[realDir]; ok {
+			return // a symlink loop or a directory linked twice
+		}
+		visited[realDir] = struct{}{}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			visit(dir, nil, err)
+			return
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				walk(path)
+				continue
+			}
+			info, err := os.Stat(path) // follows symlinks
+			if err != nil {
+				visit(path, nil, err)
+				continue
+			}
+			if info.IsDir() {
+				if followSymlinks {
+					walk(path)
+				}
+				continue
+			}
+			visit(path, info, nil)
+		}
+	}
+	walk(dir)
+}
@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"/logs/*.log", "/logs/app.log", true},
+		{"/logs/*.log", "/logs/2024/app.log", false},
+		{"/logs/**/*.log", "/logs/app.log", true},
+		{"/logs/**/*.log", "/logs/2024/01/app.log", true},
+		{"/logs/**/*.log", "/other/app.log", false},
+		{"/logs/**", "/logs/2024/app.tmp", true},
+		{"/logs/**/worker/*.log", "/logs/2024/worker/app.log", true},
+		{"/logs/**/worker/*.log", "/logs/2024/app.log", false},
+		{"./logs/**/*.log", "logs/a/b.log", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(filepath.FromSlash(tt.pattern), filepath.FromSlash(tt.path)); got != tt.expected {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	tests := []struct {
+		pattern, expected string
+	}{
+		{"/logs/**/*.log", "/logs"},
+		{"/logs/2024-*/**", "/logs"},
+		{"/**/*.log", "/"},
+		{"logs/**", "logs"},
+		{"**/*.log", "."},
+	}
+
+	for _, tt := range tests {
+		if got := globBase(filepath.FromSlash(tt.pattern)); got != filepath.FromSlash(tt.expected) {
+			t.Errorf("globBase(%q) = %q, want %q", tt.pattern, got, tt.expected)
+		}
+	}
+}
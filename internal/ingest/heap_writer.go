@@ -0,0 +1,251 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// heapWriterBufSize is the write buffer of heap files, lines are flushed once the input goes idle
+const heapWriterBufSize = 64 * 1024
+
+// HeapWriter appends streamed lines to heap files managed by heaplog.
+// Files are named "<name>-<seq>.log" in the directory, a new file starts when the current one reaches maxSize.
+// If isMessageStart is set, a new file starts only at a message start, so messages are never split across files.
+// It is safe for concurrent use.
+type HeapWriter struct {
+	dir, name      string
+	maxSize        int
+	isMessageStart func([]byte) bool
+
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	seq  int
+	size int
+	// files written since the last call to Touched
+	touched []string
+}
+
+// NewHeapWriter creates the directory and appends to the latest heap file in it (if any).
+func NewHeapWriter(dir, name string, maxSize int, isMessageStart func([]byte) bool) (*HeapWriter, error) {
+	if maxSize <= 0 {
+		panic(fmt.Sprintf("invalid max heap file size: %d", maxSize))
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create heap dir: %w", err)
+	}
+
+	hw := &HeapWriter{dir: dir, name: name, maxSize: maxSize, isMessageStart: isMessageStart, seq: 1}
+	files, err := hw.Files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last := strings.TrimSuffix(filepath.Base(files[len(files)-1]), ".log")
+		_, err = fmt.Sscanf(last[len(name)+1:], "%d", &hw.seq)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected heap file %s: %w", files[len(files)-1], err)
+		}
+	}
+	return hw, hw.open()
+}
+
+// Files returns heap files in the order they were written
+func (hw *HeapWriter) Files() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(hw.dir, hw.name+"-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	// other writers may share the directory, their names can have the same prefix
+	files := slices.DeleteFunc(matches, func(file string) bool {
+		seq := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), hw.name+"-"), ".log")
+		return strings.Trim(seq, "0123456789") != "" || seq == ""
+	})
+	slices.Sort(files) // sequence numbers are zero-padded
+	return files, nil
+}
+
+// File returns the heap file that is currently written
+func (hw *HeapWriter) File() string {
+	return filepath.Join(hw.dir, fmt.Sprintf("%s-%06d.log", hw.name, hw.seq))
+}
+
+// open opens the current heap file for appending
+func (hw *HeapWriter) open() error {
+	f, err := os.OpenFile(hw.File(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open heap file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("open heap file: %w", err)
+	}
+	hw.f, hw.size = f, int(info.Size())
+	if hw.w == nil {
+		hw.w = bufio.NewWriterSize(f, heapWriterBufSize)
+	} else {
+		hw.w.Reset(f)
+	}
+	return nil
+}
+
+// WriteLine appends the line, the line break is added if missing.
+func (hw *HeapWriter) WriteLine(line []byte) error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if hw.size >= hw.maxSize && (hw.isMessageStart == nil || hw.isMessageStart(line)) {
+		err := hw.rollover()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := hw.w.Write(line)
+	hw.size += n
+	if err == nil && !bytes.HasSuffix(line, []byte{'\n'}) {
+		err = hw.w.WriteByte('\n')
+		hw.size++
+	}
+	if err != nil {
+		return fmt.Errorf("write heap file: %w", err)
+	}
+	if len(hw.touched) == 0 || hw.touched[len(hw.touched)-1] != hw.f.Name() {
+		hw.touched = append(hw.touched, hw.f.Name())
+	}
+	return nil
+}
+
+// rollover closes the current file and starts the next one
+func (hw *HeapWriter) rollover() error {
+	err := hw.w.Flush()
+	if err != nil {
+		return fmt.Errorf("flush heap file: %w", err)
+	}
+	err = hw.f.Close()
+	if err != nil {
+		return fmt.Errorf("close heap file: %w", err)
+	}
+	hw.seq++
+	return hw.open()
+}
+
+// Flush writes buffered lines to the file
+func (hw *HeapWriter) Flush() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.w.Flush()
+}
+
+// Touched flushes buffered lines and returns files written since the previous call, they are ready to be ingested.
+func (hw *HeapWriter) Touched() ([]string, error) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	err := hw.w.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("flush heap file: %w", err)
+	}
+	touched := hw.touched
+	hw.touched = nil
+	return touched, nil
+}
+
+// Close flushes and closes the current file
+func (hw *HeapWriter) Close() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return errors.Join(hw.w.Flush(), hw.f.Close())
+}
+
+// Trim removes the oldest heap files while all files take more than maxTotal bytes, the current file is kept.
+// Removed files are reported as touched, so they are removed from the index.
+func (hw *HeapWriter) Trim(maxTotal int) error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	files, err := hw.Files()
+	if err != nil {
+		return err
+	}
+	sizes := make([]int, len(files))
+	total := 0
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("trim heap: %w", err)
+		}
+		sizes[i] = int(info.Size())
+		total += sizes[i]
+	}
+	for i, file := range files {
+		if total <= maxTotal || file == hw.f.Name() {
+			break
+		}
+		err = os.Remove(file)
+		if err != nil {
+			return fmt.Errorf("trim heap: %w", err)
+		}
+		total -= sizes[i]
+		hw.touched = append(hw.touched, file)
+	}
+	return nil
+}
+
+// Consume appends lines of the stream until it ends.
+// Buffered lines are flushed whenever the stream goes idle, so they can be ingested right away.
+func (hw *HeapWriter) Consume(r io.Reader) error {
+	br := bufio.NewReaderSize(r, heapWriterBufSize)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if werr := hw.WriteLine(line); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return hw.Flush()
+		} else if err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+		if br.Buffered() == 0 {
+			if err = hw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ConsumePipe appends lines from the named pipe until the context is done.
+// The pipe is reopened when all its writers close it, a regular file is read once.
+func (hw *HeapWriter) ConsumePipe(ctx context.Context, pipe string) error {
+	info, err := os.Stat(pipe)
+	if err != nil {
+		return fmt.Errorf("unable to open the pipe at %s: %w", pipe, err)
+	}
+	for ctx.Err() == nil {
+		f, err := os.Open(pipe) // blocks until a writer opens the pipe
+		if err != nil {
+			return fmt.Errorf("unable to open the pipe at %s: %w", pipe, err)
+		}
+		err = hw.Consume(f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return nil
+		}
+	}
+	return nil
+}
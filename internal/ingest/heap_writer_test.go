@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeapWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "heaps", "app")
+	isMessageStart := func(line []byte) bool { return bytes.HasPrefix(line, []byte("[")) }
+
+	hw, err := NewHeapWriter(dir, "app", 20, isMessageStart)
+	require.NoError(t, err)
+	other, err := NewHeapWriter(dir, "app-2", 20, nil) // shares the directory
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	stream := "[1] first\n[2] second\ncontinued\nmore\n[3] third" // the last line has no break
+	require.NoError(t, hw.Consume(strings.NewReader(stream)))
+
+	// rollover happens only at message starts
+	files, err := hw.Files()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "app-000001.log"), filepath.Join(dir, "app-000002.log")}, files)
+	touched, err := hw.Touched()
+	require.NoError(t, err)
+	require.Equal(t, files, touched)
+
+	contents := func(file string) string {
+		b, err := os.ReadFile(file)
+		require.NoError(t, err)
+		return string(b)
+	}
+	require.Equal(t, "[1] first\n[2] second\ncontinued\nmore\n", contents(files[0]))
+	require.Equal(t, "[3] third\n", contents(files[1]))
+
+	// nothing written since
+	touched, err = hw.Touched()
+	require.NoError(t, err)
+	require.Empty(t, touched)
+	require.NoError(t, hw.Close())
+
+	// a new writer appends to the latest file
+	hw, err = NewHeapWriter(dir, "app", 20, isMessageStart)
+	require.NoError(t, err)
+	require.NoError(t, hw.WriteLine([]byte("[4] fourth\n")))
+	require.NoError(t, hw.Close())
+	require.Equal(t, "[3] third\n[4] fourth\n", contents(files[1]))
+}
+
+func TestHeapWriterTrim(t *testing.T) {
+	dir := t.TempDir()
+	hw, err := NewHeapWriter(dir, "app", 10, nil)
+	require.NoError(t, err)
+	defer hw.Close()
+
+	for _, line := range []string{"0123456789", "abcdefghij", "ABCDEFGHIJ"} { // a file per line
+		require.NoError(t, hw.WriteLine([]byte(line)))
+	}
+	require.NoError(t, hw.Flush())
+	files, err := hw.Files()
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	_, err = hw.Touched()
+	require.NoError(t, err)
+
+	// the oldest files are removed and reported
+	require.NoError(t, hw.Trim(25))
+	left, err := hw.Files()
+	require.NoError(t, err)
+	require.Equal(t, files[1:], left)
+	touched, err := hw.Touched()
+	require.NoError(t, err)
+	require.Equal(t, files[:1], touched)
+
+	// the current file is never removed
+	require.NoError(t, hw.Trim(0))
+	left, err = hw.Files()
+	require.NoError(t, err)
+	require.Equal(t, files[2:], left)
+}
+
+func TestHeapWriterConsumePipe(t *testing.T) {
+	dir := t.TempDir()
+	hw, err := NewHeapWriter(filepath.Join(dir, "app"), "app", 1024, nil)
+	require.NoError(t, err)
+	defer hw.Close()
+
+	// a regular file is read once
+	stream := filepath.Join(dir, "stream")
+	require.NoError(t, os.WriteFile(stream, []byte("first\nsecond\n"), 0644))
+	require.NoError(t, hw.ConsumePipe(context.Background(), stream))
+	b, err := os.ReadFile(hw.File())
+	require.NoError(t, err)
+	require.Equal(t, "first\nsecond\n", string(b))
+
+	require.Error(t, hw.ConsumePipe(context.Background(), filepath.Join(dir, "missing")))
+}
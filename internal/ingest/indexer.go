@@ -2,8 +2,8 @@ package ingest
 
 import (
 	"context"
+	"fmt"
 	"iter"
-	"os"
 	"sync"
 	"time"
 
@@ -13,60 +13,173 @@ import (
 )
 
 type task struct {
+	seq        int // order of the task among tasks of one indexSegments call
 	at         time.Time
 	file       string
 	segmentBuf common.Buffer
 	layouts    []common.MessageLayout
+	// the date parser of the file, shared by tasks of the file
+	parseDate func([]byte) (time.Time, error)
+	// the segment could not be read (segmentBuf is empty), the file is blacklisted
+	readErr error
 }
 type taskResult struct {
-	task     task
-	tokens   [][]byte
-	messages []common.Message
+	task   task
+	tokens [][]byte
+	// short terms of the messages, nil if the indexer does not build filters
+	shortTerms common.BloomFilter
+	messages   []common.Message
+	// messages that were skipped or got the date of the previous message
+	dateErrors common.DateErrors
+	// leading messages that inherit the date of the previous segment (their dates are zero until then)
+	undated int
+	// the file is blacklisted by this task, only the task and dateErrors (or the read error) are set
+	blacklisted bool
+	// the file was blacklisted by a previous task, only the task is set
+	skipped bool
 }
 
+// DateErrorPolicy tells the indexer what to do with a message whose date can't be parsed
+type DateErrorPolicy string
+
+const (
+	// DateErrorSkip leaves the message out of the index
+	DateErrorSkip DateErrorPolicy = "skip"
+	// DateErrorInherit indexes the message with the date of the previous message of the file
+	// (it is skipped if the previous message is in a segment indexed by an earlier run)
+	DateErrorInherit DateErrorPolicy = "inherit"
+	// DateErrorBlacklist stops indexing the file until it changes or the service restarts
+	DateErrorBlacklist DateErrorPolicy = "blacklist"
+)
+
 // Indexer processes log file segments in parallel, tokenizing content and parsing dates
-// using a configurable number of workers
+// using a configurable number of workers. Results come in the order of segments, so segments of a file
+// are put into the index in the same order (with the same ids) regardless of the number of workers.
 type Indexer struct {
-	ctx       context.Context
-	blacklist sync.Map
-	workers   int
-	tokenize  func([]byte) [][]byte
-	parseDate func([]byte) (time.Time, error)
-	bufPool   *common.BufferPool
-	logger    *zap.Logger
+	ctx     context.Context
+	workers int
+	// segments read but not yet taken from results, it bounds the memory of segments waiting for an earlier one
+	slots    chan struct{}
+	tokenize func([]byte) [][]byte
+	// terms that are too short for the inverted index, they make the filter of the segment (nil = no filters)
+	shortTerms func([]byte) [][]byte
+	// makes the date parser of a file, a parser may remember the date format of its file
+	// and is used by many workers at once
+	newDateParser func() func([]byte) (time.Time, error)
+	// what to do with messages whose dates can't be parsed
+	onDateError DateErrorPolicy
+	opener      *common.Opener
+	bufPool     *common.BufferPool
+	logger      *zap.Logger
 }
 
 func NewIndexer(
 	ctx context.Context,
 	logger *zap.Logger,
 	tokenize func(i []byte) [][]byte,
-	parseDate func(b []byte) (time.Time, error),
+	shortTerms func(i []byte) [][]byte,
+	newDateParser func() func(b []byte) (time.Time, error),
+	onDateError DateErrorPolicy,
+	workers int,
+	opener *common.Opener,
 ) *Indexer {
+	if workers <= 0 {
+		panic(fmt.Sprintf("invalid workers count: %d", workers))
+	}
 	bufPool := common.NewBufferPool([]int{1024})
 	return &Indexer{
-		ctx:       ctx,
-		workers:   1, // predictable results
-		bufPool:   bufPool,
-		logger:    logger,
-		tokenize:  tokenize,
-		parseDate: parseDate,
+		ctx:           ctx,
+		workers:       workers,
+		slots:         make(chan struct{}, 2*workers),
+		bufPool:       bufPool,
+		logger:        logger,
+		tokenize:      tokenize,
+		shortTerms:    shortTerms,
+		newDateParser: newDateParser,
+		onDateError:   onDateError,
+		opener:        opener,
 	}
 }
 
 // indexSegments processes pending segments from multiple files in parallel and returns an iterator of task results.
+// Results come in the order of segments, results of a file after the one that blacklisted it are dropped
+// (as if segments were indexed one by one).
 func (ix *Indexer) indexSegments(
 	// pendingSegments is a map of file paths to groups (called segments) of message layouts to be indexed
 	pendingSegments map[string][][]common.MessageLayout,
+	// dateParser returns the date parser of the file, so it remembers the file's date format across calls
+	// (nil = a new parser per file and call)
+	dateParser func(file string) func([]byte) (time.Time, error),
 ) iter.Seq[taskResult] {
+	if dateParser == nil {
+		dateParser = func(string) func([]byte) (time.Time, error) { return ix.newDateParser() }
+	}
+	// files blacklisted by tasks of this call, their remaining segments are not read or indexed
+	blacklist := &sync.Map{}
+	tasks := ix.produceTasks(pendingSegments, dateParser, blacklist)
+	tasksResults := ix.consumeTasksViaWorkerPool(tasks, blacklist)
+
+	return func(yield func(taskResult) bool) {
+		blacklisted := make(map[string]struct{})
+		lastDates := make(map[string]time.Time) // of the last message of every file
+		stopped := false
+		for r := range orderResults(tasksResults) {
+			if r.task.readErr == nil {
+				r.task.segmentBuf.Close()
+			}
+			<-ix.slots
+			// after a stop, the rest is drained, so workers and the producer finish
+			if stopped || r.skipped {
+				continue
+			}
+			if _, ok := blacklisted[r.task.file]; ok {
+				continue
+			}
+			if r.blacklisted {
+				blacklisted[r.task.file] = struct{}{}
+			}
+			inheritDates(&r, lastDates[r.task.file])
+			if len(r.messages) > 0 {
+				lastDates[r.task.file] = r.messages[len(r.messages)-1].Date
+			}
+			stopped = !yield(r)
+		}
+	}
+}
 
-	tasks := ix.produceTasks(pendingSegments)
-	tasksResults := ix.consumeTasksViaWorkerPool(tasks)
+// inheritDates gives leading messages without dates the date of the last message of the previous segment,
+// they are skipped if the previous segment is unknown
+func inheritDates(r *taskResult, lastDate time.Time) {
+	if r.undated == 0 {
+		return
+	}
+	if lastDate.IsZero() {
+		r.messages = r.messages[r.undated:]
+	} else {
+		for i := range r.messages[:r.undated] {
+			r.messages[i].Date = lastDate
+		}
+	}
+	r.undated = 0
+}
 
+// orderResults passes results in the order of their tasks, every task must have one result
+func orderResults(results <-chan taskResult) iter.Seq[taskResult] {
 	return func(yield func(taskResult) bool) {
-		for r := range tasksResults {
-			r.task.segmentBuf.Close()
-			if !yield(r) {
-				break
+		pending := make(map[int]taskResult)
+		next := 0
+		for r := range results {
+			pending[r.task.seq] = r
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if !yield(r) {
+					return
+				}
 			}
 		}
 	}
@@ -75,8 +188,9 @@ func (ix *Indexer) indexSegments(
 // consumeTasksViaWorkerPool processes incoming tasks using a pool of workers and returns results through a channel.
 // It spawns the configured number of worker goroutines that process tasks in parallel.
 // Each worker tokenizes messages, extracts and validates dates, and collects unique terms.
-// If date parsing fails for any message in a file, the file is blacklisted and its remaining segments are skipped.
-func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task) <-chan taskResult {
+// Messages whose dates can't be parsed are handled according to the DateErrorPolicy,
+// a blacklisted file is reported once and its remaining segments are skipped.
+func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task, blacklist *sync.Map) <-chan taskResult {
 	results := make(chan taskResult)
 
 	// launch workers in a separate goroutine
@@ -95,8 +209,14 @@ func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task) <-chan taskResult {
 				defer wg.Done()
 			TaskLoop:
 				for t := range in {
-					if _, blacklisted := ix.blacklist.Load(t.file); blacklisted {
-						continue // skip faulty files
+					if _, blacklisted := blacklist.Load(t.file); blacklisted {
+						results <- taskResult{task: t, skipped: true} // skip faulty files
+						continue
+					}
+					if t.readErr != nil {
+						blacklist.Store(t.file, nil)
+						results <- taskResult{task: t, blacklisted: true}
+						continue
 					}
 
 					// calculate effective position in the buffer by offsetting absolute position
@@ -105,25 +225,59 @@ func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task) <-chan taskResult {
 					// Tokenize each message in the layouts
 					messages := make([]common.Message, 0, len(t.layouts))
 					termsMap := make(map[string]struct{})
+					shortTermsMap := make(map[string]struct{})
+					var (
+						dateErrors common.DateErrors
+						undated    int
+					)
 					for _, m := range t.layouts {
-						// skip date tokens
-						appendTermsUnique(termsMap, ix.tokenize(t.segmentBuf.Buf[pos(m.Loc.From):pos(m.DateLoc.From)]))
-						appendTermsUnique(termsMap, ix.tokenize(t.segmentBuf.Buf[pos(m.DateLoc.To):pos(m.Loc.To)]))
-
 						dateBuf := t.segmentBuf.Buf[pos(m.DateLoc.From):pos(m.DateLoc.To)]
-						date, err := ix.parseDate(dateBuf)
+						date, err := t.parseDate(dateBuf)
 						if err != nil {
-							ix.logger.Error(
+							ix.logger.Warn(
 								"parse date fail",
 								zap.String("file", t.file),
 								zap.ByteString("date", dateBuf),
+								zap.String("policy", string(ix.onDateError)),
 								zap.Error(err),
 							)
-							ix.blacklist.Store(t.file, nil)
-							continue TaskLoop
+							dateErrors.Add(dateBuf, err)
+
+							if ix.onDateError == DateErrorBlacklist {
+								blacklist.Store(t.file, nil)
+								results <- taskResult{task: t, dateErrors: dateErrors, blacklisted: true}
+								continue TaskLoop
+							}
+							if ix.onDateError != DateErrorInherit {
+								continue // skip the message
+							}
+							if len(messages) == undated {
+								undated++ // the previous message is in the previous segment
+							} else {
+								date = messages[len(messages)-1].Date
+							}
 						}
 
-						messages = append(messages, common.Message{MessageLayout: m, Date: date})
+						// copy fields before the buffer is modified
+						var fields map[string]string
+						for name, loc := range m.FieldLocs {
+							if fields == nil {
+								fields = make(map[string]string, len(m.FieldLocs))
+							}
+							fields[name] = string(t.segmentBuf.Buf[pos(loc.From):pos(loc.To)])
+						}
+
+						// skip date tokens: the date is blanked out, so the tokenizer still sees the message structure
+						for k := range dateBuf {
+							dateBuf[k] = ' '
+						}
+						body := t.segmentBuf.Buf[pos(m.Loc.From):pos(m.Loc.To)]
+						appendTermsUnique(termsMap, ix.tokenize(body))
+						if ix.shortTerms != nil {
+							appendTermsUnique(shortTermsMap, ix.shortTerms(body))
+						}
+
+						messages = append(messages, common.Message{MessageLayout: m, Date: date, Fields: fields})
 					}
 
 					// Collect unique terms from the messages
@@ -131,7 +285,22 @@ func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task) <-chan taskResult {
 					for term := range termsMap {
 						terms = append(terms, []byte(term))
 					}
-					results <- taskResult{t, terms, messages}
+					var shortTerms common.BloomFilter
+					if ix.shortTerms != nil {
+						keys := make([][]byte, 0, len(shortTermsMap))
+						for term := range shortTermsMap {
+							keys = append(keys, []byte(term))
+						}
+						shortTerms = common.NewBloomFilter(keys)
+					}
+					results <- taskResult{
+						task:       t,
+						tokens:     terms,
+						shortTerms: shortTerms,
+						messages:   messages,
+						dateErrors: dateErrors,
+						undated:    undated,
+					}
 				}
 			}()
 		}
@@ -144,28 +313,45 @@ func (ix *Indexer) consumeTasksViaWorkerPool(in <-chan task) <-chan taskResult {
 // It takes a map of file paths to their segments containing message layouts.
 // For each segment, it reads the corresponding bytes from the file using a buffer from the pool.
 // Returns a channel of tasks containing file path, segment bytes, and message layouts.
-// If file operations fail, the file is blacklisted and skipped.
-func (ix *Indexer) produceTasks(pendingSegments map[string][][]common.MessageLayout) <-chan task {
+// If reading fails, the task carries the error and remaining segments of the file are skipped.
+// A task takes a slot of the indexer that is released when its result is taken.
+func (ix *Indexer) produceTasks(
+	pendingSegments map[string][][]common.MessageLayout,
+	dateParser func(file string) func([]byte) (time.Time, error),
+	blacklist *sync.Map,
+) <-chan task {
 	tasks := make(chan task)
 
 	// produce tasks in a separate goroutine
 	go func() {
 		defer close(tasks)
+		seq := 0
 		for file, segments := range pendingSegments {
 			// Expect hang-up:
 			if ix.ctx.Err() != nil {
 				return
 			}
+			if len(segments) == 0 {
+				continue
+			}
 
-			fd, err := os.Open(file)
+			fd, err := ix.opener.OpenFile(file)
 			if err != nil {
 				ix.logger.Warn("open file", zap.String("file", file), zap.Error(err))
+				select {
+				case ix.slots <- struct{}{}:
+				case <-ix.ctx.Done():
+					return
+				}
+				tasks <- task{seq: seq, at: time.Now(), file: file, layouts: segments[0], readErr: err}
+				seq++
 				continue
 			}
 
 			// make a new scope here
 			func() {
 				defer fd.Close()
+				parseDate := dateParser(file)
 
 				for _, segment := range segments {
 
@@ -173,6 +359,15 @@ func (ix *Indexer) produceTasks(pendingSegments map[string][][]common.MessageLay
 					if ix.ctx.Err() != nil {
 						return
 					}
+					if _, blacklisted := blacklist.Load(file); blacklisted {
+						return
+					}
+
+					select {
+					case ix.slots <- struct{}{}:
+					case <-ix.ctx.Done():
+						return
+					}
 
 					segmentLoc := common.Location{From: segment[0].Loc.From, To: segment[len(segment)-1].Loc.To}
 					buf := ix.bufPool.Get(segmentLoc.Len())
@@ -180,10 +375,13 @@ func (ix *Indexer) produceTasks(pendingSegments map[string][][]common.MessageLay
 					_, err = fd.ReadAt(buf.Buf, int64(segmentLoc.From))
 					if err != nil {
 						ix.logger.Error("read file", zap.String("file", file), zap.Error(err))
-						ix.blacklist.Store(file, nil)
-						continue
+						buf.Close()
+						tasks <- task{seq: seq, at: time.Now(), file: file, layouts: segment, readErr: err}
+						seq++
+						return // the result blacklists the file
 					}
-					tasks <- task{time.Now(), file, buf, segment}
+					tasks <- task{seq: seq, at: time.Now(), file: file, segmentBuf: buf, layouts: segment, parseDate: parseDate}
+					seq++
 				}
 			}()
 		}
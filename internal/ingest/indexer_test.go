@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,17 +29,20 @@ func TestIndexer(t *testing.T) {
 		func(i []byte) [][]byte {
 			return [][]byte{[]byte("test token")}
 		},
-		func(b []byte) (time.Time, error) {
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
 			return time.Parse(common.TimeFormat, string(b))
-		},
+		}),
+		DateErrorSkip,
+		1,
+		common.NewOpener(nil),
 	)
 
 	// Prepare test data
 	fileName, fileBytes := common.MakeTestFile(t)
-	_, scannedLayouts, err := Scan(
+	_, scannedLayouts, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
 		fileName,
 		len(fileBytes),
-		common.MessageStartPattern,
 		[]common.Location{{To: len(fileBytes)}},
 	)
 	require.NoError(t, err)
@@ -45,7 +53,7 @@ func TestIndexer(t *testing.T) {
 		fileName: {layouts[:1], layouts[1:]},
 	}
 	var results []taskResult
-	for r := range ix.indexSegments(segments) {
+	for r := range ix.indexSegments(segments, nil) {
 		results = append(results, r)
 	}
 
@@ -104,7 +112,7 @@ func TestIndexer(t *testing.T) {
 
 		// Compare layouts
 		for j, layout := range result.task.layouts {
-			if layout != expected.task.layouts[j] {
+			if !reflect.DeepEqual(layout, expected.task.layouts[j]) {
 				t.Errorf("Result %d, layout %d: Expected %v, got %v", i, j, expected.task.layouts[j], layout)
 			}
 		}
@@ -121,17 +129,20 @@ func TestBlacklistedFileNotIndexed(t *testing.T) {
 		func(i []byte) [][]byte {
 			return [][]byte{[]byte("test token")}
 		},
-		func(b []byte) (time.Time, error) {
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
 			return time.Time{}, fmt.Errorf("unexpected date format")
-		},
+		}),
+		DateErrorBlacklist,
+		1,
+		common.NewOpener(nil),
 	)
 
 	// Prepare test data
 	fileName, fileBytes := common.MakeTestFile(t)
-	_, scannedLayouts, err := Scan(
+	_, scannedLayouts, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
 		fileName,
 		len(fileBytes),
-		common.MessageStartPattern,
 		[]common.Location{{To: len(fileBytes)}},
 	)
 	require.NoError(t, err)
@@ -141,16 +152,98 @@ func TestBlacklistedFileNotIndexed(t *testing.T) {
 	segments := map[string][][]common.MessageLayout{
 		fileName: {layouts},
 	}
-	results := 0
-	for range ix.indexSegments(segments) {
-		results++
+	var results []taskResult
+	for r := range ix.indexSegments(segments, nil) {
+		results = append(results, r)
 	}
-	require.Equal(t, 0, results, "Expected no results for blacklisted file")
+	require.Len(t, results, 1, "Expected the only result that reports blacklisting")
+	require.True(t, results[0].blacklisted)
+	require.Empty(t, results[0].messages)
+	require.Equal(t, 1, results[0].dateErrors.Count)
+	require.Equal(t, "unexpected date format", results[0].dateErrors.Error)
+
+	// The indexer does not remember blacklisted files, the ingestor skips them until they change
+	results = slices.Collect(ix.indexSegments(segments, nil))
+	require.Len(t, results, 1)
+	require.True(t, results[0].blacklisted)
+
+	// A file that can't be read is blacklisted too
+	results = slices.Collect(ix.indexSegments(map[string][][]common.MessageLayout{fileName + ".gone": {layouts}}, nil))
+	require.Len(t, results, 1)
+	require.True(t, results[0].blacklisted)
+	require.ErrorIs(t, results[0].task.readErr, os.ErrNotExist)
+}
 
-	// Second attempt should be skipped due to blacklisting
-	for range ix.indexSegments(segments) {
-		t.Error("Expected no results for blacklisted file on second attempt")
+func TestDateErrorPolicies(t *testing.T) {
+	fileName, fileBytes := common.MakeTestFile(t)
+	_, scannedLayouts, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
+		fileName,
+		len(fileBytes),
+		[]common.Location{{To: len(fileBytes)}},
+	)
+	require.NoError(t, err)
+	layouts := toMessageLayouts(slices.Collect(scannedLayouts))
+	badDate := string(fileBytes[layouts[1].DateLoc.From:layouts[1].DateLoc.To]) // the second message
+
+	index := func(policy DateErrorPolicy) taskResult {
+		ix := NewIndexer(
+			context.Background(),
+			zap.NewNop(),
+			func(i []byte) [][]byte { return nil },
+			nil,
+			fileDates(func(b []byte) (time.Time, error) {
+				if string(b) == badDate {
+					return time.Time{}, fmt.Errorf("bad date")
+				}
+				return time.Parse(common.TimeFormat, string(b))
+			}),
+			policy,
+			1,
+			common.NewOpener(nil),
+		)
+		results := slices.Collect(ix.indexSegments(map[string][][]common.MessageLayout{fileName: {layouts}}, nil))
+		require.Len(t, results, 1)
+		require.False(t, results[0].blacklisted)
+		require.Equal(t, common.DateErrors{Count: 1, Sample: badDate, Error: "bad date"}, results[0].dateErrors)
+		return results[0]
 	}
+
+	skipped := index(DateErrorSkip)
+	require.Len(t, skipped.messages, len(layouts)-1)
+	require.Equal(t, layouts[2], skipped.messages[1].MessageLayout)
+
+	inherited := index(DateErrorInherit)
+	require.Len(t, inherited.messages, len(layouts))
+	require.Equal(t, layouts[1], inherited.messages[1].MessageLayout)
+	require.Equal(t, inherited.messages[0].Date, inherited.messages[1].Date)
+
+	// the first message of a segment inherits the date of the previous segment
+	ix := NewIndexer(
+		context.Background(),
+		zap.NewNop(),
+		func(i []byte) [][]byte { return nil },
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
+			if string(b) == badDate {
+				return time.Time{}, fmt.Errorf("bad date")
+			}
+			return time.Parse(common.TimeFormat, string(b))
+		}),
+		DateErrorInherit,
+		2,
+		common.NewOpener(nil),
+	)
+	results := slices.Collect(ix.indexSegments(map[string][][]common.MessageLayout{fileName: {layouts[:1], layouts[1:]}}, nil))
+	require.Len(t, results, 2)
+	require.Len(t, results[1].messages, len(layouts)-1)
+	require.Equal(t, layouts[1], results[1].messages[0].MessageLayout)
+	require.Equal(t, results[0].messages[0].Date, results[1].messages[0].Date)
+
+	// unless the previous segment was indexed before
+	results = slices.Collect(ix.indexSegments(map[string][][]common.MessageLayout{fileName: {layouts[1:]}}, nil))
+	require.Len(t, results, 1)
+	require.Len(t, results[0].messages, len(layouts)-2)
+	require.Equal(t, layouts[2], results[0].messages[0].MessageLayout)
 }
 
 func TestIndexerContextCancellation(t *testing.T) {
@@ -165,18 +258,21 @@ func TestIndexerContextCancellation(t *testing.T) {
 		func(i []byte) [][]byte {
 			return [][]byte{[]byte("test token")}
 		},
-		func(b []byte) (time.Time, error) {
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
 			time.Sleep(1000 * time.Millisecond) // Simulate slow processing
 			return time.Parse(common.TimeFormat, string(b))
-		},
+		}),
+		DateErrorSkip,
+		1,
+		common.NewOpener(nil),
 	)
 
 	// Prepare test data
 	fileName, fileBytes := common.MakeTestFile(t)
-	_, scannedLayouts, err := Scan(
+	_, scannedLayouts, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
 		fileName,
 		len(fileBytes),
-		common.MessageStartPattern,
 		[]common.Location{{To: len(fileBytes)}},
 	)
 	require.NoError(t, err)
@@ -193,8 +289,158 @@ func TestIndexerContextCancellation(t *testing.T) {
 
 	// Verify no results are produced
 	results := 0
-	for range ix.indexSegments(segments) {
+	for range ix.indexSegments(segments, nil) {
 		results++
 	}
 	require.Equal(t, 0, results, "Expected no results after context cancellation")
 }
+
+func TestIndexerOrderedResults(t *testing.T) {
+	fileName, fileBytes := makeSyntheticLog(t, t.TempDir(), 1_000)
+	layouts := scanTestLayouts(t, fileName, fileBytes)
+	segments := alignSegmentsByMessageBoundaries(1_000, 0, []common.Location{{To: len(fileBytes)}}, layouts)
+	require.Greater(t, len(segments), 20)
+
+	badDate := string(fileBytes[segments[15][0].DateLoc.From:segments[15][0].DateLoc.To])
+	index := func(policy DateErrorPolicy) (indexed [][]common.MessageLayout, blacklisted int) {
+		ix := NewIndexer(
+			context.Background(),
+			zap.NewNop(),
+			func(i []byte) [][]byte {
+				time.Sleep(time.Duration(len(i)%7) * 100 * time.Microsecond) // segments finish out of order
+				return common.Tokenize(i, 4, 8)
+			},
+			nil,
+			fileDates(func(b []byte) (time.Time, error) {
+				if string(b) == badDate {
+					return time.Time{}, fmt.Errorf("bad date")
+				}
+				return time.Parse(common.TimeFormat, string(b))
+			}),
+			policy,
+			8,
+			common.NewOpener(nil),
+		)
+		for r := range ix.indexSegments(map[string][][]common.MessageLayout{fileName: segments}, nil) {
+			if r.blacklisted {
+				blacklisted++
+				continue
+			}
+			indexed = append(indexed, r.task.layouts)
+		}
+		return
+	}
+
+	indexed, blacklisted := index(DateErrorSkip)
+	require.Equal(t, segments, indexed)
+	require.Zero(t, blacklisted)
+
+	// segments after the blacklisting one are dropped even if they were indexed already
+	indexed, blacklisted = index(DateErrorBlacklist)
+	require.Equal(t, segments[:15], indexed)
+	require.Equal(t, 1, blacklisted)
+}
+
+func TestIndexerDateParserPerFile(t *testing.T) {
+	file1, bytes1 := makeSyntheticLog(t, t.TempDir(), 100)
+	file2, bytes2 := makeSyntheticLog(t, t.TempDir(), 100)
+	pending := make(map[string][][]common.MessageLayout)
+	for file, fileBytes := range map[string][]byte{file1: bytes1, file2: bytes2} {
+		layouts := scanTestLayouts(t, file, fileBytes)
+		pending[file] = alignSegmentsByMessageBoundaries(1_000, 0, []common.Location{{To: len(fileBytes)}}, layouts)
+		require.Greater(t, len(pending[file]), 1)
+	}
+
+	var parsers atomic.Int32
+	ix := NewIndexer(
+		context.Background(),
+		zap.NewNop(),
+		func(i []byte) [][]byte { return common.Tokenize(i, 4, 8) },
+		nil,
+		func() func([]byte) (time.Time, error) {
+			parsers.Add(1)
+			p, err := common.NewDateParser([]string{time.RFC3339, common.TimeFormat}, nil)
+			require.NoError(t, err)
+			return p.Parse
+		},
+		DateErrorSkip,
+		4,
+		common.NewOpener(nil),
+	)
+	messages := 0
+	for r := range ix.indexSegments(pending, nil) {
+		require.False(t, r.blacklisted)
+		require.Zero(t, r.dateErrors.Count)
+		messages += len(r.messages)
+	}
+	require.Equal(t, 200, messages)
+	require.EqualValues(t, 2, parsers.Load()) // one parser per file, not per segment or per indexer
+}
+
+// BenchmarkIndexSegments indexes a large file in segments with different numbers of workers
+func BenchmarkIndexSegments(b *testing.B) {
+	fileName, fileBytes := makeSyntheticLog(b, b.TempDir(), 200_000) // ~30Mb
+	layouts := scanTestLayouts(b, fileName, fileBytes)
+	segments := alignSegmentsByMessageBoundaries(1_000_000, 0, []common.Location{{To: len(fileBytes)}}, layouts)
+
+	counts := []int{1, 2, 4, runtime.NumCPU()}
+	slices.Sort(counts)
+	for _, workers := range slices.Compact(counts) {
+		b.Run(
+			fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+				b.SetBytes(int64(len(fileBytes)))
+				for i := 0; i < b.N; i++ {
+					ix := NewIndexer(
+						context.Background(),
+						zap.NewNop(),
+						func(i []byte) [][]byte { return common.Tokenize(i, 4, 8) },
+						nil,
+						fileDates(func(b []byte) (time.Time, error) { return time.Parse(common.TimeFormat, string(b)) }),
+						DateErrorSkip,
+						workers,
+						common.NewOpener(nil),
+					)
+					for range ix.indexSegments(map[string][][]common.MessageLayout{fileName: segments}, nil) {
+					}
+				}
+			},
+		)
+	}
+}
+
+// makeSyntheticLog writes a log file of n messages with distinct dates and words
+func makeSyntheticLog(t testing.TB, dir string, n int) (string, []byte) {
+	var buf bytes.Buffer
+	date := time.Date(2024, 7, 30, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		_, _ = fmt.Fprintf(
+			&buf,
+			"[%s] request %d of user%d handled in %dms\nstatus: done, worker: w%d, session: s%x\n",
+			date.Add(time.Duration(i)*time.Millisecond).Format(common.TimeFormat),
+			i,
+			i%1_000,
+			i%250,
+			i%16,
+			i*7919,
+		)
+	}
+	fileName := filepath.Join(dir, "synthetic.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{fileName: buf.Bytes()}))
+	return fileName, buf.Bytes()
+}
+
+// scanTestLayouts finds all messages of the file
+func scanTestLayouts(t testing.TB, fileName string, fileBytes []byte) []common.MessageLayout {
+	_, scanned, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
+		fileName,
+		len(fileBytes),
+		[]common.Location{{To: len(fileBytes)}},
+	)
+	require.NoError(t, err)
+	return toMessageLayouts(slices.Collect(scanned))
+}
+
+// fileDates gives every file the same stateless date parser
+func fileDates(parse func([]byte) (time.Time, error)) func() func([]byte) (time.Time, error) {
+	return func() func([]byte) (time.Time, error) { return parse }
+}
@@ -4,9 +4,10 @@
 package ingest
 
 import (
+	"context"
 	"fmt"
 	"maps"
-	"regexp"
+	"path/filepath"
 	"slices"
 	"sync"
 	"time"
@@ -19,100 +20,306 @@ import (
 type FilesIndex interface {
 	// GetSegments returns indexed segments (sorted by position) per file
 	GetSegments() (map[string][]common.Location, error)
-	// PutSegment adds a segment to the index
-	PutSegment(file string, terms [][]byte, messages []common.Message) (int, error)
+	// GetFiles returns all files known to the index
+	GetFiles() (map[string]common.IndexedFile, error)
+	// PutFile registers the file as a member of the source and remembers its fingerprint
+	PutFile(file string, source string, fp common.Fingerprint) error
+	// RenameFile moves the file with its segments to the new path
+	RenameFile(from, to string) error
+	// PutSegment adds a segment to the index, messages may not cover the whole segment (some could be skipped)
+	PutSegment(file string, segment common.Segment, terms [][]byte, messages []common.Message) (int, error)
+	// BlacklistFile remembers that indexing of the file stopped at a date parse failure (or a read failure without
+	// a sample), the file is skipped until it changes or a segment of it is indexed
+	BlacklistFile(file string, failure common.DateErrors) error
 	// WipeSegment resets the index for the single segment
 	WipeSegment(file string, segment common.Location) error
 	// WipeSegments resets the index for the file
 	WipeSegments(file string) error
 	// WipeFile deletes the index for the file
 	WipeFile(file string) error
+	// GetIndexOptions returns the options that files of sources were indexed with
+	GetIndexOptions() (map[string]string, error)
+	// PutIndexOptions remembers the options that files of the source are indexed with
+	PutIndexOptions(source string, options string) error
+}
+
+// FilesReport describes the state of indexed files
+type FilesReport interface {
+	// GetDegradedFiles returns files that have messages with unparsable dates
+	GetDegradedFiles() ([]common.DegradedFile, error)
+}
+
+// Source is a group of files that share the same format of messages.
+type Source struct {
+	// unique name of the source
+	Name string
+	// glob patterns to match files of the source ("**" matches nested directories)
+	Globs []string
+	// patterns of files to skip, relative patterns match at any depth (example: "*.tmp")
+	Excludes []string
+	// enter symlinked directories when matching recursive patterns
+	FollowSymlinks bool
+	// finds messages' boundaries in files
+	Scanner MessageScanner
+	// tokenizes messages and parses dates
+	Indexer *Indexer
+	// returns fields shared by all messages of the file (optional), fields of messages take precedence
+	FileFields func(file string) map[string]string
+	// IndexOptions describes how messages are turned into terms (e.g. the tokenizer's options),
+	// files of the source are indexed again when it changes
+	IndexOptions string
 }
 
 // Ingestor handles file discovery, scanning and indexing operations.
 // It maintains the index state and ensures data consistency between
 // files on disk and their indexed representation.
 type Ingestor struct {
-	// glob patterns to match files to index
-	globs []string
-	// regular expression to match messages' starting lines
-	messageRE *regexp.Regexp
-	// target length of a single indexed segment (segments align at message boundaries)
-	segmentLen int
+	// sources of files to index, a file belongs to the first source that matches it
+	sources []Source
+	// how messages are grouped into indexed segments
+	segmentSize SegmentSize
+	// growth of files between runs, segments of files are sized by it
+	growth map[string]fileGrowth
 	// number of concurrent workers that index segments
 	workers int
 
-	db      FilesIndex
-	logger  *zap.Logger
-	indexer *Indexer
+	db FilesIndex
+	// opens files of sources, it caches sizes of compressed files
+	opener *common.Opener
+	logger *zap.Logger
+
+	// runs are exclusive as they reconcile the same index
+	mu sync.Mutex
+	// false until the first run ends, so blacklisted files are retried after a restart (e.g. with fixed date formats)
+	skipBlacklisted bool
+	// true once files of sources with changed index options are wiped
+	optionsChecked bool
+	// date parsers of files, they remember the date format of their file across runs
+	dateParsers   map[string]func([]byte) (time.Time, error)
+	dateParsersMu sync.Mutex
 }
 
 func NewIngestor(
-	globs []string,
-	messageRE *regexp.Regexp,
-	segmentLen int,
+	sources []Source,
+	segmentSize SegmentSize,
 	workers int,
 	db FilesIndex,
+	opener *common.Opener,
 	logger *zap.Logger,
-	indexer *Indexer,
 ) *Ingestor {
 	if workers <= 0 {
 		panic(fmt.Sprintf("invalid workers count: %d", workers))
 	}
-	if segmentLen <= 0 {
-		panic(fmt.Sprintf("invalid segment length: %d", segmentLen))
+	if err := segmentSize.validate(); err != nil {
+		panic(err.Error())
 	}
-	if len(globs) == 0 {
-		panic("no glob patterns provided")
+	if len(sources) == 0 {
+		panic("no sources provided")
+	}
+	for _, s := range sources {
+		if len(s.Globs) == 0 {
+			panic(fmt.Sprintf("no glob patterns provided for source %q", s.Name))
+		}
 	}
 
 	return &Ingestor{
-		globs:      globs,
-		messageRE:  messageRE,
-		segmentLen: segmentLen,
-		workers:    workers,
-		db:         db,
-		logger:     logger,
-		indexer:    indexer,
+		sources:     sources,
+		segmentSize: segmentSize,
+		growth:      make(map[string]fileGrowth),
+		dateParsers: make(map[string]func([]byte) (time.Time, error)),
+		workers:     workers,
+		db:          db,
+		opener:      opener,
+		logger:      logger,
+	}
+}
+
+// discoverFiles finds files of all sources, returns file sizes and sources per file.
+// If a file matches many sources, it belongs to the first one.
+func (i *Ingestor) discoverFiles() (files map[string]int, fileSources map[string]*Source) {
+	files = make(map[string]int)
+	fileSources = make(map[string]*Source)
+	for j := range i.sources {
+		source := &i.sources[j]
+		for fs, err := range DiscoverFiles(source.Globs, source.Excludes, source.FollowSymlinks) {
+			if err != nil {
+				i.logger.Warn("discover file", zap.String("path", fs.Path), zap.Error(err))
+				continue
+			}
+			if fs.ExcludedBy != "" {
+				continue
+			}
+			if s, ok := fileSources[fs.Path]; ok {
+				if s != source {
+					i.logger.Debug(
+						"file matches many sources",
+						zap.String("file", fs.Path),
+						zap.String("source", s.Name),
+						zap.String("ignored", source.Name),
+					)
+				}
+				continue
+			}
+			files[fs.Path] = fs.Size
+			fileSources[fs.Path] = source
+		}
+	}
+	return files, fileSources
+}
+
+// measureGrowth updates the growth of the files and returns the target length of their segments.
+// Files that are gone are forgotten after a run over all files.
+func (i *Ingestor) measureGrowth(files map[string]int, all bool) (segmentLens map[string]int) {
+	now := time.Now()
+	segmentLens = make(map[string]int, len(files))
+	for file, size := range files {
+		g := i.growth[file].observe(size, now)
+		i.growth[file] = g
+		segmentLens[file] = i.segmentSize.lenFor(g.rate)
+	}
+	if all {
+		maps.DeleteFunc(i.growth, func(file string, _ fileGrowth) bool { _, ok := files[file]; return !ok })
 	}
+	return segmentLens
 }
 
 // Run performs the main ingestion workflow
 func (i *Ingestor) Run() error {
-	// 1. discover current files
-	files := map[string]int{}
-	for fs, err := range discoverFilesAt(i.globs) {
+	return i.run(nil)
+}
+
+// RunFiles performs the ingestion workflow only for the given files (it ignores files that belong to no source).
+// Files that disappeared are removed from the index.
+func (i *Ingestor) RunFiles(files []string) error {
+	only := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		only[filepath.Clean(f)] = struct{}{}
+	}
+	return i.run(only)
+}
+
+// RunTouched ingests files returned by touched every second (e.g. heap files written since the previous call)
+// until the context is done.
+func (i *Ingestor) RunTouched(ctx context.Context, touched func() ([]string, error)) {
+	common.RepeatEvery(
+		ctx, time.Second, func() {
+			files, err := touched()
+			if err != nil {
+				i.logger.Error("Heap writer failed", zap.Error(err))
+				return
+			}
+			if len(files) == 0 {
+				return
+			}
+			err = i.RunFiles(files)
+			if err != nil {
+				i.logger.Error("Ingestor failed", zap.Error(err))
+			}
+		},
+	)
+}
+
+// run ingests the files given in "only", or all files if "only" is nil.
+func (i *Ingestor) run(only map[string]struct{}) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if only == nil {
+		defer func() { i.skipBlacklisted = true }()
+	}
+	if !i.optionsChecked {
+		err := i.reindexChangedSources()
 		if err != nil {
-			i.logger.Warn("discover file", zap.String("path", fs.path), zap.Error(err))
-			continue
+			return fmt.Errorf("check index options: %w", err)
 		}
-		files[fs.path] = fs.size
+		i.optionsChecked = true
 	}
 
+	// 1. discover current files
+	files, fileSources := i.discoverFiles()
+	if only != nil {
+		for file := range files {
+			if _, ok := only[file]; !ok {
+				delete(files, file)
+				delete(fileSources, file)
+			}
+		}
+	}
+
+	segmentLens := i.measureGrowth(files, only == nil)
+
 	// 2. Read the index
 	indexedSegments, err := i.db.GetSegments()
 	if err != nil {
 		return fmt.Errorf("get indexed segments: %w", err)
 	}
+	indexedFiles, err := i.db.GetFiles()
+	if err != nil {
+		return fmt.Errorf("get indexed files: %w", err)
+	}
+	if only != nil {
+		maps.DeleteFunc(indexedFiles, func(file string, _ common.IndexedFile) bool { _, ok := only[file]; return !ok })
+	}
 
-	// 3. Reconcile missing files (present in index but not on disk)
-	for file := range indexedSegments {
-		if _, ok := files[file]; !ok {
-			i.logger.Info("wipe file index", zap.String("file", file))
-			err = i.db.WipeFile(file)
-			if err != nil {
-				return fmt.Errorf("wipe file index: %w", err)
+	// 3. Reconcile the index with files on disk: renamed, rewritten, missing files and files that moved to another source
+	fingerprints := make(map[string]common.Fingerprint, len(files))
+	for file := range files {
+		fp, err := fileFingerprint(file)
+		if err != nil {
+			i.logger.Warn("fingerprint file", zap.String("file", file), zap.Error(err))
+			delete(files, file)
+			delete(fileSources, file)
+			continue
+		}
+		fingerprints[file] = fp
+	}
+	err = i.reconcileFiles(files, fileSources, fingerprints, indexedFiles, indexedSegments)
+	if err != nil {
+		return err
+	}
+
+	// 4. Register files of sources and their current fingerprints
+	for file := range files {
+		indexed := common.IndexedFile{Source: fileSources[file].Name, Fingerprint: fingerprints[file]}
+		if indexedFiles[file].Source == indexed.Source && indexedFiles[file].Fingerprint == indexed.Fingerprint {
+			if indexedFiles[file].Blacklisted && i.skipBlacklisted {
+				delete(files, file) // blacklisted files are skipped until they change
+				delete(indexedSegments, file)
 			}
-			delete(indexedSegments, file)
+			continue
+		}
+		err = i.db.PutFile(file, indexed.Source, indexed.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("put file: %w", err)
 		}
 	}
 
-	// 4. Skip files that are already entirely indexed
+	// 5. Skip files that are already entirely indexed
 	for file, size := range files {
+		if common.IsCompressed(file) {
+			// offsets of compressed files are in the uncompressed content
+			uncompressed, err := i.opener.UncompressedSize(file)
+			if err != nil {
+				i.logger.Warn("decompress file", zap.String("file", file), zap.Error(err))
+				err = i.db.BlacklistFile(file, common.DateErrors{Error: fmt.Sprintf("decompress file: %s", err)})
+				if err != nil {
+					return fmt.Errorf("blacklist file: %w", err)
+				}
+				delete(files, file)
+				continue
+			}
+			files[file], size = uncompressed, uncompressed
+			if len(indexedSegments[file]) == 0 {
+				i.warnUnseekable(file)
+			}
+		}
 		if len(indexedSegments[file]) == 0 {
 			continue
 		}
 		loc := common.Location{From: 0, To: size}
+		if common.IsCompressed(file) {
+			// compressed files do not grow, bytes before the first message are never indexed
+			loc.From = min(indexedSegments[file][0].From, size)
+		}
 		unindexed := loc.RemoveAll(indexedSegments[file])
 		if len(unindexed) == 0 {
 			delete(files, file)
@@ -139,23 +346,21 @@ func (i *Ingestor) Run() error {
 				// PER-FILE WORKER:
 
 				size := files[f]
+				segmentLen := segmentLens[f]
+				source := fileSources[f]
 				fileIndexedSegments := indexedSegments[f]
 
 				// 1. Build messages' layouts for each file
-				msgCount, layoutsIt, err := Scan(f, size, i.messageRE.String(), nil)
+				layouts, tailOnly, err := i.scanFile(source.Scanner, f, size, fileIndexedSegments)
 				if err != nil {
 					i.logger.Error("Scan file", zap.String("file", f), zap.Error(err))
 					continue
 				}
-				// allocate enough memory in one pass
-				layouts := make([]common.MessageLayout, 0, msgCount)
-				for scannedMessage := range layoutsIt {
-					layouts = append(layouts, scannedMessage.MessageLayout)
-				}
 
 				// 2. Validate index alignment; wipe segments if misaligned
 				// as a result it re-indexes files that somehow changed already indexed data.
-				if findMisalignedSegmentsForFile(fileIndexedSegments, layouts) {
+				// The tail scan has already validated the boundary, so only a full scan is checked.
+				if !tailOnly && findMisalignedSegmentsForFile(fileIndexedSegments, layouts) {
 					i.logger.Warn("indexed misalignment: re-index required", zap.String("file", f))
 					err = i.db.WipeSegments(f)
 					if err != nil {
@@ -163,14 +368,22 @@ func (i *Ingestor) Run() error {
 						return
 					}
 
-					// remove last segment from the list as it is re-indexed
-					fileIndexedSegments = fileIndexedSegments[:len(fileIndexedSegments)-1]
+					// all segments are re-indexed
+					fileIndexedSegments = nil
 				}
 
 				// 3. Validate last file segments.
 				// If the last layout is not full and does not end at the end of the file,
 				// it is considered to be incomplete and needs to be re-indexed.
-				if hasIncompleteTrailingSegment(i.segmentLen, size, fileIndexedSegments) {
+				// The same applies if the last indexed message continued after indexing.
+				if hasIncompleteTrailingSegment(
+					segmentLen,
+					i.segmentSize.Messages,
+					size,
+					fileIndexedSegments,
+					layouts,
+				) ||
+					hasGrownTrailingMessage(size, fileIndexedSegments, layouts) {
 					i.logger.Debug("re-index trailing segment", zap.String("file", f))
 					// here it wipes trailing index data, which can briefly affect searches that are currently running.
 					// past searches won't be affected as they keep found messages in a separate results storage.
@@ -186,12 +399,48 @@ func (i *Ingestor) Run() error {
 				plan := make(map[string][][]common.MessageLayout, 0)
 				loc := common.Location{To: size}
 				unindexedLocations := loc.RemoveAll(fileIndexedSegments)
-				segments := alignSegmentsByMessageBoundaries(i.segmentLen, unindexedLocations, layouts)
+				segments := alignSegmentsByMessageBoundaries(
+					segmentLen,
+					i.segmentSize.Messages,
+					unindexedLocations,
+					layouts,
+				)
 				plan[f] = segments
 
 				// 5. Perform indexing
-				for r := range i.indexer.indexSegments(plan) {
-					_, err = i.db.PutSegment(r.task.file, r.tokens, r.messages)
+				var fileFields map[string]string
+				if source.FileFields != nil {
+					fileFields = source.FileFields(f)
+				}
+				for r := range source.Indexer.indexSegments(plan, i.dateParser(source)) {
+					if r.blacklisted {
+						failure := r.dateErrors
+						if r.task.readErr != nil {
+							failure = common.DateErrors{Error: fmt.Sprintf("read file: %s", r.task.readErr)}
+							i.logger.Error("file blacklisted: read failure", zap.String("file", r.task.file))
+						} else {
+							i.logger.Error(
+								"file blacklisted: date parse failure",
+								zap.String("file", r.task.file),
+								zap.String("date", r.dateErrors.Sample),
+							)
+						}
+						err = i.db.BlacklistFile(r.task.file, failure)
+						if err != nil {
+							i.logger.Error("blacklist file", zap.String("file", r.task.file), zap.Error(err))
+						}
+						continue
+					}
+					segment := common.Segment{
+						Location: common.Location{
+							From: r.task.layouts[0].Loc.From,
+							To:   r.task.layouts[len(r.task.layouts)-1].Loc.To,
+						},
+						DateErrors: r.dateErrors,
+						ShortTerms: r.shortTerms,
+					}
+					addFields(r.messages, fileFields)
+					_, err = i.db.PutSegment(r.task.file, segment, r.tokens, r.messages)
 					if err != nil {
 						i.logger.Error("put segment", zap.String("file", r.task.file), zap.Error(err))
 						return
@@ -200,8 +449,8 @@ func (i *Ingestor) Run() error {
 						fmt.Sprintf(
 							"indexed segment %s [%d:%d] %d messages, %d tokens in %s",
 							r.task.file,
-							r.task.layouts[0].Loc.From,
-							r.task.layouts[len(r.task.layouts)-1].Loc.To,
+							segment.From,
+							segment.To,
 							len(r.messages),
 							len(r.tokens),
 							time.Since(r.task.at).String(),
@@ -217,9 +466,254 @@ func (i *Ingestor) Run() error {
 	return nil
 }
 
+// dateParser returns parsers of files of the source, a file keeps its parser until it is wiped
+func (i *Ingestor) dateParser(source *Source) func(file string) func([]byte) (time.Time, error) {
+	return func(file string) func([]byte) (time.Time, error) {
+		i.dateParsersMu.Lock()
+		defer i.dateParsersMu.Unlock()
+		parseDate, ok := i.dateParsers[file]
+		if !ok {
+			parseDate = source.Indexer.newDateParser()
+			i.dateParsers[file] = parseDate
+		}
+		return parseDate
+	}
+}
+
+// forgetDateParser drops the parser of the file, or moves it to the new path of a renamed file (newFile is not empty)
+func (i *Ingestor) forgetDateParser(file, newFile string) {
+	i.dateParsersMu.Lock()
+	defer i.dateParsersMu.Unlock()
+	if parseDate, ok := i.dateParsers[file]; ok && newFile != "" {
+		i.dateParsers[newFile] = parseDate
+	}
+	delete(i.dateParsers, file)
+}
+
+// reindexChangedSources wipes files of sources whose index options changed, so they are indexed again as new files.
+// Options are only remembered for sources that have none yet (new sources, or indexed before options were kept).
+func (i *Ingestor) reindexChangedSources() error {
+	stored, err := i.db.GetIndexOptions()
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]struct{})
+	for _, source := range i.sources {
+		if o, ok := stored[source.Name]; ok && o != source.IndexOptions {
+			i.logger.Info("index options changed, files of the source are indexed again", zap.String("source", source.Name))
+			changed[source.Name] = struct{}{}
+		}
+	}
+	if len(changed) > 0 {
+		indexedFiles, err := i.db.GetFiles()
+		if err != nil {
+			return err
+		}
+		for file, indexed := range indexedFiles {
+			if _, ok := changed[indexed.Source]; !ok {
+				continue
+			}
+			err = i.db.WipeFile(file)
+			if err != nil {
+				return fmt.Errorf("wipe file index: %w", err)
+			}
+		}
+	}
+	// options are stored after files are wiped, so an interrupted wipe continues on the next start
+	for _, source := range i.sources {
+		if o, ok := stored[source.Name]; !ok || o != source.IndexOptions {
+			err = i.db.PutIndexOptions(source.Name, source.IndexOptions)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// warnUnseekable tells that reading messages of the new compressed file decompresses it from the start
+func (i *Ingestor) warnUnseekable(file string) {
+	index, err := i.opener.SeekIndex(file)
+	if err == nil && !index.Seekable() {
+		i.logger.Warn(
+			"compressed file cannot be seeked, every read decompresses it from the start: "+
+				"compress it in many frames (e.g. with pzstd or in the seekable zstd format)",
+			zap.String("file", file),
+		)
+	}
+}
+
+// reconcileFiles brings indexed files in line with files on disk (it updates the given maps accordingly):
+//   - a renamed file (same inode and the same head) keeps its segments under the new path,
+//     this is how rotation by renaming is handled without re-indexing,
+//   - a file that was truncated or rewritten in place (e.g. copytruncate rotation) loses its segments,
+//   - a missing file, or a file that moved to another source, is removed from the index.
+func (i *Ingestor) reconcileFiles(
+	files map[string]int,
+	fileSources map[string]*Source,
+	fingerprints map[string]common.Fingerprint,
+	indexedFiles map[string]common.IndexedFile,
+	indexedSegments map[string][]common.Location,
+) error {
+	// files whose index does not describe the file at the same path anymore
+	changed := make(map[string]struct{})
+	for file, indexed := range indexedFiles {
+		fp, ok := fingerprints[file]
+		switch {
+		case !ok || indexed.Source != fileSources[file].Name:
+			changed[file] = struct{}{}
+		case indexed.Fingerprint.IsZero():
+			// unknown fingerprint, rely on misalignment checks
+		case indexed.Fingerprint.Inode != fp.Inode || indexed.Fingerprint.Device != fp.Device:
+			changed[file] = struct{}{} // replaced by another file
+		}
+	}
+
+	// find new paths of renamed files
+	renames := make(map[string]string) // old path -> new path
+	for file := range changed {
+		indexed := indexedFiles[file]
+		for newFile, fp := range fingerprints {
+			if !indexed.Fingerprint.SameIdentity(fp) || fileSources[newFile].Name != indexed.Source {
+				continue
+			}
+			if _, ok := indexedFiles[newFile]; ok {
+				if _, ok = changed[newFile]; !ok {
+					continue // the new path is occupied by a valid file
+				}
+			}
+			sameHead, err := hasSameHead(newFile, indexed.Fingerprint)
+			if err != nil {
+				i.logger.Warn("fingerprint file", zap.String("file", newFile), zap.Error(err))
+				continue
+			}
+			if sameHead {
+				renames[file] = newFile
+				break
+			}
+		}
+	}
+
+	// move renamed files out of the way first, as their new paths may be occupied by stale files
+	movingPath := func(file string) string { return file + ".heaplog-renaming" }
+	for file := range renames {
+		err := i.db.RenameFile(file, movingPath(file))
+		if err != nil {
+			return fmt.Errorf("rename file: %w", err)
+		}
+		i.forgetDateParser(file, movingPath(file))
+	}
+
+	// remove files that are gone from the index
+	for file := range changed {
+		if _, ok := renames[file]; ok {
+			continue
+		}
+		i.logger.Info("wipe file index", zap.String("file", file))
+		err := i.db.WipeFile(file)
+		if err != nil {
+			return fmt.Errorf("wipe file index: %w", err)
+		}
+		i.opener.Forget(file)
+		i.forgetDateParser(file, "")
+		delete(indexedFiles, file)
+		delete(indexedSegments, file)
+	}
+
+	// place renamed files at their new paths
+	newIndexedFiles := make(map[string]common.IndexedFile, len(renames))
+	newIndexedSegments := make(map[string][]common.Location, len(renames))
+	for file, newFile := range renames {
+		i.logger.Info("file renamed", zap.String("file", file), zap.String("new", newFile))
+		err := i.db.RenameFile(movingPath(file), newFile)
+		if err != nil {
+			return fmt.Errorf("rename file: %w", err)
+		}
+		i.opener.Forget(file)
+		i.forgetDateParser(movingPath(file), newFile)
+		newIndexedFiles[newFile] = indexedFiles[file]
+		newIndexedSegments[newFile] = indexedSegments[file]
+		delete(indexedFiles, file)
+		delete(indexedSegments, file)
+	}
+	maps.Copy(indexedFiles, newIndexedFiles)
+	maps.Copy(indexedSegments, newIndexedSegments)
+
+	// files that were truncated or rewritten in place lose their segments
+	for file, indexed := range indexedFiles {
+		if indexed.Fingerprint.IsZero() || len(indexedSegments[file]) == 0 {
+			continue
+		}
+		segments := indexedSegments[file]
+		// sizes of compressed files are not comparable with offsets in their content
+		truncated := !common.IsCompressed(file) && files[file] < segments[len(segments)-1].To
+		if !truncated {
+			sameHead, err := hasSameHead(file, indexed.Fingerprint)
+			if err != nil {
+				return fmt.Errorf("fingerprint file: %w", err)
+			}
+			truncated = !sameHead
+		}
+		if !truncated {
+			continue
+		}
+		i.logger.Info("file truncated or rewritten: re-index required", zap.String("file", file))
+		err := i.db.WipeSegments(file)
+		if err != nil {
+			return fmt.Errorf("wipe segments: %w", err)
+		}
+		delete(indexedSegments, file)
+	}
+
+	return nil
+}
+
+// scanFile builds message layouts of the file.
+// If the file was indexed before, it scans only the tail of the file starting at the last indexed segment,
+// so already indexed data is not read again. The tail is trusted if the last segment still starts at a message,
+// otherwise the file changed before that point and the entire file is scanned.
+// tailOnly reports that layouts cover only the tail.
+func (i *Ingestor) scanFile(scanner MessageScanner, file string, size int, indexedSegments []common.Location) (
+	layouts []common.MessageLayout,
+	tailOnly bool,
+	err error,
+) {
+	from, ok := tailScanStart(indexedSegments, size)
+	if ok {
+		layouts, err = scanLayouts(scanner, file, size, []common.Location{{From: from, To: size}})
+		if err != nil {
+			return nil, false, err
+		}
+		if isTailAligned(indexedSegments[len(indexedSegments)-1], layouts) {
+			return layouts, true, nil
+		}
+		i.logger.Info("file changed before the last indexed segment: full scan required", zap.String("file", file))
+	}
+
+	layouts, err = scanLayouts(scanner, file, size, nil)
+	return layouts, false, err
+}
+
+// scanLayouts scans the file for messages starting within locations (all messages if locations are empty).
+func scanLayouts(scanner MessageScanner, file string, size int, locations []common.Location) (
+	[]common.MessageLayout,
+	error,
+) {
+	msgCount, layoutsIt, err := scanner.Scan(file, size, locations)
+	if err != nil {
+		return nil, err
+	}
+	// allocate enough memory in one pass
+	layouts := make([]common.MessageLayout, 0, msgCount)
+	for scannedMessage := range layoutsIt {
+		layouts = append(layouts, scannedMessage.MessageLayout)
+	}
+	return layouts, nil
+}
+
 // scanFiles scans accessible files to build message layouts.
 // Returns a map of file paths to their message layouts and error if scanning fails.
-func (i *Ingestor) scanFiles(files map[string]int) (map[string][]common.MessageLayout, error) {
+func (i *Ingestor) scanFiles(scanner MessageScanner, files map[string]int) (map[string][]common.MessageLayout, error) {
 
 	// split files per workers
 	filePaths := slices.Collect(maps.Keys(files))
@@ -233,17 +727,12 @@ func (i *Ingestor) scanFiles(files map[string]int) (map[string][]common.MessageL
 		go func() {
 			defer wg.Done()
 			for _, f := range filesPerWorker[j] {
-				msgCount, layouts, err := Scan(f, files[f], i.messageRE.String(), nil)
+				layouts, err := scanLayouts(scanner, f, files[f], nil)
 				if err != nil {
 					i.logger.Error("Scan file", zap.String("file", f), zap.Error(err))
 					continue
 				}
-				// allocate enough memory in one pass
-				_layouts := make([]common.MessageLayout, 0, msgCount)
-				for sl := range layouts {
-					_layouts = append(_layouts, sl.MessageLayout)
-				}
-				fileLayouts[slices.Index(filePaths, f)] = _layouts
+				fileLayouts[slices.Index(filePaths, f)] = layouts
 			}
 		}()
 	}
@@ -1,15 +1,23 @@
 package ingest
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"iter"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"github.com/lezhnev74/inverted_index_2"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 
 	"heaplog_2024/internal"
 	"heaplog_2024/internal/common"
@@ -24,6 +32,18 @@ func (m *MockFileIndex) GetSegments() (map[string][]common.Location, error) {
 	return m.duck.GetSegments()
 }
 
+func (m *MockFileIndex) GetFiles() (map[string]common.IndexedFile, error) {
+	return m.duck.GetFiles()
+}
+
+func (m *MockFileIndex) PutFile(file string, source string, fp common.Fingerprint) error {
+	return m.duck.PutFile(file, source, fp)
+}
+
+func (m *MockFileIndex) RenameFile(from, to string) error {
+	return m.duck.RenameFile(from, to)
+}
+
 func (m *MockFileIndex) WipeSegment(file string, segment common.Location) error {
 	_, err := m.duck.WipeSegment(file, segment)
 	return err
@@ -43,8 +63,35 @@ func (m *MockFileIndex) WipeSegmentsForFiles(file string, segment common.Locatio
 	return err
 }
 
-func (m *MockFileIndex) PutSegment(file string, terms [][]byte, messages []common.Message) (int, error) {
-	return m.duck.PutSegment(file, messages)
+func (m *MockFileIndex) GetIndexOptions() (map[string]string, error) {
+	return m.duck.GetIndexOptions()
+}
+
+func (m *MockFileIndex) PutIndexOptions(source string, options string) error {
+	return m.duck.PutIndexOptions(source, options)
+}
+
+func (m *MockFileIndex) PutSegment(file string, segment common.Segment, terms [][]byte, messages []common.Message) (int, error) {
+	return m.duck.PutSegmentAt(file, segment, messages)
+}
+
+func (m *MockFileIndex) BlacklistFile(file string, dateErrors common.DateErrors) error {
+	return m.duck.BlacklistFile(file, dateErrors)
+}
+
+// recordingScanner remembers the locations of each scan
+type recordingScanner struct {
+	MessageScanner
+	scans [][]common.Location
+}
+
+func (s *recordingScanner) Scan(file string, fileSize int, locations []common.Location) (
+	int,
+	iter.Seq[ScannedMessage],
+	error,
+) {
+	s.scans = append(s.scans, locations)
+	return s.MessageScanner.Scan(file, fileSize, locations)
 }
 
 func TestIngestionDryRun(t *testing.T) {
@@ -69,7 +116,7 @@ func TestIngestionDryRun(t *testing.T) {
 func TestIngestionSegmentSizeChange(t *testing.T) {
 	fileName, contents := common.MakeTestFile(t)
 	ingestor, duck := makeTestIngestor(t, []string{fileName})
-	ingestor.segmentLen = 100_000_000
+	ingestor.segmentSize.Bytes = 100_000_000
 
 	// Put misaligned segment
 	_, err := duck.PutSegment(
@@ -87,7 +134,7 @@ func TestIngestionSegmentSizeChange(t *testing.T) {
 	require.NotEmpty(t, segments[fileName])
 
 	// new segment size
-	ingestor.segmentLen = 1
+	ingestor.segmentSize.Bytes = 1
 	require.NoError(t, ingestor.Run())
 
 	// make sure it did not wiped the indexed segment
@@ -102,7 +149,7 @@ func TestIngesting(t *testing.T) {
 	require.NoError(t, ingestor.Run())
 
 	// Analyze the state
-	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil)
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	messages := slices.Collect(messagesSeq)
 	require.Equal(t, len(common.LayoutsSampleLog1), len(messages))
@@ -110,7 +157,7 @@ func TestIngesting(t *testing.T) {
 	for _, l := range common.LayoutsSampleLog1 {
 		found := false
 		for _, m := range messages {
-			if l == m.Message {
+			if reflect.DeepEqual(l, m.Message) {
 				found = true
 				break
 			}
@@ -122,7 +169,7 @@ func TestIngesting(t *testing.T) {
 func TestMisalignedSegments(t *testing.T) {
 	fileName, _ := common.MakeTestFile(t)
 	ingestor, duck := makeTestIngestor(t, []string{fileName})
-	ingestor.segmentLen = 1_000_000
+	ingestor.segmentSize.Bytes = 1_000_000
 
 	// Put misaligned segment
 	_, err := duck.PutSegment(
@@ -157,7 +204,7 @@ func TestTrailingSegmentIndexing(t *testing.T) {
 	require.NoError(t, err)
 
 	ingestor, duck := makeTestIngestor(t, []string{testFile})
-	ingestor.segmentLen = 1_000_000 // make it big so the trailing segment is half-full
+	ingestor.segmentSize.Bytes = 1_000_000 // make it big so the trailing segment is half-full
 
 	// Run
 	require.NoError(t, ingestor.Run())
@@ -195,6 +242,431 @@ func TestTrailingSegmentIndexing(t *testing.T) {
 	require.Equal(t, expected, fileSegments)
 }
 
+func TestTrailingSegmentMessagesCap(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	ingestor.segmentSize = SegmentSize{Bytes: 1_000_000, Messages: 4}
+	require.NoError(t, ingestor.Run())
+
+	segmentMessages := func() (counts []int) {
+		fileSegments, err := duck.GetSegments()
+		require.NoError(t, err)
+		messages, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+		require.NoError(t, err)
+		all := slices.Collect(messages)
+		for _, segment := range fileSegments[testFile] {
+			n := 0
+			for _, m := range all {
+				if segment.Contains(m.Loc.From) {
+					n++
+				}
+			}
+			counts = append(counts, n)
+		}
+		return counts
+	}
+	require.Equal(t, []int{4, 4, 2}, segmentMessages())
+
+	// the trailing segment is not full, so it takes new messages
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1 + common.SampleLog1)}))
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, []int{4, 4, 4, 4, 4}, segmentMessages())
+
+	// a larger size joins the trailing segment with new messages once
+	ingestor.segmentSize = SegmentSize{Bytes: 1_000_000, Messages: 10}
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(strings.Repeat(common.SampleLog1, 3))}))
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, []int{4, 4, 4, 4, 10, 4}, segmentMessages())
+}
+
+func TestTailScanning(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+
+	// the first run scans the entire file
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, [][]common.Location{nil}, scanner.scans)
+	fileSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	lastSegment := fileSegments[testFile][len(fileSegments[testFile])-1]
+
+	// the next run scans only the tail starting at the last indexed segment
+	newLog := common.SampleLog1 + common.SampleLog1
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(newLog)}))
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, [][]common.Location{{{From: lastSegment.From, To: len(newLog)}}}, scanner.scans)
+
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog1)*2)
+
+	fileSegments, err = duck.GetSegments()
+	require.NoError(t, err)
+	merged := common.MergeLocations(fileSegments[testFile])
+	require.Equal(t, []common.Location{{From: 1, To: len(newLog)}}, merged)
+}
+
+func TestTailScanningChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+	require.NoError(t, ingestor.Run())
+
+	// the file changed before the last indexed segment: the changed head is detected by the fingerprint,
+	// so the whole file is re-scanned and re-indexed right away
+	newLog := "\n\n" + common.SampleLog1 + common.SampleLog1
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(newLog)}))
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, [][]common.Location{nil}, scanner.scans)
+
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	messages := slices.Collect(messagesSeq)
+	require.Len(t, messages, len(common.LayoutsSampleLog1)*2)
+	for _, m := range messages {
+		require.Equal(t, byte('['), newLog[m.Loc.From])
+	}
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	appFile := filepath.Join(dir, "app.log")
+	workerFile := filepath.Join(dir, "worker.log")
+	require.NoError(
+		t, common.PopulateFiles(
+			map[string][]byte{
+				appFile:    []byte(common.SampleLog1),
+				workerFile: []byte(common.SampleLog2),
+			},
+		),
+	)
+
+	ingestor, duck := makeTestIngestor(t, []string{appFile})
+	workerSource := ingestor.sources[0]
+	workerSource.Name = "worker"
+	workerSource.Globs = []string{filepath.Join(dir, "*.log")} // also matches the app file
+	ingestor.sources = append(ingestor.sources, workerSource)
+	require.NoError(t, ingestor.Run())
+
+	files, err := duck.GetFiles()
+	require.NoError(t, err)
+	require.Equal(t, "default", files[appFile].Source)
+	require.Equal(t, "worker", files[workerFile].Source)
+
+	// the file moves to another source: it is re-indexed
+	ingestor.sources = ingestor.sources[1:]
+	require.NoError(t, ingestor.Run())
+
+	files, err = duck.GetFiles()
+	require.NoError(t, err)
+	require.Equal(t, "worker", files[appFile].Source)
+	require.Equal(t, "worker", files[workerFile].Source)
+
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, []string{"worker"}, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog1)+len(common.LayoutsSampleLog2))
+}
+
+func TestIngestingFields(t *testing.T) {
+	fileName, _ := common.MakeTestFile(t)
+	ingestor, duck := makeTestIngestor(t, []string{fileName})
+	re := regexp.MustCompile(common.MessageStartPattern + ` (?P<action>\w+)`)
+	ingestor.sources[0].Scanner = NewNativeScanner(re, common.NewOpener(nil))
+	require.NoError(t, ingestor.Run())
+
+	messagesSeq, err := duck.GetMessages(
+		context.Background(), nil, nil, common.FieldsFilter{"action": {"User", "Cache"}}, nil, nil,
+	)
+	require.NoError(t, err)
+	var actions []string
+	for m := range messagesSeq {
+		actions = append(actions, m.Fields["action"])
+	}
+	require.Equal(t, []string{"User", "User", "Cache"}, actions)
+}
+
+func TestRenamedFile(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	rotatedFile := filepath.Join(dir, "test.log.1")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{filepath.Join(dir, "*")})
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+	require.NoError(t, ingestor.Run())
+	segments, err := duck.GetSegments()
+	require.NoError(t, err)
+
+	// the file is rotated by renaming: its segments follow it without re-indexing,
+	// the new file at the old path is indexed from scratch
+	require.NoError(t, os.Rename(testFile, rotatedFile))
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog2)}))
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	fullScans := slices.DeleteFunc(slices.Clone(scanner.scans), func(l []common.Location) bool { return l != nil })
+	require.Len(t, fullScans, 1) // only the new file is scanned entirely
+
+	newSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, segments[testFile], newSegments[rotatedFile])
+	require.NotEmpty(t, newSegments[testFile])
+
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog1)+len(common.LayoutsSampleLog2))
+}
+
+func TestChangedIndexOptions(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+	ingestor.sources[0].IndexOptions = "suffix"
+	require.NoError(t, ingestor.Run())
+	segments, err := duck.GetSegments()
+	require.NoError(t, err)
+
+	// a restart with the same options keeps the index
+	ingestor.optionsChecked = false
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Len(t, slices.DeleteFunc(slices.Clone(scanner.scans), func(l []common.Location) bool { return l != nil }), 0)
+
+	// other options index the file again
+	ingestor.optionsChecked = false
+	ingestor.sources[0].IndexOptions = "ngram"
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Len(t, slices.DeleteFunc(slices.Clone(scanner.scans), func(l []common.Location) bool { return l != nil }), 1)
+	options, err := duck.GetIndexOptions()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"default": "ngram"}, options)
+
+	newSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, len(segments[testFile]), len(newSegments[testFile]))
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog1))
+}
+
+func TestTruncatedFile(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: []byte(common.SampleLog1 + common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	ingestor.segmentSize.Bytes = 1_000_000
+	require.NoError(t, ingestor.Run())
+
+	// copytruncate: the file is truncated in place and receives new messages
+	f, err := os.OpenFile(testFile, os.O_RDWR, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(0))
+	_, err = f.WriteAt([]byte(common.SampleLog2), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, ingestor.Run())
+
+	fileSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, []common.Location{{From: 1, To: len(common.SampleLog2)}}, common.MergeLocations(fileSegments[testFile]))
+
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog2))
+}
+
+func TestCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.log.gz")
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte(common.SampleLog1))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, common.PopulateFiles(map[string][]byte{testFile: compressed.Bytes()}))
+
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+	require.NoError(t, ingestor.Run())
+
+	// messages are located by uncompressed offsets
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	bufPool := common.NewBufferPool([]int{1_024})
+	var messages []common.Message
+	for m, err := range common.ReadMessages(context.Background(), common.NewOpener(nil), bufPool, messagesSeq) {
+		require.NoError(t, err)
+		require.Equal(t, common.SampleLog1[m.Loc.From:m.Loc.To], string(m.Body))
+		messages = append(messages, m.Message)
+	}
+	require.ElementsMatch(t, common.LayoutsSampleLog1, messages)
+
+	// compressed files are immutable, so they are not scanned again
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Empty(t, scanner.scans)
+
+	// a partially indexed file is indexed up to the end
+	_, err = duck.WipeSegment(testFile, common.Location{From: 571, To: 623})
+	require.NoError(t, err)
+	require.NoError(t, ingestor.Run())
+	messagesSeq, err = duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messagesSeq), len(common.LayoutsSampleLog1))
+}
+
+func TestBlacklistedFileSkipped(t *testing.T) {
+	testFile, _ := common.MakeTestFile(t)
+	ingestor, duck := makeTestIngestor(t, []string{testFile})
+	badDate := "2024-07-29T01:07:21.923832+00:00" // the second message
+	ingestor.sources[0].Indexer = NewIndexer(
+		context.Background(),
+		zap.NewNop(),
+		func(i []byte) [][]byte { return [][]byte{[]byte("test token")} },
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
+			if string(b) == badDate {
+				return time.Time{}, fmt.Errorf("bad date")
+			}
+			return time.Parse(common.TimeFormat, string(b))
+		}),
+		DateErrorBlacklist,
+		1,
+		common.NewOpener(nil),
+	)
+	scanner := &recordingScanner{MessageScanner: ingestor.sources[0].Scanner}
+	ingestor.sources[0].Scanner = scanner
+	require.NoError(t, ingestor.Run())
+
+	degraded, err := duck.GetDegradedFiles()
+	require.NoError(t, err)
+	require.Len(t, degraded, 1)
+	require.True(t, degraded[0].Blacklisted)
+	require.Equal(t, badDate, degraded[0].Sample)
+
+	// the blacklisting is persisted, the file is not scanned again while it is the same
+	scanner.scans = nil
+	require.NoError(t, ingestor.Run())
+	require.Empty(t, scanner.scans)
+
+	// a changed file is indexed again
+	f, err := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("[2024-07-30T07:00:00.000000+00:00] Rotated\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, ingestor.Run())
+	require.NotEmpty(t, scanner.scans)
+}
+
+func TestDateParserRemembered(t *testing.T) {
+	testFile, _ := common.MakeTestFile(t)
+	ingestor, _ := makeTestIngestor(t, []string{testFile})
+
+	// the parser tries the layout that matched last first, tried layouts are recorded
+	layouts := []string{time.DateOnly, common.TimeFormat}
+	var (
+		parsers int
+		tried   []string
+	)
+	ingestor.sources[0].Indexer = NewIndexer(
+		context.Background(),
+		zap.NewNop(),
+		func(i []byte) [][]byte { return [][]byte{[]byte("test token")} },
+		nil,
+		func() func([]byte) (time.Time, error) {
+			parsers++
+			last := 0
+			return func(b []byte) (time.Time, error) {
+				for j := range layouts {
+					k := (last + j) % len(layouts)
+					tried = append(tried, layouts[k])
+					d, err := time.Parse(layouts[k], string(b))
+					if err == nil {
+						last = k
+						return d, nil
+					}
+				}
+				return time.Time{}, fmt.Errorf("bad date")
+			}
+		},
+		DateErrorSkip,
+		1,
+		common.NewOpener(nil),
+	)
+	require.NoError(t, ingestor.Run())
+	require.Equal(t, time.DateOnly, tried[0])
+
+	// the second run parses the new message with the layout remembered by the first run
+	f, err := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("[2024-07-30T07:00:00.000000+00:00] Appended\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	tried = nil
+	require.NoError(t, ingestor.Run())
+	require.NotEmpty(t, tried)
+	require.Equal(t, common.TimeFormat, tried[0])
+	require.Equal(t, 1, parsers)
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "test1.log")
+	file2 := filepath.Join(dir, "test2.log")
+	require.NoError(
+		t, common.PopulateFiles(
+			map[string][]byte{
+				file1: []byte(common.SampleLog1),
+				file2: []byte(common.SampleLog2),
+			},
+		),
+	)
+	ingestor, duck := makeTestIngestor(t, []string{filepath.Join(dir, "*.log")})
+
+	// only the given file is ingested
+	require.NoError(t, ingestor.RunFiles([]string{file2, filepath.Join(dir, "unknown.log")}))
+	fileSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	require.Len(t, fileSegments, 1)
+	require.NotEmpty(t, fileSegments[file2])
+
+	// a removed file is wiped, other files are not touched
+	require.NoError(t, os.Remove(file2))
+	require.NoError(t, ingestor.RunFiles([]string{file2}))
+	fileSegments, err = duck.GetSegments()
+	require.NoError(t, err)
+	require.Empty(t, fileSegments)
+
+	require.NoError(t, ingestor.Run())
+	fileSegments, err = duck.GetSegments()
+	require.NoError(t, err)
+	require.Len(t, fileSegments, 1)
+	require.NotEmpty(t, fileSegments[file1])
+}
+
 func TestReconcileMissing(t *testing.T) {
 	fileName, _ := common.MakeTestFile(t)
 	ingestor, duck := makeTestIngestor(t, []string{fileName})
@@ -206,14 +678,15 @@ func TestReconcileMissing(t *testing.T) {
 		},
 	)
 	require.NoError(t, err)
-	messagesSeq1, err := duck.GetMessages(context.Background(), nil, nil, nil)
+	messagesSeq1, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	messages1 := slices.Collect(messagesSeq1)
 	require.Equal(
 		t,
 		[]common.FileMessage{
 			{
-				File: "unknown",
+				File:   "unknown",
+				Source: "default",
 				Message: common.Message{
 					MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}}, Date: common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
 				},
@@ -226,7 +699,7 @@ func TestReconcileMissing(t *testing.T) {
 	require.NoError(t, ingestor.Run())
 
 	// Analyze the state
-	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil)
+	messagesSeq, err := duck.GetMessages(context.Background(), nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	messages := slices.Collect(messagesSeq)
 	require.Equal(t, len(common.LayoutsSampleLog1), len(messages))
@@ -234,7 +707,7 @@ func TestReconcileMissing(t *testing.T) {
 	for _, l := range common.LayoutsSampleLog1 {
 		found := false
 		for _, m := range messages {
-			if l == m.Message {
+			if reflect.DeepEqual(l, m.Message) {
 				found = true
 				break
 			}
@@ -246,26 +719,37 @@ func TestReconcileMissing(t *testing.T) {
 func makeTestIngestor(t *testing.T, globs []string) (*Ingestor, *persistence.DuckDB) {
 	logger, err := internal.NewLogger("test")
 	require.NoError(t, err)
+	opener := common.NewOpener(nil)
 	indexer := NewIndexer(
 		context.Background(),
 		logger,
 		func(i []byte) [][]byte {
 			return [][]byte{[]byte("test token")}
 		},
-		func(b []byte) (time.Time, error) {
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
 			return time.Parse(common.TimeFormat, string(b))
-		},
+		}),
+		DateErrorSkip,
+		1,
+		opener,
 	)
 	duck, err := persistence.NewDuckDB(context.Background(), "", logger)
 	require.NoError(t, err)
 	ingestor := NewIngestor(
-		globs,
-		regexp.MustCompile(common.MessageStartPattern),
-		1,
+		[]Source{
+			{
+				Name:    "default",
+				Globs:   globs,
+				Scanner: NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), opener),
+				Indexer: indexer,
+			},
+		},
+		SegmentSize{Bytes: 1},
 		1,
 		&MockFileIndex{duck},
+		opener,
 		logger,
-		indexer,
 	)
 	return ingestor, duck
 }
@@ -278,24 +762,35 @@ func _TestManual(t *testing.T) {
 	ii, err := inverted_index_2.NewInvertedIndex(".", false)
 	require.NoError(t, err)
 	tokenize := func(b []byte) [][]byte { return common.Tokenize(b, 4, 8) }
+	opener := common.NewOpener(nil)
 	indexer := NewIndexer(
 		context.Background(),
 		logger,
 		tokenize,
-		func(b []byte) (time.Time, error) {
+		nil,
+		fileDates(func(b []byte) (time.Time, error) {
 			return time.Parse(common.TimeFormat, string(b))
-		},
+		}),
+		DateErrorSkip,
+		1,
+		opener,
 	)
 	persistentIndex, err := persistence.NewPersistentIndex(duck, ii)
 	require.NoError(t, err)
 	ingestor := NewIngestor(
-		[]string{"./logs/*.log"},
-		regexp.MustCompile(common.MessageStartPattern),
-		5_000_000,
+		[]Source{
+			{
+				Name:    "default",
+				Globs:   []string{"./logs/*.log"},
+				Scanner: NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), opener),
+				Indexer: indexer,
+			},
+		},
+		SegmentSize{Bytes: 5_000_000},
 		1,
 		persistentIndex,
+		opener,
 		logger,
-		indexer,
 	)
 
 	cancel, err := common.Profile()
@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+
+	"heaplog_2024/internal/common"
+)
+
+// JSONScanner finds messages in NDJSON files: every non-empty line is a message.
+// The date of a message is the value of the configured field.
+type JSONScanner struct {
+	// dot-separated path to the date field, example: "meta.time"
+	dateField string
+	// dot-separated paths of values extracted as fields of messages, example: "ctx.user_id".
+	// "prefix.*" extracts all values under the prefix, they are named without it.
+	fields []string
+	opener *common.Opener
+}
+
+func NewJSONScanner(dateField string, fields []string, opener *common.Opener) *JSONScanner {
+	return &JSONScanner{dateField: dateField, fields: fields, opener: opener}
+}
+
+// Scan streams the file from the earliest location and returns all messages within the given locations.
+// Returns NoMessageStartFound error if no messages are found in the stream.
+func (s *JSONScanner) Scan(file string, fileSize int, locations []common.Location) (
+	count int,
+	layouts iter.Seq[ScannedMessage],
+	err error,
+) {
+	f, err := s.opener.OpenFile(file)
+	if err != nil {
+		return 0, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	start, err := lineStartAt(f, scanStart(locations))
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	stream := io.NewSectionReader(f, int64(start), int64(max(f.Len()-start, 0)))
+
+	starts, err := s.findStarts(stream, start)
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	if len(starts) == 0 && start == 0 {
+		return 0, nil, NoMessageStartFound
+	}
+
+	messages := layoutsFromStarts(starts, fileSize, locations)
+	return len(messages), slices.Values(messages), nil
+}
+
+// findStarts reads the stream line by line, every non-empty line starts a message.
+// The stream begins at pos in the file, reported positions are absolute in the file.
+func (s *JSONScanner) findStarts(r io.Reader, pos int) ([]common.MessageLayout, error) {
+	var (
+		starts []common.MessageLayout
+		line   []byte // accumulates lines longer than the read buffer
+	)
+
+	br := bufio.NewReaderSize(r, nativeScannerBufSize)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			line = append(line, chunk...)
+			continue
+		}
+		if len(line) > 0 {
+			chunk = append(line, chunk...)
+			line = line[:0]
+		}
+
+		if len(bytes.TrimSpace(chunk)) > 0 {
+			starts = append(starts, s.layout(pos, chunk))
+		}
+		pos += len(chunk)
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return starts, nil
+}
+
+// layout locates the message, its date and fields in the line located at pos in the file.
+// If the date field is not found, the date location is empty.
+func (s *JSONScanner) layout(pos int, line []byte) common.MessageLayout {
+	l := common.MessageLayout{Loc: common.Location{From: pos}}
+	l.DateLoc = common.Location{From: pos, To: pos}
+	dateFound := false
+	common.ScanJSONFields(
+		line, func(f common.JSONField) bool {
+			loc := common.Location{From: pos + f.Loc.From, To: pos + f.Loc.To}
+			if f.Path == s.dateField && !dateFound {
+				l.DateLoc, dateFound = loc, true
+			}
+			if name, ok := s.fieldName(f.Path); ok {
+				if l.FieldLocs == nil {
+					l.FieldLocs = make(map[string]common.Location)
+				}
+				l.FieldLocs[name] = loc
+			}
+			return !dateFound || len(s.fields) > 0
+		},
+	)
+	return l
+}
+
+// fieldName returns the name of the extracted field at the path
+func (s *JSONScanner) fieldName(path string) (string, bool) {
+	for _, f := range s.fields {
+		if prefix, ok := strings.CutSuffix(f, "*"); ok {
+			if name, ok := strings.CutPrefix(path, prefix); ok && name != "" {
+				return name, true
+			}
+		} else if f == path {
+			return path, true
+		}
+	}
+	return "", false
+}
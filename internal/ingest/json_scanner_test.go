@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestJSONScanner(t *testing.T) {
+	lines := []string{
+		`{"meta":{"time":"2024-07-30T00:00:04Z"},"msg":"first"}`,
+		``, // empty lines are skipped
+		`{"msg":"no date"}`,
+		`{"msg":"` + strings.Repeat("x", nativeScannerBufSize) + `","meta":{"time":"2024-07-30T00:00:06Z"}}`,
+	}
+	stream := strings.Join(lines, "\n") + "\n"
+	filePath := filepath.Join(t.TempDir(), "sample.ndjson")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	count, messages, err := NewJSONScanner("meta.time", nil, common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	layouts := toMessageLayouts(slices.Collect(messages))
+	require.Len(t, layouts, 3)
+
+	require.Equal(t, common.Location{From: 0, To: len(lines[0]) + 2}, layouts[0].Loc)
+	require.Equal(t, "2024-07-30T00:00:04Z", stream[layouts[0].DateLoc.From:layouts[0].DateLoc.To])
+
+	require.Equal(t, layouts[1].Loc.From, layouts[1].DateLoc.From) // no date
+	require.Equal(t, layouts[1].Loc.From, layouts[1].DateLoc.To)
+
+	require.Equal(t, len(stream), layouts[2].Loc.To)
+	require.Equal(t, "2024-07-30T00:00:06Z", stream[layouts[2].DateLoc.From:layouts[2].DateLoc.To])
+}
+
+func TestJSONScannerFields(t *testing.T) {
+	stream := `{"time":1,"level":"error","resource":{"service.name":"api","host":{"name":"h1"}},"msg":"m"}` + "\n"
+	filePath := filepath.Join(t.TempDir(), "sample.ndjson")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	_, messages, err := NewJSONScanner("time", []string{"level", "resource.*"}, common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+	require.NoError(t, err)
+	layouts := toMessageLayouts(slices.Collect(messages))
+	require.Len(t, layouts, 1)
+
+	fields := make(map[string]string)
+	for name, loc := range layouts[0].FieldLocs {
+		fields[name] = stream[loc.From:loc.To]
+	}
+	require.Equal(t, map[string]string{"level": "error", "service.name": "api", "host.name": "h1"}, fields)
+	require.Equal(t, "1", stream[layouts[0].DateLoc.From:layouts[0].DateLoc.To])
+}
@@ -0,0 +1,121 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"regexp"
+	"slices"
+
+	"heaplog_2024/internal/common"
+)
+
+// nativeScannerBufSize is the read buffer of the native scanner.
+// Lines longer than that are only matched by their first nativeScannerBufSize bytes.
+const nativeScannerBufSize = 64 * 1024
+
+// NativeScanner finds message boundaries with Go's regexp engine.
+// It streams the file line by line and matches the message start pattern against each line,
+// so it behaves like "ug" which is also line-oriented.
+// Named capture groups other than the first (date) group are extracted as message fields,
+// example: `^\[(\d{4}-\d{2}-\d{2})\] (?P<level>\w+)`.
+// Note that the pattern uses Go's RE2 syntax, not PCRE.
+type NativeScanner struct {
+	re     *regexp.Regexp
+	opener *common.Opener
+}
+
+func NewNativeScanner(re *regexp.Regexp, opener *common.Opener) *NativeScanner {
+	return &NativeScanner{re: re, opener: opener}
+}
+
+// Scan streams the file from the earliest location and returns all message offsets within the given locations.
+// Returns NoMessageStartFound error if no messages are found in the stream.
+func (s *NativeScanner) Scan(file string, fileSize int, locations []common.Location) (
+	count int,
+	layouts iter.Seq[ScannedMessage],
+	err error,
+) {
+	f, err := s.opener.OpenFile(file)
+	if err != nil {
+		return 0, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	start, err := lineStartAt(f, scanStart(locations))
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	stream := io.NewSectionReader(f, int64(start), int64(max(f.Len()-start, 0)))
+
+	starts, err := s.findStarts(stream, start)
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
+	}
+	if len(starts) == 0 && start == 0 {
+		return 0, nil, NoMessageStartFound
+	}
+
+	messages := layoutsFromStarts(starts, fileSize, locations)
+	return len(messages), slices.Values(messages), nil
+}
+
+// findStarts reads the stream line by line and reports every match of the pattern.
+// The stream begins at pos in the file, reported positions are absolute in the file.
+func (s *NativeScanner) findStarts(r io.Reader, pos int) ([]common.MessageLayout, error) {
+	var (
+		starts    []common.MessageLayout
+		lineStart = true // false when the buffer split a long line
+	)
+
+	br := bufio.NewReaderSize(r, nativeScannerBufSize)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 && lineStart {
+			starts = s.appendLineMatches(starts, pos, line)
+		}
+		pos += len(line)
+		lineStart = len(line) > 0 && line[len(line)-1] == '\n'
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		} else if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return starts, nil
+}
+
+// appendLineMatches matches the pattern against a single line located at pos in the stream.
+func (s *NativeScanner) appendLineMatches(starts []common.MessageLayout, pos int, line []byte) []common.MessageLayout {
+	// the trailing newline is not a part of the line, so "$" matches as in line-oriented tools
+	if line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+
+	for _, m := range s.re.FindAllSubmatchIndex(line, -1) {
+		l := common.MessageLayout{}
+		l.Loc.From = pos + m[0]
+		l.DateLoc = common.Location{From: l.Loc.From, To: l.Loc.From} // no date group
+		if len(m) >= 4 && m[2] >= 0 {
+			l.DateLoc = common.Location{From: pos + m[2], To: pos + m[3]}
+		}
+		// named groups (except the date group) are fields of the message
+		for i, name := range s.re.SubexpNames() {
+			if i < 2 || name == "" || m[2*i] < 0 {
+				continue
+			}
+			if l.FieldLocs == nil {
+				l.FieldLocs = make(map[string]common.Location)
+			}
+			l.FieldLocs[name] = common.Location{From: pos + m[2*i], To: pos + m[2*i+1]}
+		}
+		starts = append(starts, l)
+	}
+	return starts
+}
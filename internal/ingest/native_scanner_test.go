@@ -0,0 +1,124 @@
+package ingest
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestNativeScannerLocations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "sample.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(scannerSample)}))
+
+	scanner := NewNativeScanner(regexp.MustCompile(MsgStartRe), common.NewOpener(nil))
+	for i, tt := range scannerLocationTests {
+		t.Run(
+			fmt.Sprintf("Test %d", i), func(t *testing.T) {
+				_, layouts, err := scanner.Scan(filePath, len(scannerSample), tt.locations)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedLayouts, slices.Collect(layouts))
+			},
+		)
+	}
+}
+
+func TestNativeScannerHuge(t *testing.T) {
+	hugeStream := strings.Repeat(scannerSample, 1000)
+	filePath := filepath.Join(t.TempDir(), "sample.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(hugeStream)}))
+
+	count, messages, err := NewNativeScanner(regexp.MustCompile(MsgStartRe), common.NewOpener(nil)).Scan(
+		filePath,
+		len(hugeStream),
+		[]common.Location{{From: 0, To: 1_000_000}},
+	)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messages), 3000)
+	require.Equal(t, count, 3000)
+}
+
+func TestNativeScannerLongLines(t *testing.T) {
+	// the second line is longer than the read buffer and contains a message-like text past the buffer,
+	// which must not be reported as the line is only matched from its beginning.
+	longLine := strings.Repeat("x", nativeScannerBufSize) + "[2024-07-30T00:00:05.000000+00:00] not a message"
+	stream := "[2024-07-30T00:00:04.769958+00:00] first\n" +
+		longLine + "\n" +
+		"[2024-07-30T00:00:06.000000+00:00] second"
+	filePath := filepath.Join(t.TempDir(), "sample.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	_, layouts, err := NewNativeScanner(regexp.MustCompile(common.MessageStartPattern), common.NewOpener(nil)).Scan(
+		filePath,
+		len(stream),
+		nil,
+	)
+	require.NoError(t, err)
+
+	secondFrom := strings.LastIndex(stream, "[")
+	expected := []ScannedMessage{
+		{
+			MessageLayout: common.MessageLayout{
+				Loc:     common.Location{From: 0, To: secondFrom},
+				DateLoc: common.Location{From: 1, To: 33},
+			},
+		},
+		{
+			MessageLayout: common.MessageLayout{
+				Loc:     common.Location{From: secondFrom, To: len(stream)},
+				DateLoc: common.Location{From: secondFrom + 1, To: secondFrom + 33},
+			},
+			IsTail: true,
+		},
+	}
+	require.Equal(t, expected, slices.Collect(layouts))
+}
+
+func TestNativeScannerNoMessages(t *testing.T) {
+	stream := "no messages here\nat all\n"
+	filePath := filepath.Join(t.TempDir(), "sample.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	_, _, err := NewNativeScanner(regexp.MustCompile(MsgStartRe), common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+	require.ErrorIs(t, err, NoMessageStartFound)
+}
+
+func TestNativeScannerFields(t *testing.T) {
+	stream := "[2024-07-30T00:00:04.769958+00:00] ERROR billing: first\n" +
+		"[2024-07-30T00:00:06.000000+00:00] INFO second"
+	filePath := filepath.Join(t.TempDir(), "sample.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{filePath: []byte(stream)}))
+
+	re := regexp.MustCompile(`^\[([^\]]+)\] (?P<level>[A-Z]+)(?: (?P<channel>\w+):)?`)
+	_, layouts, err := NewNativeScanner(re, common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+	require.NoError(t, err)
+
+	secondFrom := strings.LastIndex(stream, "[")
+	expected := []ScannedMessage{
+		{
+			MessageLayout: common.MessageLayout{
+				Loc:     common.Location{From: 0, To: secondFrom},
+				DateLoc: common.Location{From: 1, To: 33},
+				FieldLocs: map[string]common.Location{
+					"level":   {From: 35, To: 40},
+					"channel": {From: 41, To: 48},
+				},
+			},
+		},
+		{
+			MessageLayout: common.MessageLayout{
+				Loc:       common.Location{From: secondFrom, To: len(stream)},
+				DateLoc:   common.Location{From: secondFrom + 1, To: secondFrom + 33},
+				FieldLocs: map[string]common.Location{"level": {From: secondFrom + 35, To: secondFrom + 39}},
+			},
+			IsTail: true,
+		},
+	}
+	require.Equal(t, expected, slices.Collect(layouts))
+}
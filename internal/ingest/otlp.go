@@ -0,0 +1,504 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"heaplog_2024/internal/common"
+)
+
+// OpenTelemetry log records are written to heap files as NDJSON lines, these settings make a source of them.
+// Resource attributes and the severity are extracted as fields (named without the "resource." prefix).
+const (
+	OTLPDateField  = "time_unix_nano"
+	OTLPDateFormat = common.LayoutUnixNano
+)
+
+// OTLPFields are paths of extracted fields in lines of log records
+var OTLPFields = []string{"severity", "resource.*"}
+
+// OTLPLogRecord is a log record received over OTLP with its resource and scope
+type OTLPLogRecord struct {
+	TimeUnixNano   uint64         `json:"time_unix_nano"`
+	Severity       string         `json:"severity,omitempty"`
+	SeverityNumber int64          `json:"severity_number,omitempty"`
+	Body           any            `json:"body,omitempty"`
+	Attributes     map[string]any `json:"attributes,omitempty"`
+	Resource       map[string]any `json:"resource,omitempty"`
+	Scope          string         `json:"scope,omitempty"`
+	TraceID        string         `json:"trace_id,omitempty"`
+	SpanID         string         `json:"span_id,omitempty"`
+}
+
+// OTLPLines decodes log records of the OTLP request body into NDJSON lines,
+// the content type is "application/x-protobuf" or "application/json".
+func OTLPLines(body []byte, contentType string, received time.Time) ([][]byte, error) {
+	var (
+		records []OTLPLogRecord
+		err     error
+	)
+	switch contentType {
+	case "application/x-protobuf":
+		records, err = DecodeOTLPLogsProto(body, received)
+	case "application/json":
+		records, err = DecodeOTLPLogsJSON(body, received)
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([][]byte, 0, len(records))
+	for _, r := range records {
+		line, err := r.Line()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// Line formats the record as an NDJSON line, the line break is not included.
+// Decoders store non-finite doubles as strings, JSON has no numbers for them.
+func (r OTLPLogRecord) Line() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// normalize fills the time and the severity text if the record has none
+func (r *OTLPLogRecord) normalize(observed uint64, received time.Time) {
+	if r.TimeUnixNano == 0 {
+		r.TimeUnixNano = observed
+	}
+	if r.TimeUnixNano == 0 {
+		r.TimeUnixNano = uint64(received.UnixNano())
+	}
+	if r.Severity == "" && r.SeverityNumber > 0 {
+		// severity numbers are grouped by 4: TRACE 1-4, DEBUG 5-8, INFO 9-12, WARN 13-16, ERROR 17-20, FATAL 21-24
+		names := []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+		r.Severity = names[min(int(r.SeverityNumber-1)/4, len(names)-1)]
+	}
+}
+
+// DecodeOTLPLogsJSON decodes ExportLogsServiceRequest in the OTLP/JSON encoding.
+// Records without a timestamp get the observed time or the time of receiving.
+func DecodeOTLPLogsJSON(b []byte, received time.Time) ([]OTLPLogRecord, error) {
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []otlpJSONKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				LogRecords []struct {
+					TimeUnixNano         otlpJSONUint64     `json:"timeUnixNano"`
+					ObservedTimeUnixNano otlpJSONUint64     `json:"observedTimeUnixNano"`
+					SeverityNumber       int64              `json:"severityNumber"`
+					SeverityText         string             `json:"severityText"`
+					Body                 *otlpJSONAnyValue  `json:"body"`
+					Attributes           []otlpJSONKeyValue `json:"attributes"`
+					TraceID              string             `json:"traceId"`
+					SpanID               string             `json:"spanId"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	err := json.Unmarshal(b, &req)
+	if err != nil {
+		return nil, fmt.Errorf("decode OTLP logs: %w", err)
+	}
+
+	var records []OTLPLogRecord
+	for _, rl := range req.ResourceLogs {
+		resource := otlpJSONAttributes(rl.Resource.Attributes)
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				r := OTLPLogRecord{
+					TimeUnixNano:   uint64(lr.TimeUnixNano),
+					Severity:       lr.SeverityText,
+					SeverityNumber: lr.SeverityNumber,
+					Attributes:     otlpJSONAttributes(lr.Attributes),
+					Resource:       resource,
+					Scope:          sl.Scope.Name,
+					TraceID:        lr.TraceID,
+					SpanID:         lr.SpanID,
+				}
+				if lr.Body != nil {
+					r.Body = lr.Body.value()
+				}
+				r.normalize(uint64(lr.ObservedTimeUnixNano), received)
+				records = append(records, r)
+			}
+		}
+	}
+	return records, nil
+}
+
+// otlpJSONUint64 is a 64-bit integer, OTLP/JSON encodes it as a string (numbers are accepted too)
+type otlpJSONUint64 uint64
+
+func (u *otlpJSONUint64) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	*u = otlpJSONUint64(v)
+	return err
+}
+
+// otlpJSONInt64 is a signed 64-bit integer, encoded like otlpJSONUint64
+type otlpJSONInt64 int64
+
+func (i *otlpJSONInt64) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	*i = otlpJSONInt64(v)
+	return err
+}
+
+// otlpJSONDouble is a double, OTLP/JSON encodes NaN and infinities as strings "NaN", "Infinity" and "-Infinity"
+type otlpJSONDouble float64
+
+func (d *otlpJSONDouble) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case `"NaN"`:
+		*d = otlpJSONDouble(math.NaN())
+	case `"Infinity"`:
+		*d = otlpJSONDouble(math.Inf(1))
+	case `"-Infinity"`:
+		*d = otlpJSONDouble(math.Inf(-1))
+	default:
+		var v float64
+		err := json.Unmarshal(b, &v)
+		*d = otlpJSONDouble(v)
+		return err
+	}
+	return nil
+}
+
+// otlpDouble returns the double as is, or NaN and infinities as strings in the OTLP/JSON encoding
+func otlpDouble(v float64) any {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return v
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string         `json:"stringValue"`
+	BoolValue   *bool           `json:"boolValue"`
+	IntValue    *otlpJSONInt64  `json:"intValue"` // int64 as a string
+	DoubleValue *otlpJSONDouble `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"` // base64
+}
+
+func (v otlpJSONAnyValue) value() any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return otlpDouble(float64(*v.DoubleValue))
+	case v.ArrayValue != nil:
+		values := make([]any, 0, len(v.ArrayValue.Values))
+		for _, av := range v.ArrayValue.Values {
+			values = append(values, av.value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		return otlpJSONAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	}
+	return nil
+}
+
+func otlpJSONAttributes(kvs []otlpJSONKeyValue) map[string]any {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.value()
+	}
+	return attrs
+}
+
+// DecodeOTLPLogsProto decodes ExportLogsServiceRequest in the protobuf encoding.
+// Only fields of log records that heaplog stores are decoded, others are skipped.
+// Records without a timestamp get the observed time or the time of receiving.
+func DecodeOTLPLogsProto(b []byte, received time.Time) ([]OTLPLogRecord, error) {
+	var records []OTLPLogRecord
+	err := protoFields(b, func(num int, wt protoWireType, v protoValue) error {
+		if num != 1 || wt != protoBytes { // resource_logs
+			return nil
+		}
+		return decodeResourceLogs(v.bytes, received, &records)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decode OTLP logs: %w", err)
+	}
+	return records, nil
+}
+
+func decodeResourceLogs(b []byte, received time.Time, records *[]OTLPLogRecord) error {
+	var (
+		resource  map[string]any
+		scopeLogs [][]byte
+	)
+	err := protoFields(b, func(num int, wt protoWireType, v protoValue) error {
+		if wt != protoBytes {
+			return nil
+		}
+		switch num {
+		case 1: // resource
+			return protoFields(v.bytes, func(num int, wt protoWireType, v protoValue) error {
+				if num == 1 && wt == protoBytes { // attributes
+					return decodeKeyValue(v.bytes, &resource)
+				}
+				return nil
+			})
+		case 2: // scope_logs, decoded once the resource is known (fields may come in any order)
+			scopeLogs = append(scopeLogs, v.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sl := range scopeLogs {
+		var (
+			scope string
+			logs  [][]byte
+		)
+		err = protoFields(sl, func(num int, wt protoWireType, v protoValue) error {
+			if wt != protoBytes {
+				return nil
+			}
+			switch num {
+			case 1: // scope
+				return protoFields(v.bytes, func(num int, wt protoWireType, v protoValue) error {
+					if num == 1 && wt == protoBytes { // name
+						scope = string(v.bytes)
+					}
+					return nil
+				})
+			case 2: // log_records
+				logs = append(logs, v.bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			r := OTLPLogRecord{Resource: resource, Scope: scope}
+			var observed uint64
+			err = protoFields(l, func(num int, wt protoWireType, v protoValue) error {
+				switch {
+				case num == 1 && wt == protoFixed64:
+					r.TimeUnixNano = v.num
+				case num == 2 && wt == protoVarint:
+					r.SeverityNumber = int64(v.num)
+				case num == 3 && wt == protoBytes:
+					r.Severity = string(v.bytes)
+				case num == 5 && wt == protoBytes:
+					body, err := decodeAnyValue(v.bytes)
+					r.Body = body
+					return err
+				case num == 6 && wt == protoBytes:
+					return decodeKeyValue(v.bytes, &r.Attributes)
+				case num == 9 && wt == protoBytes:
+					r.TraceID = hex.EncodeToString(v.bytes)
+				case num == 10 && wt == protoBytes:
+					r.SpanID = hex.EncodeToString(v.bytes)
+				case num == 11 && wt == protoFixed64:
+					observed = v.num
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			r.normalize(observed, received)
+			*records = append(*records, r)
+		}
+	}
+	return nil
+}
+
+// decodeKeyValue decodes KeyValue into the map, the map is created if nil
+func decodeKeyValue(b []byte, attrs *map[string]any) error {
+	var (
+		key   string
+		value any
+	)
+	err := protoFields(b, func(num int, wt protoWireType, v protoValue) error {
+		if wt != protoBytes {
+			return nil
+		}
+		var err error
+		switch num {
+		case 1:
+			key = string(v.bytes)
+		case 2:
+			value, err = decodeAnyValue(v.bytes)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if *attrs == nil {
+		*attrs = make(map[string]any)
+	}
+	(*attrs)[key] = value
+	return nil
+}
+
+// decodeAnyValue decodes the AnyValue oneof
+func decodeAnyValue(b []byte) (any, error) {
+	var value any
+	err := protoFields(b, func(num int, wt protoWireType, v protoValue) error {
+		switch {
+		case num == 1 && wt == protoBytes:
+			value = string(v.bytes)
+		case num == 2 && wt == protoVarint:
+			value = v.num != 0
+		case num == 3 && wt == protoVarint:
+			value = int64(v.num)
+		case num == 4 && wt == protoFixed64:
+			value = otlpDouble(math.Float64frombits(v.num))
+		case num == 5 && wt == protoBytes: // array_value
+			values := []any{}
+			err := protoFields(v.bytes, func(num int, wt protoWireType, v protoValue) error {
+				if num != 1 || wt != protoBytes {
+					return nil
+				}
+				item, err := decodeAnyValue(v.bytes)
+				values = append(values, item)
+				return err
+			})
+			value = values
+			return err
+		case num == 6 && wt == protoBytes: // kvlist_value
+			kvs := map[string]any{}
+			err := protoFields(v.bytes, func(num int, wt protoWireType, v protoValue) error {
+				if num != 1 || wt != protoBytes {
+					return nil
+				}
+				return decodeKeyValue(v.bytes, &kvs)
+			})
+			value = kvs
+			return err
+		case num == 7 && wt == protoBytes:
+			value = base64.StdEncoding.EncodeToString(v.bytes)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// protoWireType is the wire type of a protobuf field
+type protoWireType int
+
+const (
+	protoVarint  protoWireType = 0
+	protoFixed64 protoWireType = 1
+	protoBytes   protoWireType = 2
+	protoFixed32 protoWireType = 5
+)
+
+// protoValue is the value of a field: numbers are in num, length-delimited values are in bytes
+type protoValue struct {
+	num   uint64
+	bytes []byte
+}
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoFields visits fields of the protobuf message in the wire format
+func protoFields(b []byte, visit func(num int, wt protoWireType, v protoValue) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		b = b[n:]
+		num, wt := int(tag>>3), protoWireType(tag&7)
+
+		var v protoValue
+		switch wt {
+		case protoVarint:
+			v.num, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return errProtoTruncated
+			}
+			v.num, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errProtoTruncated
+			}
+			v.bytes, b = b[n:n+int(l)], b[n+int(l):]
+		case protoFixed32:
+			if len(b) < 4 {
+				return errProtoTruncated
+			}
+			v.num, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wt)
+		}
+
+		err := visit(num, wt, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
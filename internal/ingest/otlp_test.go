@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// protobuf encoding helpers to build requests without generated code
+func pbTag(b []byte, num int, wt protoWireType) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wt))
+}
+
+func pbBytes(b []byte, num int, v []byte) []byte {
+	b = pbTag(b, num, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbVarint(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(pbTag(b, num, protoVarint), v)
+}
+
+func pbFixed64(b []byte, num int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(pbTag(b, num, protoFixed64), v)
+}
+
+func pbString(num int, s string) []byte { return pbBytes(nil, num, []byte(s)) }
+
+func pbKeyValue(key string, value []byte) []byte {
+	return append(pbString(1, key), pbBytes(nil, 2, value)...)
+}
+
+func TestDecodeOTLPLogsProto(t *testing.T) {
+	received := time.Unix(100, 0)
+
+	resource := pbBytes(nil, 1, pbKeyValue("service.name", pbString(1, "checkout")))
+	record := pbFixed64(nil, 1, 1722297600_000000001)
+	record = pbVarint(record, 2, 17)
+	record = pbBytes(record, 5, pbString(1, "payment failed"))
+	record = pbBytes(record, 6, pbKeyValue("retry", pbVarint(nil, 3, 3)))
+	record = pbBytes(record, 6, pbKeyValue("ratio", pbFixed64(nil, 4, math.Float64bits(0.5))))
+	record = pbBytes(record, 6, pbKeyValue("offset", pbVarint(nil, 3, uint64(1<<64-1)))) // -1
+	record = pbBytes(record, 6, pbKeyValue("limit", pbFixed64(nil, 4, math.Float64bits(math.Inf(1)))))
+	record = pbBytes(record, 6, pbKeyValue("tags", pbBytes(nil, 5, append(pbBytes(nil, 1, pbString(1, "a")), pbBytes(nil, 1, pbVarint(nil, 2, 1))...))))
+	record = pbBytes(record, 9, []byte{0xab, 0xcd})
+	record = pbVarint(record, 8, 1) // flags are skipped
+	noTime := pbFixed64(nil, 11, 5) // observed time only
+
+	scopeLogs := pbBytes(nil, 1, pbString(1, "my.lib"))
+	scopeLogs = pbBytes(scopeLogs, 2, record)
+	scopeLogs = pbBytes(scopeLogs, 2, noTime)
+	// the resource may follow scope logs
+	resourceLogs := append(pbBytes(nil, 2, scopeLogs), pbBytes(nil, 1, resource)...)
+	req := pbBytes(nil, 1, resourceLogs)
+
+	records, err := DecodeOTLPLogsProto(req, received)
+	require.NoError(t, err)
+	require.Equal(
+		t, []OTLPLogRecord{
+			{
+				TimeUnixNano:   1722297600_000000001,
+				Severity:       "ERROR",
+				SeverityNumber: 17,
+				Body:           "payment failed",
+				Attributes: map[string]any{
+					"retry":  int64(3),
+					"ratio":  0.5,
+					"offset": int64(-1),
+					"limit":  "Infinity",
+					"tags":   []any{"a", true},
+				},
+				Resource: map[string]any{"service.name": "checkout"},
+				Scope:    "my.lib",
+				TraceID:  "abcd",
+			},
+			{TimeUnixNano: 5, Resource: map[string]any{"service.name": "checkout"}, Scope: "my.lib"},
+		},
+		records,
+	)
+
+	_, err = records[0].Line()
+	require.NoError(t, err)
+
+	_, err = DecodeOTLPLogsProto(req[:len(req)-1], received)
+	require.Error(t, err)
+}
+
+func TestDecodeOTLPLogsJSON(t *testing.T) {
+	received := time.Unix(100, 0)
+	req := `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeLogs":[{"scope":{"name":"my.lib"},"logRecords":[
+			{"timeUnixNano":"1722297600000000001","severityText":"warn","body":{"kvlistValue":{"values":[{"key":"k","value":{"intValue":"7"}}]}},
+			 "attributes":[{"key":"ok","value":{"boolValue":true}},{"key":"offset","value":{"intValue":"-1"}},
+				{"key":"ratio","value":{"doubleValue":"NaN"}}],"traceId":"abcd"},
+			{"severityNumber":9,"body":{"stringValue":"no time"}}
+		]}]}]}`
+
+	records, err := DecodeOTLPLogsJSON([]byte(req), received)
+	require.NoError(t, err)
+	require.Equal(
+		t, []OTLPLogRecord{
+			{
+				TimeUnixNano: 1722297600_000000001,
+				Severity:     "warn",
+				Body:         map[string]any{"k": int64(7)},
+				Attributes:   map[string]any{"ok": true, "offset": int64(-1), "ratio": "NaN"},
+				Resource:     map[string]any{"service.name": "checkout"},
+				Scope:        "my.lib",
+				TraceID:      "abcd",
+			},
+			{
+				TimeUnixNano:   uint64(received.UnixNano()),
+				Severity:       "INFO",
+				SeverityNumber: 9,
+				Body:           "no time",
+				Resource:       map[string]any{"service.name": "checkout"},
+				Scope:          "my.lib",
+			},
+		},
+		records,
+	)
+
+	line, err := records[0].Line()
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		`{"time_unix_nano":1722297600000000001,"severity":"warn","body":{"k":7},"attributes":{"offset":-1,"ok":true,"ratio":"NaN"},`+
+			`"resource":{"service.name":"checkout"},"scope":"my.lib","trace_id":"abcd"}`,
+		string(line),
+	)
+}
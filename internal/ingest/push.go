@@ -0,0 +1,203 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrPushBufferFull means that pushed lines don't fit into the buffer now, the client should retry later
+	ErrPushBufferFull = errors.New("push buffer is full")
+	// ErrPushTooLarge means that pushed lines would never fit into the buffer
+	ErrPushTooLarge = errors.New("pushed lines are larger than the buffer")
+	// ErrUnknownHeap means that the heap does not accept pushed lines
+	ErrUnknownHeap = errors.New("unknown heap")
+)
+
+// pushQueueLen limits the number of buffered batches (in addition to their size)
+const pushQueueLen = 4096
+
+// PushHeap is a heap that accepts pushed lines
+type PushHeap struct {
+	Writer *HeapWriter
+	// max total size of heap files, the oldest files are removed beyond it (0 is unlimited)
+	MaxSize int
+}
+
+// OpenPushHeaps opens writers of heaps that accept pushed lines, caps are max sizes of heaps in megabytes
+func OpenPushHeaps(caps map[string]int, newWriter func(name string) (*HeapWriter, error)) (map[string]PushHeap, error) {
+	heaps := make(map[string]PushHeap, len(caps))
+	for name, maxSizeMb := range caps {
+		writer, err := newWriter(name)
+		if err != nil {
+			return nil, fmt.Errorf("push source %q: %w", name, err)
+		}
+		heaps[name] = PushHeap{Writer: writer, MaxSize: maxSizeMb * 1024 * 1024}
+	}
+	return heaps, nil
+}
+
+type pushBatch struct {
+	heap  string
+	lines [][]byte
+	size  int
+}
+
+// PushBuffer accepts lines pushed to heaps and writes them in the background.
+// Lines are buffered in memory up to the size limit, pushes beyond it are rejected until the buffer drains.
+type PushBuffer struct {
+	heaps   map[string]PushHeap
+	size    int
+	batches chan pushBatch
+	logger  *zap.Logger
+
+	mu       sync.Mutex
+	buffered int // bytes of batches that are not written yet
+}
+
+func NewPushBuffer(heaps map[string]PushHeap, size int, logger *zap.Logger) *PushBuffer {
+	if size <= 0 {
+		panic(fmt.Sprintf("invalid push buffer size: %d", size))
+	}
+	return &PushBuffer{
+		heaps:   heaps,
+		size:    size,
+		batches: make(chan pushBatch, pushQueueLen),
+		logger:  logger,
+	}
+}
+
+// Size is the max number of buffered bytes
+func (b *PushBuffer) Size() int {
+	return b.size
+}
+
+// Push buffers lines of the heap, they are written in the background.
+// It never blocks: ErrPushBufferFull is returned if the lines don't fit into the buffer.
+func (b *PushBuffer) Push(heap string, lines [][]byte) error {
+	if _, ok := b.heaps[heap]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownHeap, heap)
+	}
+	batch := pushBatch{heap: heap, lines: lines}
+	for _, l := range lines {
+		batch.size += len(l)
+	}
+	if batch.size > b.size {
+		return ErrPushTooLarge
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buffered+batch.size > b.size {
+		return ErrPushBufferFull
+	}
+	select {
+	case b.batches <- batch:
+		b.buffered += batch.size
+		return nil
+	default:
+		return ErrPushBufferFull
+	}
+}
+
+// Run writes buffered lines until the context is done, then closes heap files.
+func (b *PushBuffer) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			var errs []error
+			for _, h := range b.heaps {
+				errs = append(errs, h.Writer.Close())
+			}
+			return errors.Join(errs...)
+		case batch := <-b.batches:
+			err := b.write(batch)
+			if err != nil {
+				b.logger.Error("pushed lines are lost", zap.String("heap", batch.heap), zap.Error(err))
+			}
+			b.mu.Lock()
+			b.buffered -= batch.size
+			b.mu.Unlock()
+		}
+	}
+}
+
+// write appends lines to the heap and removes its oldest files beyond the size cap
+func (b *PushBuffer) write(batch pushBatch) error {
+	heap := b.heaps[batch.heap]
+	for _, line := range batch.lines {
+		err := heap.Writer.WriteLine(line)
+		if err != nil {
+			return err
+		}
+	}
+	if heap.MaxSize > 0 {
+		return heap.Writer.Trim(heap.MaxSize)
+	}
+	return nil
+}
+
+// Touched returns heap files written since the previous call
+func (b *PushBuffer) Touched() ([]string, error) {
+	var files []string
+	for _, h := range b.heaps {
+		touched, err := h.Writer.Touched()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, touched...)
+	}
+	return files, nil
+}
+
+// DecodePushedBody returns a copy of the pushed body, decompressed if the encoding is "gzip".
+// The decompressed body can't be larger than limit.
+func DecodePushedBody(body []byte, encoding string, limit int) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		if len(body) > limit {
+			return nil, ErrPushTooLarge
+		}
+		return bytes.Clone(body), nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		decoded, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		if len(decoded) > limit {
+			return nil, ErrPushTooLarge
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// SplitPushedLines returns non-empty lines of the pushed body, with ndjson every line must be a JSON object.
+func SplitPushedLines(body []byte, ndjson bool) ([][]byte, error) {
+	var lines [][]byte
+	for i, line := range bytes.Split(body, []byte{'\n'}) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if ndjson && (line[0] != '{' || !json.Valid(line)) {
+			return nil, fmt.Errorf("line %d is not a JSON object", i+1)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPushBuffer(t *testing.T) {
+	dir := t.TempDir()
+	hw, err := NewHeapWriter(dir, "ci", 1000, nil)
+	require.NoError(t, err)
+
+	buffer := NewPushBuffer(map[string]PushHeap{"ci": {Writer: hw}}, 10, zap.NewNop())
+
+	require.ErrorIs(t, buffer.Push("other", [][]byte{[]byte("line")}), ErrUnknownHeap)
+	require.ErrorIs(t, buffer.Push("ci", [][]byte{[]byte("longer than the buffer")}), ErrPushTooLarge)
+
+	// the buffer is not drained yet
+	require.NoError(t, buffer.Push("ci", [][]byte{[]byte("line1"), []byte("line2")}))
+	require.ErrorIs(t, buffer.Push("ci", [][]byte{[]byte("line3")}), ErrPushBufferFull)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- buffer.Run(ctx) }()
+
+	// lines are written in the background and free the buffer
+	require.Eventually(
+		t, func() bool { return buffer.Push("ci", [][]byte{[]byte("line3")}) == nil },
+		time.Second, 10*time.Millisecond,
+	)
+	var touched []string
+	require.Eventually(
+		t, func() bool {
+			files, err := buffer.Touched()
+			require.NoError(t, err)
+			touched = append(touched, files...)
+			b, _ := os.ReadFile(hw.File())
+			return string(b) == "line1\nline2\nline3\n"
+		},
+		time.Second, 10*time.Millisecond,
+	)
+
+	require.Contains(t, touched, hw.File())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestPushedBody(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, err := zw.Write([]byte("line1\r\n\n  \nline2"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	body, err := DecodePushedBody(zipped.Bytes(), "gzip", 100)
+	require.NoError(t, err)
+	lines, err := SplitPushedLines(body, false)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("line1"), []byte("line2")}, lines)
+
+	// the limit is of the decompressed body
+	_, err = DecodePushedBody(zipped.Bytes(), "gzip", 5)
+	require.ErrorIs(t, err, ErrPushTooLarge)
+	_, err = DecodePushedBody([]byte("line1"), "", 4)
+	require.ErrorIs(t, err, ErrPushTooLarge)
+	_, err = DecodePushedBody([]byte("line1"), "br", 100)
+	require.Error(t, err)
+
+	_, err = SplitPushedLines([]byte("{\"a\":1}\nplain"), true)
+	require.EqualError(t, err, "line 2 is not a JSON object")
+}
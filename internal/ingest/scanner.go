@@ -0,0 +1,106 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"iter"
+
+	"heaplog_2024/internal/common"
+)
+
+var (
+	// NoMessageStartFound means the scanner failed to locate the start of a message in the stream
+	NoMessageStartFound = fmt.Errorf("unable to find a message in the stream")
+)
+
+type ScannedMessage struct {
+	common.MessageLayout
+	IsTail bool
+}
+
+// MessageScanner finds message boundaries in a file.
+// Scan returns all messages that start within the given locations (all messages if locations are empty),
+// the last message in the file is marked as the tail and spans to the end of the file.
+// Scanners only read the file from the line that contains the earliest location,
+// so scanning the tail of a big file is cheap.
+// The date of each message is the first capture group of the message start pattern.
+type MessageScanner interface {
+	Scan(file string, fileSize int, locations []common.Location) (
+		count int,
+		layouts iter.Seq[ScannedMessage],
+		err error,
+	)
+}
+
+func toMessageLayouts(in []ScannedMessage) []common.MessageLayout {
+	out := make([]common.MessageLayout, len(in))
+	for i, m := range in {
+		out[i] = m.MessageLayout
+	}
+	return out
+}
+
+// withinLocations decides if the scanned message starts within the given locations.
+// Empty locations match everything.
+func withinLocations(m ScannedMessage, locations []common.Location) bool {
+	if len(locations) == 0 {
+		return true
+	}
+	for _, rloc := range locations {
+		if rloc.Contains(m.Loc.From) {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutsFromStarts turns found message starts into contiguous messages.
+// Each message spans to the start of the next one, the last one spans to the end of the file.
+// starts must be sorted by position and have only Loc.From and DateLoc set.
+func layoutsFromStarts(starts []common.MessageLayout, fileSize int, locations []common.Location) []ScannedMessage {
+	out := make([]ScannedMessage, 0, len(starts))
+	for j, s := range starts {
+		m := ScannedMessage{MessageLayout: s}
+		if j == len(starts)-1 {
+			m.Loc.To = fileSize // the last message
+			m.IsTail = true
+		} else {
+			m.Loc.To = starts[j+1].Loc.From
+		}
+		if withinLocations(m, locations) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// scanStart returns the earliest position the scanner must read from to find messages within locations.
+func scanStart(locations []common.Location) int {
+	if len(locations) == 0 {
+		return 0
+	}
+	start := locations[0].From
+	for _, l := range locations[1:] {
+		start = min(start, l.From)
+	}
+	return max(start, 0)
+}
+
+// lineStartAt returns the position of the beginning of the line that contains pos.
+// Scanners match lines from their beginning, so they can't start reading in the middle of a line.
+func lineStartAt(r io.ReaderAt, pos int) (int, error) {
+	buf := make([]byte, 4096)
+	for pos > 0 {
+		from := max(pos-len(buf), 0)
+		n, err := r.ReadAt(buf[:pos-from], int64(from))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i != -1 {
+			return from + i + 1, nil
+		}
+		pos = from
+	}
+	return 0, nil
+}
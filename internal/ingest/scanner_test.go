@@ -0,0 +1,250 @@
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal/common"
+)
+
+// scannerSample is a stream with multi-line messages used to test scanners
+const scannerSample = `
+[2024-07-30T00:00:04.769958+00:00] production.DEBUG: Shuffle jobs on the queue
+
+trace: fe0fef7b-7770-42f8-8197-86e56bd87842
+[2024-07-30T00:00:12.285087+00:00] production.INFO: Event dispatched: App\Domain\Account\Events\UserWasSeen
+payload:
+    user_id: 1216302
+context:
+    label: 386ddf2b-bd13-4f12-ac19-92262cbf9b63
+    environment: production
+    started_at: 1722297612274467
+    user_id: null
+    channel: { type: http, details: { method: get, url: 'https://abcde.io/api/user' } }
+    extras: { ip: 185.202.221.74 }
+event_emitted_at_format: '2024-07-30T00:00:12+00:00'
+
+trace: 386ddf2b-bd13-4f12-ac19-92262cbf9b63
+[2024-07-30T00:00:12.967490+00:00] production.DEBUG: analytics event result:  for data {"client_id":"237338923.1722297170","user_id":"1216302","events":[{"name":"be_user_created","params":{"value":1,"currency":"EUR"}}]}
+
+trace: 80847f4b-c06e-4f2b-9b77-80c6428d925b
+`
+
+// scannerLocationTests are expected scanning results of scannerSample within given locations
+var scannerLocationTests = []struct {
+	locations       []common.Location
+	expectedLayouts []ScannedMessage
+}{
+	{ // all
+		locations: nil,
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 1, To: 125},
+					DateLoc: common.Location{From: 2, To: 34},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 125, To: 620},
+					DateLoc: common.Location{From: 126, To: 158},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+	{ // no message start
+		locations: []common.Location{
+			{From: 0, To: 1},
+		},
+		expectedLayouts: nil,
+	},
+	{ // wrong locations
+		locations: []common.Location{
+			{From: 2000, To: 10000},
+		},
+		expectedLayouts: nil,
+	},
+	{ // All file as a single location
+		locations: []common.Location{
+			{From: 0, To: 10000},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 1, To: 125},
+					DateLoc: common.Location{From: 2, To: 34},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 125, To: 620},
+					DateLoc: common.Location{From: 126, To: 158},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+	{ // Location that contains only part of the date
+		locations: []common.Location{
+			{From: 0, To: 20},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 1, To: 125},
+					DateLoc: common.Location{From: 2, To: 34},
+				},
+			},
+		},
+	},
+	{ // Location that contains the date of the first message
+		locations: []common.Location{
+			{From: 0, To: 50},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 1, To: 125}, // right boundary is the next message or the eof
+					DateLoc: common.Location{From: 2, To: 34},
+				},
+			},
+		},
+	},
+	{ // Multiple location that contain messages
+		locations: []common.Location{
+			{From: 0, To: 50},
+			{From: 610, To: 700},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 1, To: 125},
+					DateLoc: common.Location{From: 2, To: 34},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+	{ // Location that starts in the middle of a line (scanning starts from the line beginning)
+		locations: []common.Location{
+			{From: 600, To: 700},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+	{ // Location that starts in the middle of a message's first line
+		locations: []common.Location{
+			{From: 130, To: 10000},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+	{ // Location that starts at a message
+		locations: []common.Location{
+			{From: 125, To: 10000},
+		},
+		expectedLayouts: []ScannedMessage{
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 125, To: 620},
+					DateLoc: common.Location{From: 126, To: 158},
+				},
+			},
+			{
+				MessageLayout: common.MessageLayout{
+					Loc:     common.Location{From: 620, To: 885},
+					DateLoc: common.Location{From: 621, To: 653},
+				},
+				IsTail: true,
+			},
+		},
+	},
+}
+
+// requireUg skips the test if "ug" is not installed
+func requireUg(t testing.TB) {
+	if _, err := exec.LookPath("ug"); err != nil {
+		t.Skip("ug is not installed")
+	}
+}
+
+// TestScannersAgree checks that the native scanner produces the same messages as "ug".
+func TestScannersAgree(t *testing.T) {
+	requireUg(t)
+
+	streams := []string{
+		scannerSample,
+		common.SampleLog1,
+		common.SampleLog2,
+		strings.TrimSpace(common.SampleLog1), // no leading and trailing new lines
+		strings.ReplaceAll(common.SampleLog2, "\n", "\r\n"), // windows line endings
+		strings.Repeat(scannerSample, 100),
+	}
+	patterns := []string{MsgStartRe, common.MessageStartPattern}
+
+	dir := t.TempDir()
+	for i, stream := range streams {
+		for j, pattern := range patterns {
+			t.Run(
+				fmt.Sprintf("stream %d pattern %d", i, j), func(t *testing.T) {
+					filePath := filepath.Join(dir, fmt.Sprintf("sample_%d.log", i))
+					require.NoError(t, os.WriteFile(filePath, []byte(stream), 0644))
+
+					ugCount, ugLayouts, ugErr := NewUgScanner(pattern, common.NewOpener(nil)).Scan(filePath, len(stream), nil)
+					nativeCount, nativeLayouts, nativeErr := NewNativeScanner(regexp.MustCompile(pattern), common.NewOpener(nil)).Scan(
+						filePath,
+						len(stream),
+						nil,
+					)
+					require.Equal(t, ugErr, nativeErr)
+					if ugErr != nil {
+						return
+					}
+					require.Equal(t, ugCount, nativeCount)
+					require.Equal(t, slices.Collect(ugLayouts), slices.Collect(nativeLayouts))
+				},
+			)
+		}
+	}
+}
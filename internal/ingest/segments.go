@@ -0,0 +1,76 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// files that grow slower than this (bytes per second) get segments of SegmentSize.MaxBytes
+	quietFileRate = 1 << 10
+	// files that grow faster than this (bytes per second) get segments of SegmentSize.MinBytes
+	busyFileRate = 1 << 20
+	// the growth of a file is measured over at least this time, so frequent runs don't make the rate noisy
+	minGrowthWindow = 10 * time.Second
+)
+
+// SegmentSize tells how messages of files are grouped into segments.
+// The inverted index points to segments, so with smaller segments searches read and match fewer bytes,
+// but the index is larger.
+type SegmentSize struct {
+	// target length of a segment (bytes), segments align at message boundaries
+	Bytes int
+	// max messages in a segment, 0 is unlimited
+	Messages int
+	// size segments by how fast the file grows: quiet files get segments of MaxBytes, busy ones down to MinBytes.
+	// The growth is measured between runs, files that were not measured yet get segments of Bytes.
+	Adaptive           bool
+	MinBytes, MaxBytes int
+}
+
+func (s SegmentSize) validate() error {
+	if s.Bytes <= 0 || s.Messages < 0 {
+		return fmt.Errorf("invalid segment size: %d bytes, %d messages", s.Bytes, s.Messages)
+	}
+	if s.Adaptive && (s.MinBytes <= 0 || s.MinBytes > s.MaxBytes) {
+		return fmt.Errorf("invalid adaptive segment size: min %d, max %d bytes", s.MinBytes, s.MaxBytes)
+	}
+	return nil
+}
+
+// lenFor returns the target length of segments of a file that grows at the rate (bytes per second),
+// a negative rate means that it is unknown
+func (s SegmentSize) lenFor(rate float64) int {
+	switch {
+	case !s.Adaptive || rate < 0:
+		return s.Bytes
+	case rate <= quietFileRate:
+		return s.MaxBytes
+	case rate >= busyFileRate:
+		return s.MinBytes
+	}
+	// rates differ by orders of magnitude, so the length goes down with the logarithm of the rate
+	k := math.Log(rate/quietFileRate) / math.Log(busyFileRate/quietFileRate)
+	return s.MaxBytes - int(k*float64(s.MaxBytes-s.MinBytes))
+}
+
+// fileGrowth measures how fast a file grows between runs
+type fileGrowth struct {
+	size int
+	at   time.Time
+	rate float64 // bytes per second, negative if unknown
+}
+
+// observe returns the growth with the current size of the file,
+// the rate is updated when enough time passed since the previous measurement.
+func (g fileGrowth) observe(size int, now time.Time) fileGrowth {
+	if g.at.IsZero() || size < g.size {
+		return fileGrowth{size: size, at: now, rate: -1} // new or truncated file
+	}
+	elapsed := now.Sub(g.at)
+	if elapsed < minGrowthWindow {
+		return g
+	}
+	return fileGrowth{size: size, at: now, rate: float64(size-g.size) / elapsed.Seconds()}
+}
@@ -0,0 +1,44 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentSizeLenFor(t *testing.T) {
+	fixed := SegmentSize{Bytes: 5_000, MinBytes: 1_000, MaxBytes: 9_000}
+	require.Equal(t, 5_000, fixed.lenFor(-1))
+	require.Equal(t, 5_000, fixed.lenFor(busyFileRate))
+
+	adaptive := fixed
+	adaptive.Adaptive = true
+	require.Equal(t, 5_000, adaptive.lenFor(-1)) // not measured yet
+	require.Equal(t, 9_000, adaptive.lenFor(0))
+	require.Equal(t, 9_000, adaptive.lenFor(quietFileRate))
+	require.Equal(t, 5_000, adaptive.lenFor(32*1024)) // halfway between the rates on the log scale
+	require.Equal(t, 1_000, adaptive.lenFor(busyFileRate))
+	require.Equal(t, 1_000, adaptive.lenFor(100*busyFileRate))
+
+	require.Error(t, SegmentSize{}.validate())
+	require.Error(t, SegmentSize{Bytes: 1, Adaptive: true, MinBytes: 2, MaxBytes: 1}.validate())
+	require.NoError(t, adaptive.validate())
+}
+
+func TestFileGrowth(t *testing.T) {
+	now := time.Now()
+	g := fileGrowth{}.observe(1_000, now)
+	require.Equal(t, -1.0, g.rate) // a new file
+
+	// too soon to measure
+	g = g.observe(2_000, now.Add(time.Second))
+	require.Equal(t, fileGrowth{size: 1_000, at: now, rate: -1}, g)
+
+	g = g.observe(21_000, now.Add(minGrowthWindow*2))
+	require.Equal(t, 1_000.0, g.rate)
+
+	// truncated
+	g = g.observe(10, now.Add(minGrowthWindow*4))
+	require.Equal(t, -1.0, g.rate)
+}
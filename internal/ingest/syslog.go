@@ -0,0 +1,206 @@
+package ingest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"heaplog_2024/internal/common"
+)
+
+// Syslog messages are written to heap files as canonical lines, these settings make a source of them.
+// Host, app, severity and facility of messages are extracted as fields.
+const (
+	SyslogMessageStartRE = `^\[(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z)\] ` +
+		`host=(?P<host>\S+) app=(?P<app>\S+) severity=(?P<severity>\w+) facility=(?P<facility>[\w-]+)`
+	SyslogDateFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogNil is the value of omitted parts of a message (as in RFC 5424)
+const syslogNil = "-"
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// rfc3164Date parses dates of BSD syslog, they have no year and no timezone (the receiver's local time is assumed)
+var rfc3164Date, _ = common.NewDateParser([]string{time.Stamp, time.StampMicro}, time.Local)
+
+// SyslogMessage is a message received in RFC 3164 (BSD) or RFC 5424 format
+type SyslogMessage struct {
+	Time     time.Time
+	Host     string
+	App      string
+	ProcID   string
+	MsgID    string
+	Severity string
+	Facility string
+	// structured data of RFC 5424 messages as is, example: `[origin ip="10.0.0.1"]`
+	StructuredData string
+	Message        string
+}
+
+// Line formats the message as a canonical heap line (see SyslogMessageStartRE), the line break is not included.
+func (m SyslogMessage) Line() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(
+		&b, "[%s] host=%s app=%s severity=%s facility=%s",
+		m.Time.UTC().Format(SyslogDateFormat), orNil(m.Host), orNil(m.App), m.Severity, m.Facility,
+	)
+	if m.ProcID != "" && m.ProcID != syslogNil {
+		fmt.Fprintf(&b, " pid=%s", m.ProcID)
+	}
+	if m.MsgID != "" && m.MsgID != syslogNil {
+		fmt.Fprintf(&b, " msgid=%s", m.MsgID)
+	}
+	if m.StructuredData != "" && m.StructuredData != syslogNil {
+		b.WriteString(" ")
+		b.WriteString(m.StructuredData)
+	}
+	b.WriteString(": ")
+	b.WriteString(m.Message)
+	return b.Bytes()
+}
+
+// orNil replaces an empty or blank value with the nil value
+func orNil(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return syslogNil
+	}
+	return strings.Join(strings.Fields(s), "_") // values must be single words
+}
+
+// ParseSyslog parses a message in RFC 5424 or RFC 3164 format.
+// The time of receiving is used if the message has no valid timestamp,
+// the sender's address is used if the message has no host name.
+func ParseSyslog(b []byte, received time.Time, sender string) (SyslogMessage, error) {
+	s := strings.TrimRight(string(b), "\r\n\x00")
+	m := SyslogMessage{Time: received, Host: sender}
+
+	pri := 13 // user.notice is assumed for messages without the priority (RFC 3164)
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end < 2 || end > 4 {
+			return m, errors.New("invalid syslog priority")
+		}
+		p, err := strconv.Atoi(s[1:end])
+		if err != nil || p < 0 || p > 191 {
+			return m, fmt.Errorf("invalid syslog priority %q", s[1:end])
+		}
+		pri, s = p, s[end+1:]
+	}
+	m.Severity, m.Facility = syslogSeverities[pri%8], syslogFacilities[pri/8]
+
+	if strings.HasPrefix(s, "1 ") {
+		return parseRFC5424(s[2:], m)
+	}
+	return parseRFC3164(s, m), nil
+}
+
+// parseRFC5424 parses the rest of the message after "<PRI>1 ":
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(s string, m SyslogMessage) (SyslogMessage, error) {
+	parts := strings.SplitN(s, " ", 6)
+	if len(parts) < 6 {
+		return m, errors.New("invalid RFC 5424 message: missing header fields")
+	}
+	if parts[0] != syslogNil {
+		t, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return m, fmt.Errorf("invalid RFC 5424 timestamp: %w", err)
+		}
+		m.Time = t
+	}
+	if parts[1] != syslogNil {
+		m.Host = parts[1]
+	}
+	if parts[2] != syslogNil {
+		m.App = parts[2]
+	}
+	m.ProcID, m.MsgID = parts[3], parts[4]
+
+	rest := parts[5]
+	if strings.HasPrefix(rest, syslogNil) {
+		rest = rest[1:]
+	} else {
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return m, errors.New("invalid RFC 5424 structured data")
+		}
+		m.StructuredData, rest = rest[:end], rest[end:]
+	}
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff") // BOM of UTF-8 messages
+	return m, nil
+}
+
+// structuredDataEnd finds the end of structured data elements "[id key="value"]...",
+// values may contain escaped quotes and brackets. Returns -1 if the data is malformed.
+func structuredDataEnd(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		inValue := false
+		for i++; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && inValue {
+				i++
+			} else if c == '"' {
+				inValue = !inValue
+			} else if c == ']' && !inValue {
+				break
+			}
+		}
+		if i >= len(s) {
+			return -1
+		}
+		i++
+	}
+	if i == 0 {
+		return -1
+	}
+	return i
+}
+
+// parseRFC3164 parses the rest of the message after "<PRI>": TIMESTAMP [HOSTNAME] TAG[PID]: MSG.
+// BSD syslog is loosely followed by senders, so what can't be parsed is kept in the message.
+func parseRFC3164(s string, m SyslogMessage) SyslogMessage {
+	m.Message = s
+
+	// "Jan _2 15:04:05" optionally with microseconds
+	stampLen := len(time.Stamp)
+	if len(s) > len(time.StampMicro) && s[len(time.Stamp)] == '.' {
+		stampLen = len(time.StampMicro)
+	}
+	if len(s) <= stampLen || s[stampLen] != ' ' {
+		return m
+	}
+	t, err := rfc3164Date.Parse([]byte(s[:stampLen]))
+	if err != nil {
+		return m
+	}
+	m.Time, s = t, s[stampLen+1:]
+
+	// the host name is optional: the first word is the tag if it ends with ":" or has a pid
+	word, rest, _ := strings.Cut(s, " ")
+	if !strings.HasSuffix(word, ":") && !strings.Contains(word, "[") {
+		m.Host, s = word, rest
+		word, rest, _ = strings.Cut(s, " ")
+	}
+
+	tag := strings.TrimSuffix(word, ":")
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		tag, m.ProcID = tag[:open], tag[open+1:len(tag)-1]
+	}
+	if strings.HasSuffix(word, ":") || m.ProcID != "" {
+		m.App, m.Message = tag, rest
+	} else {
+		m.Message = s // no tag
+	}
+	return m
+}
@@ -0,0 +1,122 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// hostFileRE matches characters that can't be used in heap file names
+	hostFileRE = regexp.MustCompile(`[^\w.-]`)
+	// hostNameRE matches host names that can name heap files
+	hostNameRE = regexp.MustCompile(`^\w[\w.-]*$`)
+)
+
+// SyslogHeaps writes received syslog messages to heap files per host.
+// It is safe for concurrent use.
+type SyslogHeaps struct {
+	dir     string
+	maxSize int
+
+	mu      sync.Mutex
+	writers map[string]*HeapWriter
+}
+
+// NewSyslogHeaps creates the heap directory, so the heap is a source of the index
+func NewSyslogHeaps(dir string, maxSize int) (*SyslogHeaps, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the syslog heap at %s: %w", dir, err)
+	}
+	return &SyslogHeaps{dir: dir, maxSize: maxSize, writers: make(map[string]*HeapWriter)}, nil
+}
+
+// Write appends the message to the heap file of its host
+func (h *SyslogHeaps) Write(m SyslogMessage) error {
+	host := hostFileRE.ReplaceAllString(m.Host, "_")
+	if !hostNameRE.MatchString(host) {
+		host = "unknown"
+	}
+
+	h.mu.Lock()
+	w, ok := h.writers[host]
+	if !ok {
+		var err error
+		w, err = NewHeapWriter(h.dir, host, h.maxSize, nil) // every write is a whole message
+		if err != nil {
+			h.mu.Unlock()
+			return err
+		}
+		h.writers[host] = w
+	}
+	h.mu.Unlock()
+
+	return w.WriteLine(m.Line())
+}
+
+// Touched returns files of all hosts written since the previous call
+func (h *SyslogHeaps) Touched() ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var files []string
+	for _, w := range h.writers {
+		touched, err := w.Touched()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, touched...)
+	}
+	return files, nil
+}
+
+// Close flushes and closes files of all hosts
+func (h *SyslogHeaps) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var errs []error
+	for _, w := range h.writers {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ListenSyslog receives syslog messages on the addresses (empty = not listened) until the context is done,
+// messages are written to the heaps and ingested every second.
+func ListenSyslog(ctx context.Context, udp, tcp string, heaps *SyslogHeaps, ingestor *Ingestor, logger *zap.Logger) error {
+	receiver := NewSyslogReceiver(heaps.Write, logger)
+	if udp != "" {
+		conn, err := net.ListenPacket("udp", udp)
+		if err != nil {
+			return fmt.Errorf("unable to receive syslog: %w", err)
+		}
+		go func() {
+			err := receiver.ServeUDP(ctx, conn)
+			if err != nil {
+				logger.Error("Syslog receiver failed", zap.Error(err))
+			}
+		}()
+	}
+	if tcp != "" {
+		l, err := net.Listen("tcp", tcp)
+		if err != nil {
+			return fmt.Errorf("unable to receive syslog: %w", err)
+		}
+		go func() {
+			err := receiver.ServeTCP(ctx, l)
+			if err != nil {
+				logger.Error("Syslog receiver failed", zap.Error(err))
+			}
+		}()
+	}
+
+	ingestor.RunTouched(ctx, heaps.Touched)
+	context.AfterFunc(ctx, func() { _ = heaps.Close() })
+	return nil
+}
@@ -0,0 +1,46 @@
+package ingest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogHeaps(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "syslog")
+	heaps, err := NewSyslogHeaps(dir, 1024)
+	require.NoError(t, err)
+	require.DirExists(t, dir) // the heap is a source before any message is received
+
+	date := time.Date(2024, 7, 30, 10, 0, 0, 0, time.UTC)
+	for _, host := range []string{"web-1", "db/2", "-", ""} {
+		require.NoError(t, heaps.Write(SyslogMessage{Time: date, Host: host, Message: "hello"}))
+	}
+
+	// a file per host, hosts that can't name files are unknown
+	touched, err := heaps.Touched()
+	require.NoError(t, err)
+	slices.Sort(touched)
+	require.Equal(
+		t,
+		[]string{
+			filepath.Join(dir, "db_2-000001.log"),
+			filepath.Join(dir, "unknown-000001.log"),
+			filepath.Join(dir, "web-1-000001.log"),
+		},
+		touched,
+	)
+	touched, err = heaps.Touched()
+	require.NoError(t, err)
+	require.Empty(t, touched)
+
+	require.NoError(t, heaps.Close())
+	b, err := os.ReadFile(filepath.Join(dir, "unknown-000001.log"))
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(b, []byte("\n")))
+}
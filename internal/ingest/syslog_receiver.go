@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxSyslogMessage is the max size of a received message, longer octet-counted frames are rejected
+const maxSyslogMessage = 1 << 20
+
+// SyslogReceiver accepts syslog messages over UDP and TCP and passes them to the handler.
+// Messages that can't be parsed are passed as is, with the time of receiving and the sender as the host.
+type SyslogReceiver struct {
+	handle func(SyslogMessage) error
+	logger *zap.Logger
+}
+
+func NewSyslogReceiver(handle func(SyslogMessage) error, logger *zap.Logger) *SyslogReceiver {
+	return &SyslogReceiver{handle: handle, logger: logger}
+}
+
+// ServeUDP receives a message per datagram until the context is done.
+func (r *SyslogReceiver) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 64*1024) // max UDP payload
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("syslog udp: %w", err)
+		}
+		r.receive(buf[:n], addr)
+	}
+}
+
+// ServeTCP accepts connections until the context is done.
+// Messages are framed by octet counting or by line breaks (RFC 6587).
+func (r *SyslogReceiver) ServeTCP(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("syslog tcp: %w", err)
+		}
+		go r.serveConn(ctx, conn)
+	}
+}
+
+func (r *SyslogReceiver) serveConn(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	for {
+		frame, err := readSyslogFrame(br)
+		if len(frame) > 0 {
+			r.receive(frame, conn.RemoteAddr())
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				r.logger.Warn("syslog connection failed", zap.Stringer("from", conn.RemoteAddr()), zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads an octet-counted frame ("<length> <message>") or a line
+func readSyslogFrame(br *bufio.Reader) ([]byte, error) {
+	c, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if c[0] < '1' || c[0] > '9' {
+		return br.ReadBytes('\n')
+	}
+
+	prefix, err := br.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	if err != nil || n > maxSyslogMessage {
+		return nil, fmt.Errorf("invalid syslog frame length %q", prefix)
+	}
+	frame := make([]byte, n)
+	_, err = io.ReadFull(br, frame)
+	return frame, err
+}
+
+// receive parses the message and passes it to the handler
+func (r *SyslogReceiver) receive(b []byte, from net.Addr) {
+	sender := from.String()
+	if host, _, err := net.SplitHostPort(sender); err == nil {
+		sender = host
+	}
+
+	m, err := ParseSyslog(b, time.Now(), sender)
+	if err != nil {
+		r.logger.Debug("unparsable syslog message", zap.String("from", sender), zap.Error(err))
+		m = SyslogMessage{
+			Time:     time.Now(),
+			Host:     sender,
+			Severity: syslogSeverities[5],
+			Facility: syslogFacilities[1],
+			Message:  strings.TrimRight(string(b), "\r\n\x00"),
+		}
+	}
+
+	err = r.handle(m)
+	if err != nil {
+		r.logger.Error("syslog message is lost", zap.String("from", sender), zap.Error(err))
+	}
+}
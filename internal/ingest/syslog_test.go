@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseSyslog(t *testing.T) {
+	received := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	type test struct {
+		message  string
+		expected SyslogMessage
+		err      bool
+	}
+	tests := []test{
+		{
+			message: `<165>1 2024-07-30T10:00:00.123Z web1 nginx 42 ID47 [origin ip="10.0.0.1"][meta x="a\]b"] request done` + "\n",
+			expected: SyslogMessage{
+				Time: time.Date(2024, 7, 30, 10, 0, 0, 123_000_000, time.UTC), Host: "web1", App: "nginx",
+				ProcID: "42", MsgID: "ID47", Severity: "notice", Facility: "local4",
+				StructuredData: `[origin ip="10.0.0.1"][meta x="a\]b"]`, Message: "request done",
+			},
+		},
+		{
+			message: "<14>1 - - - - - -",
+			expected: SyslogMessage{
+				Time: received, Host: "10.0.0.2", ProcID: "-", MsgID: "-", Severity: "info", Facility: "user",
+			},
+		},
+		{
+			message: "<34>Jul 30 10:00:00 db1 su[123]: 'su root' failed",
+			expected: SyslogMessage{
+				Host: "db1", App: "su", ProcID: "123",
+				Severity: "crit", Facility: "auth", Message: "'su root' failed",
+			},
+		},
+		{
+			message: "<13>Jul 30 10:00:00 cron: job started", // no host
+			expected: SyslogMessage{
+				Host: "10.0.0.2", App: "cron",
+				Severity: "notice", Facility: "user", Message: "job started",
+			},
+		},
+		{
+			message: "just text", // no priority and no header
+			expected: SyslogMessage{
+				Time: received, Host: "10.0.0.2", Severity: "notice", Facility: "user", Message: "just text",
+			},
+		},
+		{message: "<999>1 - - - - - -", err: true},
+		{message: "<14>1 yesterday - - - - -", err: true},
+		{message: "<14>1 - - - - [unclosed", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			m, err := ParseSyslog([]byte(tt.message), received, "10.0.0.2")
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expected.Time.IsZero() { // BSD dates have no year, it depends on the current date
+				require.Equal(t, "Jul 30 10:00:00", m.Time.In(time.Local).Format(time.Stamp))
+			} else {
+				require.True(t, tt.expected.Time.Equal(m.Time), "time %s", m.Time)
+			}
+			m.Time = tt.expected.Time
+			require.Equal(t, tt.expected, m)
+		})
+	}
+}
+
+func TestSyslogLine(t *testing.T) {
+	m := SyslogMessage{
+		Time:           time.Date(2024, 7, 30, 10, 0, 0, 123_000_000, time.FixedZone("X", 3600)),
+		Host:           "web 1",
+		ProcID:         "42",
+		Severity:       "err",
+		Facility:       "solaris-cron",
+		StructuredData: `[origin ip="10.0.0.1"]`,
+		Message:        "line one\nline two",
+	}
+	line := string(m.Line())
+	require.Equal(
+		t,
+		`[2024-07-30T09:00:00.123000Z] host=web_1 app=- severity=err facility=solaris-cron pid=42 [origin ip="10.0.0.1"]: line one`+
+			"\nline two",
+		line,
+	)
+
+	re := regexp.MustCompile(SyslogMessageStartRE)
+	match := re.FindStringSubmatch(line)
+	require.NotNil(t, match)
+	date, err := time.Parse(SyslogDateFormat, match[1])
+	require.NoError(t, err)
+	require.True(t, m.Time.Equal(date))
+	require.Equal(t, []string{"web_1", "-", "err", "solaris-cron"}, match[2:])
+}
+
+func TestSyslogReceiver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan SyslogMessage, 10)
+	receiver := NewSyslogReceiver(
+		func(m SyslogMessage) error {
+			received <- m
+			return nil
+		},
+		zap.NewNop(),
+	)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = receiver.ServeUDP(ctx, udp) }()
+	go func() { _ = receiver.ServeTCP(ctx, tcp) }()
+
+	next := func() SyslogMessage {
+		select {
+		case m := <-received:
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("no message received")
+			return SyslogMessage{}
+		}
+	}
+
+	conn, err := net.Dial("udp", udp.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("<11>1 2024-07-30T10:00:00Z host1 app1 - - - over udp\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	m := next()
+	require.Equal(t, "host1", m.Host)
+	require.Equal(t, "over udp", m.Message)
+
+	// both framings can be mixed in one connection
+	conn, err = net.Dial("tcp", tcp.Addr().String())
+	require.NoError(t, err)
+	counted := "<11>1 2024-07-30T10:00:00Z host2 app2 - - - multi\nline"
+	_, err = fmt.Fprintf(conn, "%d %s<11>Jul 30 10:00:00 host3 app3: by line\n", len(counted), counted)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	m = next()
+	require.Equal(t, "host2", m.Host)
+	require.Equal(t, "multi\nline", m.Message)
+	m = next()
+	require.Equal(t, "host3", m.Host)
+	require.Equal(t, "app3", m.App)
+	require.Equal(t, "by line", m.Message)
+}
@@ -4,172 +4,137 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"iter"
-	"log"
-	"os"
 	"os/exec"
+	"slices"
 	"strconv"
-	"unsafe"
 
 	"heaplog_2024/internal/common"
 )
 
-var (
-	// NoMessageStartFound means the scanner failed to locate the start of a message in the stream
-	NoMessageStartFound = fmt.Errorf("unable to find a message in the stream")
-)
-
-type ScannedMessage struct {
-	common.MessageLayout
-	IsTail bool
+// UgScanner execs "ug" to find message boundaries.
+// The "ug" command is based on https://github.com/Genivia/ugrep by Robert A. van Engelen.
+// The pattern uses PCRE syntax ("ug -P"). Named capture groups are not extracted as fields.
+type UgScanner struct {
+	re     string
+	opener *common.Opener
 }
 
-func toMessageLayouts(in []ScannedMessage) []common.MessageLayout {
-	out := make([]common.MessageLayout, len(in))
-	for i, m := range in {
-		out[i] = m.MessageLayout
-	}
-	return out
+func NewUgScanner(re string, opener *common.Opener) *UgScanner {
+	return &UgScanner{re: re, opener: opener}
 }
 
-// Scan execs "ug" on the entire file and returns all message offsets within the given locations.
-// The "ug" command is based on https://github.com/Genivia/ugrep by Robert A. van Engelen.
+// Scan execs "ug" on the file from the earliest location and returns all message offsets within the given locations.
 // It uses a custom format to extract message boundaries and date ranges.
 // Returns NoMessageStartFound error if no messages are found in the stream.
 // Returns error if there are issues executing ug or accessing the file.
-func Scan(file string, fileSize int, re string, locations []common.Location) (
+func (s *UgScanner) Scan(file string, fileSize int, locations []common.Location) (
 	count int,
 	layouts iter.Seq[ScannedMessage],
 	err error,
 ) {
-	ctx := context.Background()
-	cmd := exec.CommandContext(ctx, "ug", "-P", `--format=%[0]b,%[1]b:%[1]d%~`, re, file)
-
-	tmpFile, err := os.CreateTemp("", "ug-output-*.txt")
+	f, err := s.opener.OpenFile(file)
 	if err != nil {
-		return 0, nil, fmt.Errorf("create temp file: %w", err)
+		return 0, nil, fmt.Errorf("open file: %w", err)
 	}
-	defer os.Remove(tmpFile.Name())
+	defer f.Close()
 
-	cmd.Stdout = tmpFile
-	err = cmd.Run()
+	start, err := lineStartAt(f, scanStart(locations))
 	if err != nil {
-		return 0, nil, fmt.Errorf("ug exec: %w", err)
+		return 0, nil, fmt.Errorf("scan %s: %w", file, err)
 	}
+	stream := io.NewSectionReader(f, int64(start), int64(max(f.Len()-start, 0)))
 
-	_, err = tmpFile.Seek(0, 0)
-	if err != nil {
-		return 0, nil, fmt.Errorf("seek temp file: %w", err)
-	}
+	// ug reads the file from stdin, so reported offsets are relative to start
+	ctx := context.Background()
+	cmd := exec.CommandContext(ctx, "ug", "-P", `--format=%[0]b,%[1]b:%[1]d%~`, s.re)
+	cmd.Stdin = stream
 
-	// use a linux command to count new lines in the ug layouts file
-	cmd = exec.CommandContext(ctx, "wc", "-l", tmpFile.Name())
-	out, err := cmd.Output()
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return 0, nil, fmt.Errorf("wc exec: %w", err)
+		return 0, nil, fmt.Errorf("ug pipe: %w", err)
 	}
-	fields := bytes.Fields(out)
-	count, err = strconv.Atoi(string(fields[0]))
+	err = cmd.Start()
 	if err != nil {
-		return 0, nil, fmt.Errorf("parse wc output: %w", err)
+		return 0, nil, fmt.Errorf("ug exec: %w", err)
 	}
 
-	// When a new layout is scanned from the file,
-	// here we decide if it within the given locations.
-	matched := func(l ScannedMessage) bool {
-		if len(locations) == 0 {
-			return true
-		}
-		for _, rloc := range locations {
-			if rloc.Contains(l.Loc.From) {
-				return true
-			}
+	var (
+		starts   []common.MessageLayout
+		parseErr error
+	)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		m, d, dl, err := parseLine(scanner.Bytes())
+		if err != nil {
+			parseErr = err
+			break
 		}
-		return false
+		starts = append(starts, common.MessageLayout{
+			Loc:     common.Location{From: start + m},
+			DateLoc: common.Location{From: start + d, To: start + d + dl},
+		})
 	}
-
-	scanner := bufio.NewScanner(tmpFile)
-	if !scanner.Scan() {
-		return 0, nil, NoMessageStartFound
+	if parseErr == nil {
+		parseErr = scanner.Err()
 	}
-
-	lastLine := scanner.Text()
-	m, d, dl := parseLine(lastLine)
-
-	if err != nil {
-		return 0, nil, fmt.Errorf("parse line: %w", err)
+	if parseErr != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return 0, nil, fmt.Errorf("parse ug output: %w", parseErr)
 	}
 
-	return count, func(yield func(ScannedMessage) bool) {
-		defer tmpFile.Close()
-
-		for {
-			l := ScannedMessage{}
-			l.Loc.From = m
-			l.DateLoc.From = d
-			l.DateLoc.To = d + dl
-
-			if !scanner.Scan() {
-				// reached EOF
-				l.Loc.To = fileSize // the last message
-				l.IsTail = true
-				if matched(l) {
-					if !yield(l) {
-						return
-					}
-				}
-				break
-			}
-
-			lastLine = scanner.Text()
-			m, d, dl = parseLine(lastLine)
-			l.Loc.To = m
-			if matched(l) {
-				if !yield(l) {
-					return
-				}
-			}
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(starts) == 0 {
+		// ug exits with 1 when nothing matched
+		if start == 0 {
+			return 0, nil, NoMessageStartFound
 		}
-	}, nil
+		return 0, slices.Values([]ScannedMessage(nil)), nil
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("ug exec: %w", err)
+	}
+	if len(starts) == 0 && start == 0 {
+		return 0, nil, NoMessageStartFound
+	}
+
+	messages := layoutsFromStarts(starts, fileSize, locations)
+	return len(messages), slices.Values(messages), nil
 }
 
 // parseLine relies on ug format: "%[0]b,%[1]b:%[1]d%~"
-func parseLine(s string) (messageStart, dateStart, dateLen int) {
-	l := unsafe.Slice(unsafe.StringData(s), len(s))
-
-	var x int64
-
+func parseLine(l []byte) (messageStart, dateStart, dateLen int, err error) {
 	// FIRST NUMBER: message start
-	p := bytes.Index(l, []byte(","))
+	p := bytes.IndexByte(l, ',')
 	if p == -1 {
-		log.Fatalf("ug produced unexpected format: %s", string(l))
+		return 0, 0, 0, fmt.Errorf("ug produced unexpected format: %s", l)
 	}
-	x, err := strconv.ParseInt(string(l[:p]), 10, 64)
+	messageStart, err = strconv.Atoi(string(l[:p]))
 	if err != nil {
-		log.Fatalf("ug produced unexpected format: %s: %s", string(l), err)
+		return 0, 0, 0, fmt.Errorf("ug produced unexpected format: %s: %w", l, err)
 	}
-	messageStart = int(x)
 
 	// SECOND NUMBER: date start
 	l = l[p+1:]
-	p = bytes.Index(l, []byte(":"))
+	p = bytes.IndexByte(l, ':')
 	if p == -1 {
-		log.Fatalf("ug produced unexpected format: %s", string(l))
+		return 0, 0, 0, fmt.Errorf("ug produced unexpected format: %s", l)
 	}
-	x, err = strconv.ParseInt(string(l[:p]), 10, 64)
+	dateStart, err = strconv.Atoi(string(l[:p]))
 	if err != nil {
-		log.Fatalf("ug produced unexpected format: %s: %s", string(l), err)
+		return 0, 0, 0, fmt.Errorf("ug produced unexpected format: %s: %w", l, err)
 	}
-	dateStart = int(x)
 
 	// THIRD NUMBER: date len
 	l = l[p+1:]
-	x, err = strconv.ParseInt(string(l), 10, 64)
+	dateLen, err = strconv.Atoi(string(l))
 	if err != nil {
-		log.Fatalf("ug produced unexpected format: %s: %s", string(l), err)
+		return 0, 0, 0, fmt.Errorf("ug produced unexpected format: %s: %w", l, err)
 	}
-	dateLen = int(x)
 
 	return
 }
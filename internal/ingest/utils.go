@@ -3,6 +3,7 @@ package ingest
 import (
 	"cmp"
 	"iter"
+	"maps"
 	"slices"
 	"unsafe"
 
@@ -11,8 +12,10 @@ import (
 
 // alignSegmentsByMessageBoundaries groups message layouts into segments within locations.
 // It ensures that layouts within each layouts are contiguous (abutting) and the total size.
+// A segment also ends at maxMessages messages (0 is unlimited).
 func alignSegmentsByMessageBoundaries(
 	segmentSize int,
+	maxMessages int,
 	locs []common.Location,
 	layouts []common.MessageLayout,
 ) [][]common.MessageLayout {
@@ -24,13 +27,15 @@ func alignSegmentsByMessageBoundaries(
 	currentSegment := make([]common.MessageLayout, 0)
 	currentSize := 0
 	latestLayoutIndex := 0
+	// a message that ends where an unindexed location starts is already indexed
+	overlaps := func(a, b common.Location) bool { return a.From < b.To && a.To > b.From }
 
 	for _, loc := range locs {
 		li, found := slices.BinarySearchFunc(
 			layouts[latestLayoutIndex:],
 			loc,
 			func(a common.MessageLayout, b common.Location) int {
-				if a.Loc.Intersects(b) {
+				if overlaps(a.Loc, b) {
 					return 0
 				}
 				return cmp.Compare(a.Loc.From, b.From)
@@ -42,7 +47,7 @@ func alignSegmentsByMessageBoundaries(
 		}
 
 		latestLayoutIndex = li
-		for latestLayoutIndex < len(layouts) && loc.Intersects(layouts[latestLayoutIndex].Loc) {
+		for latestLayoutIndex < len(layouts) && overlaps(loc, layouts[latestLayoutIndex].Loc) {
 			layout := layouts[latestLayoutIndex]
 			// Check if layout abuts with previous layout in layouts
 			if len(currentSegment) > 0 && currentSegment[len(currentSegment)-1].Loc.To != layout.Loc.From {
@@ -59,7 +64,7 @@ func alignSegmentsByMessageBoundaries(
 			currentSize += layout.Loc.Len()
 
 			// Segment full → flush
-			if currentSize >= segmentSize {
+			if currentSize >= segmentSize || (maxMessages > 0 && len(currentSegment) >= maxMessages) {
 				result = append(result, currentSegment)
 				currentSegment = make([]common.MessageLayout, 0)
 				currentSize = 0
@@ -89,6 +94,18 @@ func appendTermsUnique(all map[string]struct{}, terms [][]byte) {
 	}
 }
 
+// addFields adds fields to every message, fields the message already has are kept.
+func addFields(messages []common.Message, fields map[string]string) {
+	if len(fields) == 0 {
+		return
+	}
+	for i := range messages {
+		merged := maps.Clone(fields)
+		maps.Copy(merged, messages[i].Fields)
+		messages[i].Fields = merged
+	}
+}
+
 // findMisalignedSegmentsForFiles checks misalignment of indexed segments with actual message boundaries found in the file.
 func findMisalignedSegmentsForFiles(
 	indexedSegments map[string][]common.Location,
@@ -150,7 +167,7 @@ func filesWithIncompleteTrailingSegments(
 				continue
 			}
 			size := accessibleFiles[file]
-			if hasIncompleteTrailingSegment(segmentLen, size, segments) {
+			if hasIncompleteTrailingSegment(segmentLen, 0, size, segments, nil) {
 				if !yield(file) {
 					return
 				}
@@ -159,10 +176,78 @@ func filesWithIncompleteTrailingSegments(
 	}
 }
 
-func hasIncompleteTrailingSegment(segmentLen, fileSize int, indexedSegments []common.Location) bool {
+// hasIncompleteTrailingSegment tells that the last indexed segment is shorter than segmentLen
+// and has fewer than maxMessages messages of the layouts (if limited), but does not reach the end of the file.
+// Such a segment is re-indexed along with new messages. Lengths may differ between runs (configuration changes,
+// adaptive sizes), so a segment that was full before is joined with following messages up to the new length once.
+func hasIncompleteTrailingSegment(
+	segmentLen, maxMessages, fileSize int,
+	indexedSegments []common.Location,
+	layouts []common.MessageLayout,
+) bool {
+	if len(indexedSegments) == 0 {
+		return false
+	}
+	trailingSegment := indexedSegments[len(indexedSegments)-1]
+	if trailingSegment.Len() >= segmentLen || trailingSegment.To >= fileSize {
+		return false
+	}
+	if maxMessages == 0 {
+		return true
+	}
+	first, _ := slices.BinarySearchFunc(
+		layouts, trailingSegment.From, func(l common.MessageLayout, pos int) int {
+			return cmp.Compare(l.Loc.From, pos)
+		},
+	)
+	messages := 0
+	for _, l := range layouts[first:] {
+		if l.Loc.To > trailingSegment.To {
+			break
+		}
+		messages++
+	}
+	return messages < maxMessages
+}
+
+// tailScanStart returns the position to scan an indexed file from: the beginning of the last indexed segment.
+// It is only possible if indexed segments are contiguous and the file did not shrink,
+// otherwise the file must be scanned entirely.
+func tailScanStart(indexedSegments []common.Location, fileSize int) (int, bool) {
+	if len(indexedSegments) == 0 {
+		return 0, false
+	}
+	for j := 1; j < len(indexedSegments); j++ {
+		if indexedSegments[j-1].To != indexedSegments[j].From {
+			return 0, false
+		}
+	}
+	lastSegment := indexedSegments[len(indexedSegments)-1]
+	if lastSegment.To > fileSize {
+		return 0, false
+	}
+	return lastSegment.From, true
+}
+
+// isTailAligned checks that the last indexed segment still starts at a message found in the tail of the file.
+func isTailAligned(lastSegment common.Location, tailLayouts []common.MessageLayout) bool {
+	return len(tailLayouts) > 0 && tailLayouts[0].Loc.From == lastSegment.From
+}
+
+// hasGrownTrailingMessage checks if the last message of the trailing segment continued after it was indexed,
+// so the segment does not end at a message boundary anymore and must be re-indexed.
+func hasGrownTrailingMessage(fileSize int, indexedSegments []common.Location, layouts []common.MessageLayout) bool {
 	if len(indexedSegments) == 0 {
 		return false
 	}
 	trailingSegment := indexedSegments[len(indexedSegments)-1]
-	return trailingSegment.Len() < segmentLen && trailingSegment.To < fileSize
+	if trailingSegment.To >= fileSize {
+		return false
+	}
+	_, found := slices.BinarySearchFunc(
+		layouts,
+		trailingSegment.To,
+		func(l common.MessageLayout, pos int) int { return cmp.Compare(l.Loc.From, pos) },
+	)
+	return !found
 }
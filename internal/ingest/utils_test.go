@@ -1,6 +1,7 @@
 package ingest
 
 import (
+	"reflect"
 	"slices"
 	"testing"
 
@@ -215,10 +216,154 @@ func TestFindMisalignedSegments(t *testing.T) {
 	}
 }
 
+func TestTailScan(t *testing.T) {
+	tests := []struct {
+		name            string
+		indexedSegments []common.Location
+		fileSize        int
+		tailLayouts     []common.MessageLayout
+		wantStart       int
+		wantOk          bool
+		wantAligned     bool
+	}{
+		{
+			name:     "not indexed",
+			fileSize: 100,
+		},
+		{
+			name:            "gap in the index",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 20, To: 30}},
+			fileSize:        100,
+		},
+		{
+			name:            "file shrank",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 10, To: 30}},
+			fileSize:        20,
+		},
+		{
+			name:            "file grew",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 10, To: 30}},
+			fileSize:        50,
+			tailLayouts: []common.MessageLayout{
+				{Loc: common.Location{From: 10, To: 30}},
+				{Loc: common.Location{From: 30, To: 50}},
+			},
+			wantStart:   10,
+			wantOk:      true,
+			wantAligned: true,
+		},
+		{
+			name:            "file did not change",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 10, To: 30}},
+			fileSize:        30,
+			tailLayouts:     []common.MessageLayout{{Loc: common.Location{From: 10, To: 30}}},
+			wantStart:       10,
+			wantOk:          true,
+			wantAligned:     true,
+		},
+		{
+			name:            "last segment start moved",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 10, To: 30}},
+			fileSize:        50,
+			tailLayouts:     []common.MessageLayout{{Loc: common.Location{From: 15, To: 50}}},
+			wantStart:       10,
+			wantOk:          true,
+		},
+		{
+			name:            "last message grew",
+			indexedSegments: []common.Location{{From: 0, To: 10}, {From: 10, To: 30}},
+			fileSize:        50,
+			tailLayouts: []common.MessageLayout{
+				{Loc: common.Location{From: 10, To: 35}},
+				{Loc: common.Location{From: 35, To: 50}},
+			},
+			wantStart:   10,
+			wantOk:      true,
+			wantAligned: true,
+		},
+		{
+			name:            "no messages in the tail",
+			indexedSegments: []common.Location{{From: 0, To: 10}},
+			fileSize:        50,
+			wantStart:       0,
+			wantOk:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				start, ok := tailScanStart(tt.indexedSegments, tt.fileSize)
+				if start != tt.wantStart || ok != tt.wantOk {
+					t.Errorf("tailScanStart() = %d, %v, want %d, %v", start, ok, tt.wantStart, tt.wantOk)
+				}
+				if !ok {
+					return
+				}
+				lastSegment := tt.indexedSegments[len(tt.indexedSegments)-1]
+				if aligned := isTailAligned(lastSegment, tt.tailLayouts); aligned != tt.wantAligned {
+					t.Errorf("isTailAligned() = %v, want %v", aligned, tt.wantAligned)
+				}
+			},
+		)
+	}
+}
+
+func TestHasGrownTrailingMessage(t *testing.T) {
+	tests := []struct {
+		name            string
+		indexedSegments []common.Location
+		fileSize        int
+		layouts         []common.MessageLayout
+		want            bool
+	}{
+		{
+			name:     "not indexed",
+			fileSize: 50,
+		},
+		{
+			name:            "file did not change",
+			indexedSegments: []common.Location{{From: 10, To: 30}},
+			fileSize:        30,
+			layouts:         []common.MessageLayout{{Loc: common.Location{From: 10, To: 30}}},
+		},
+		{
+			name:            "new message appended",
+			indexedSegments: []common.Location{{From: 10, To: 30}},
+			fileSize:        50,
+			layouts: []common.MessageLayout{
+				{Loc: common.Location{From: 10, To: 30}},
+				{Loc: common.Location{From: 30, To: 50}},
+			},
+		},
+		{
+			name:            "last message grew",
+			indexedSegments: []common.Location{{From: 10, To: 30}},
+			fileSize:        50,
+			layouts: []common.MessageLayout{
+				{Loc: common.Location{From: 10, To: 35}},
+				{Loc: common.Location{From: 35, To: 50}},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := hasGrownTrailingMessage(tt.fileSize, tt.indexedSegments, tt.layouts); got != tt.want {
+					t.Errorf("hasGrownTrailingMessage() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
 func TestSegmentLayoutsByLocations(t *testing.T) {
 	tests := []struct {
 		name        string
 		segmentSize int
+		maxMessages int
 		locs        []common.Location
 		layouts     []common.MessageLayout
 		want        [][]common.MessageLayout
@@ -429,6 +574,23 @@ func TestSegmentLayoutsByLocations(t *testing.T) {
 			},
 			want: [][]common.MessageLayout{},
 		},
+		{
+			name:        "max messages",
+			segmentSize: 100,
+			maxMessages: 2,
+			locs: []common.Location{
+				{From: 0, To: 100},
+			},
+			layouts: []common.MessageLayout{
+				{Loc: common.Location{From: 0, To: 10}},
+				{Loc: common.Location{From: 10, To: 20}},
+				{Loc: common.Location{From: 20, To: 30}},
+			},
+			want: [][]common.MessageLayout{
+				{{Loc: common.Location{From: 0, To: 10}}, {Loc: common.Location{From: 10, To: 20}}},
+				{{Loc: common.Location{From: 20, To: 30}}},
+			},
+		},
 		{
 			name:        "partial overlap left",
 			segmentSize: 100,
@@ -455,12 +617,27 @@ func TestSegmentLayoutsByLocations(t *testing.T) {
 				{{Loc: common.Location{From: 16, To: 30}}},
 			},
 		},
+		{
+			name:        "touching layouts are not included",
+			segmentSize: 100,
+			locs: []common.Location{
+				{From: 10, To: 20},
+			},
+			layouts: []common.MessageLayout{
+				{Loc: common.Location{From: 0, To: 10}},
+				{Loc: common.Location{From: 10, To: 20}},
+				{Loc: common.Location{From: 20, To: 30}},
+			},
+			want: [][]common.MessageLayout{
+				{{Loc: common.Location{From: 10, To: 20}}},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got := alignSegmentsByMessageBoundaries(tt.segmentSize, tt.locs, tt.layouts)
+				got := alignSegmentsByMessageBoundaries(tt.segmentSize, tt.maxMessages, tt.locs, tt.layouts)
 				if len(got) != len(tt.want) {
 					t.Errorf("alignByLayouts() got = %v, want %v", got, tt.want)
 				}
@@ -469,7 +646,7 @@ func TestSegmentLayoutsByLocations(t *testing.T) {
 						t.Errorf("alignByLayouts() layouts %d got = %v, want %v", i, got[i], tt.want[i])
 					}
 					for j := range got[i] {
-						if got[i][j] != tt.want[i][j] {
+						if !reflect.DeepEqual(got[i][j], tt.want[i][j]) {
 							t.Errorf(
 								"alignByLayouts() element [%d][%d] got = %v, want %v",
 								i,
@@ -0,0 +1,174 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Watcher watches directories behind glob patterns and reports files that changed.
+// Events are debounced: all files changed within the window are reported as one batch,
+// so a busy log file triggers at most one ingestion per window.
+// Files that are gone are held back for one more window, so a rename whose events fall into two windows
+// is reported as one batch with both paths, and the ingestor finds the new path by the fingerprint
+// instead of wiping the file.
+type Watcher struct {
+	globs    []string
+	debounce time.Duration
+	logger   *zap.Logger
+}
+
+func NewWatcher(globs []string, debounce time.Duration, logger *zap.Logger) *Watcher {
+	if len(globs) == 0 {
+		panic("no glob patterns provided")
+	}
+	return &Watcher{
+		globs:    globs,
+		debounce: debounce,
+		logger:   logger,
+	}
+}
+
+// Watch blocks until the context is done and calls onChange with files that were written, created,
+// renamed or removed. onChange is called synchronously, events that arrive meanwhile are batched for the next call.
+func (w *Watcher) Watch(ctx context.Context, onChange func(files []string)) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("new watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	w.watchDirs(fsw)
+
+	var (
+		pending = make(map[string]struct{})
+		// gone files of the previous window, they are reported with the next one
+		deferred = make(map[string]struct{})
+		timer    = time.NewTimer(0)
+	)
+	<-timer.C // the timer runs only while there are pending events
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Warn("watch files", zap.Error(err))
+		case e, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if e.Has(fsnotify.Create) {
+				if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+					w.watchDirs(fsw) // a new directory may match a glob pattern
+					continue
+				}
+			}
+			if !e.Has(fsnotify.Write) && !e.Has(fsnotify.Create) && !e.Has(fsnotify.Rename) && !e.Has(fsnotify.Remove) {
+				continue
+			}
+			if !w.matches(e.Name) {
+				continue
+			}
+			if len(pending) == 0 && len(deferred) == 0 {
+				timer.Reset(w.debounce) // the window starts at the first event
+			}
+			pending[filepath.Clean(e.Name)] = struct{}{}
+		case <-timer.C:
+			files := make([]string, 0, len(pending)+len(deferred))
+			for f := range deferred {
+				files = append(files, f)
+			}
+			clear(deferred)
+			for f := range pending {
+				if _, err := os.Lstat(f); errors.Is(err, fs.ErrNotExist) && !slices.Contains(files, f) {
+					deferred[f] = struct{}{} // its new path may come in the next window
+					continue
+				}
+				if !slices.Contains(files, f) {
+					files = append(files, f)
+				}
+			}
+			clear(pending)
+			if len(deferred) > 0 {
+				timer.Reset(w.debounce)
+			}
+			if len(files) == 0 {
+				continue
+			}
+			slices.Sort(files)
+			onChange(files)
+		}
+	}
+}
+
+// watchDirs adds directories of glob patterns to the watcher (already watched ones are ignored).
+func (w *Watcher) watchDirs(fsw *fsnotify.Watcher) {
+	watched := fsw.WatchList()
+	for _, pattern := range w.globs {
+		var (
+			dirs []string
+			err  error
+		)
+		if isRecursiveGlob(pattern) {
+			dirs = subdirs(globBase(pattern))
+		} else {
+			dirs, err = filepath.Glob(filepath.Dir(pattern))
+		}
+		if err != nil {
+			w.logger.Warn("watch files", zap.String("pattern", pattern), zap.Error(err))
+			continue
+		}
+		for _, dir := range dirs {
+			if slices.Contains(watched, dir) {
+				continue
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				continue
+			}
+			err = fsw.Add(dir)
+			if err != nil {
+				w.logger.Warn("watch directory", zap.String("dir", dir), zap.Error(err))
+				continue
+			}
+			watched = append(watched, dir)
+		}
+	}
+}
+
+// matches checks if the file matches any glob pattern
+func (w *Watcher) matches(file string) bool {
+	file = filepath.Clean(file)
+	for _, pattern := range w.globs {
+		if matchGlob(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// subdirs returns the directory and all directories nested in it
+func subdirs(dir string) []string {
+	var dirs []string
+	_ = filepath.WalkDir(
+		dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		},
+	)
+	return dirs
+}
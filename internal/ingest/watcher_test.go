@@ -0,0 +1,114 @@
+package ingest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"heaplog_2024/internal/common"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	otherFile := filepath.Join(dir, "app.txt")
+	require.NoError(t, os.WriteFile(logFile, []byte("first\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	batches := make(chan []string, 10)
+	watcher := NewWatcher([]string{filepath.Join(dir, "*.log")}, 100*time.Millisecond, zap.NewNop())
+	watchErr := make(chan error)
+	go func() { watchErr <- watcher.Watch(ctx, func(files []string) { batches <- files }) }()
+	time.Sleep(100 * time.Millisecond) // let it start watching
+
+	// many changes within the window are reported once, unmatched files are ignored
+	newFile := filepath.Join(dir, "new.log")
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	for range 10 {
+		_, err = f.WriteString("line\n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	require.NoError(t, os.WriteFile(newFile, []byte("new\n"), 0644))
+	require.NoError(t, os.WriteFile(otherFile, []byte("other\n"), 0644))
+
+	select {
+	case files := <-batches:
+		require.Equal(t, []string{logFile, newFile}, files)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no changes reported")
+	}
+
+	// removal is reported too
+	require.NoError(t, os.Remove(newFile))
+	select {
+	case files := <-batches:
+		require.Equal(t, []string{newFile}, files)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no changes reported")
+	}
+
+	cancel()
+	require.NoError(t, <-watchErr)
+}
+
+func TestWatcherRenameAcrossWindows(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	rotatedFile := filepath.Join(dir, "rotated.log")
+	require.NoError(t, common.PopulateFiles(map[string][]byte{logFile: []byte(common.SampleLog1)}))
+
+	ingestor, duck := makeTestIngestor(t, []string{filepath.Join(dir, "*.log")})
+	require.NoError(t, ingestor.Run())
+	segments, err := duck.GetSegments()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	debounce := 500 * time.Millisecond
+	batches := make(chan []string, 10)
+	watcher := NewWatcher([]string{filepath.Join(dir, "*.log")}, debounce, zap.NewNop())
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- watcher.Watch(
+			ctx, func(files []string) {
+				if err := ingestor.RunFiles(files); err != nil {
+					t.Error(err)
+				}
+				batches <- files
+			},
+		)
+	}()
+	time.Sleep(100 * time.Millisecond) // let it start watching
+
+	// the file leaves in one window and comes back under the new name in the next one
+	movedFile := filepath.Join(outside, "app.log")
+	require.NoError(t, os.Rename(logFile, movedFile))
+	time.Sleep(debounce * 3 / 2)
+	require.NoError(t, os.Rename(movedFile, rotatedFile))
+
+	select {
+	case files := <-batches:
+		require.Equal(t, []string{logFile, rotatedFile}, files)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no changes reported")
+	}
+
+	// segments follow the file without re-indexing
+	newSegments, err := duck.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, segments[logFile], newSegments[rotatedFile])
+	require.NotContains(t, newSegments, logFile)
+
+	cancel()
+	require.NoError(t, <-watchErr)
+}
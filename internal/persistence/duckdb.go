@@ -1,14 +1,19 @@
 package persistence
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"iter"
+	"maps"
 	"math"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,7 +24,7 @@ import (
 	"heaplog_2024/internal/common"
 )
 
-//go:embed migrations/_.sql
+//go:embed migrations/*.sql
 var migrationFS embed.FS
 
 type DuckDB struct {
@@ -103,9 +108,16 @@ func (duck *DuckDB) PutResultsAsync(query common.UserQuery, results iter.Seq[com
 	if query.ToDate != nil {
 		maxDateMicro = query.ToDate.UnixMicro()
 	}
+	var fields []byte
+	if len(query.Fields) > 0 {
+		fields, err = json.Marshal(query.Fields)
+		if err != nil {
+			return
+		}
+	}
 	_, err = tx.Exec(
-		"INSERT INTO queries (queryId, text, date_min, date_max, messages, finished, built_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		queryId, query.Query, minDateMicro, maxDateMicro, 0, false, now.UnixMicro(),
+		"INSERT INTO queries (queryId, text, sources, fields, date_min, date_max, messages, finished, built_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		queryId, query.Query, strings.Join(query.Sources, ","), string(fields), minDateMicro, maxDateMicro, 0, false, now.UnixMicro(),
 	)
 	if err != nil {
 		return
@@ -156,6 +168,7 @@ func (duck *DuckDB) PutResultsAsync(query common.UserQuery, results iter.Seq[com
 				msg.Loc.From,
 				msg.Loc.To-msg.Loc.From,
 				dateMicro,
+				fieldsToMap(msg.Fields),
 			)
 			if err != nil {
 				duck.logger.Error("could not append row to query_results", zap.Error(err))
@@ -199,7 +212,7 @@ func (duck *DuckDB) PutResultsAsync(query common.UserQuery, results iter.Seq[com
 
 func (duck *DuckDB) GetResultMessages(resultId, skip, limit int) (iter.Seq[common.FileMessage], error) {
 	q := `
-		SELECT files.path, pos, len, date
+		SELECT files.path, files.source, pos, len, date, fields
 		FROM query_results
 		JOIN files ON files.id = file_id
 		WHERE query_id = ?
@@ -215,12 +228,16 @@ func (duck *DuckDB) GetResultMessages(resultId, skip, limit int) (iter.Seq[commo
 		defer rows.Close()
 		for rows.Next() {
 			msg := common.FileMessage{}
-			var dateMicro int64
-			err = rows.Scan(&msg.File, &msg.Loc.From, &msg.Loc.To, &dateMicro)
+			var (
+				dateMicro int64
+				fields    any
+			)
+			err = rows.Scan(&msg.File, &msg.Source, &msg.Loc.From, &msg.Loc.To, &dateMicro, &fields)
 			if err != nil {
 				panic(err)
 			}
 			msg.Date = time.UnixMicro(dateMicro).UTC()
+			msg.Fields = fieldsFromMap(fields)
 			msg.Loc.To += msg.Loc.From // Convert length to absolute position
 			if !yield(msg) {
 				break
@@ -231,7 +248,7 @@ func (duck *DuckDB) GetResultMessages(resultId, skip, limit int) (iter.Seq[commo
 
 func (duck *DuckDB) GetResults(ids []int) (map[int]*common.SearchResult, error) {
 	q := `
-		SELECT queryId, text, built_at, messages, finished, date_min, date_max 
+		SELECT queryId, text, sources, fields, built_at, messages, finished, date_min, date_max 
 		FROM queries
 		WHERE %s
 		ORDER BY built_at DESC
@@ -251,10 +268,13 @@ func (duck *DuckDB) GetResults(ids []int) (map[int]*common.SearchResult, error)
 	results := make(map[int]*common.SearchResult)
 	for rows.Next() {
 		var r common.SearchResult
+		var sources, fields string
 		var builtAt, minDateMicro, maxDateMicro int64
 		err = rows.Scan(
 			&r.Id,
 			&r.Query,
+			&sources,
+			&fields,
 			&builtAt,
 			&r.Messages,
 			&r.Finished,
@@ -265,6 +285,14 @@ func (duck *DuckDB) GetResults(ids []int) (map[int]*common.SearchResult, error)
 			return nil, err
 		}
 		r.CreatedAt = time.UnixMicro(builtAt).UTC()
+		if sources != "" {
+			r.Sources = strings.Split(sources, ",")
+		}
+		if fields != "" {
+			if err = json.Unmarshal([]byte(fields), &r.Fields); err != nil {
+				return nil, fmt.Errorf("decode fields filter of query %d: %w", r.Id, err)
+			}
+		}
 
 		if minDateMicro > 0 {
 			t := time.UnixMicro(minDateMicro).UTC()
@@ -324,19 +352,196 @@ func (duck *DuckDB) getFileIdByPath(path string) (id int, err error) {
 	err = tx.Commit()
 	return
 }
+
+// Migrate creates the schema ("_.sql") and applies numbered migrations on top of it in order.
+// Numbered migrations run every time, so they must be idempotent.
 func (duck *DuckDB) Migrate() (err error) {
-	f, err := migrationFS.Open("migrations/_.sql")
+	migrateContent, err := fs.ReadFile(migrationFS, "migrations/_.sql")
 	if err != nil {
 		return err
 	}
-	migrateContent, err := io.ReadAll(f)
+	_, err = duck.db.Exec(string(migrateContent))
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+
+	entries, err := fs.ReadDir(migrationFS, "migrations") // sorted by name
 	if err != nil {
 		return err
 	}
-	_, err = duck.db.Exec(string(migrateContent))
-	if err != nil && strings.Contains(err.Error(), "already exists") {
-		return nil // skip migrations
+	for _, e := range entries {
+		if e.Name() == "_.sql" {
+			continue
+		}
+		migrateContent, err = fs.ReadFile(migrationFS, "migrations/"+e.Name())
+		if err != nil {
+			return err
+		}
+		_, err = duck.db.Exec(string(migrateContent))
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
 	}
+	return nil
+}
+
+// GetFiles returns all files known to the index
+func (duck *DuckDB) GetFiles() (map[string]common.IndexedFile, error) {
+	rows, err := duck.db.Query("SELECT path, source, inode, device, head_len, head_hash, blacklisted FROM files")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]common.IndexedFile)
+	for rows.Next() {
+		var (
+			file     string
+			f        common.IndexedFile
+			headHash int64
+		)
+		err = rows.Scan(
+			&file,
+			&f.Source,
+			&f.Fingerprint.Inode,
+			&f.Fingerprint.Device,
+			&f.Fingerprint.HeadLen,
+			&headHash,
+			&f.Blacklisted,
+		)
+		if err != nil {
+			return nil, err
+		}
+		f.Fingerprint.HeadHash = uint64(headHash)
+		result[file] = f
+	}
+	return result, rows.Err()
+}
+
+// PutFile registers the file as a member of the source and remembers its fingerprint.
+// A changed file (or a file of another source) is not blacklisted anymore.
+func (duck *DuckDB) PutFile(file string, source string, fp common.Fingerprint) error {
+	_, err := duck.getFileIdByPath(file)
+	if err != nil {
+		return err
+	}
+	_, err = duck.db.Exec(
+		`UPDATE files SET
+			blacklisted = blacklisted AND source = ? AND inode = ? AND device = ? AND head_len = ? AND head_hash = ?,
+			source = ?, inode = ?, device = ?, head_len = ?, head_hash = ?
+		WHERE path = ?`,
+		source, fp.Inode, fp.Device, fp.HeadLen, int64(fp.HeadHash),
+		source, fp.Inode, fp.Device, fp.HeadLen, int64(fp.HeadHash),
+		file,
+	)
+	return err
+}
+
+// GetSeekIndex returns the persisted seek index of the compressed file without windows (see GetSeekWindow)
+func (duck *DuckDB) GetSeekIndex(file string) (index common.SeekIndex, found bool, err error) {
+	var blob []byte
+	err = duck.db.QueryRow("SELECT seek_index FROM files WHERE path = ? AND seek_index IS NOT NULL", file).Scan(&blob)
+	if errors.Is(err, sql.ErrNoRows) {
+		return index, false, nil
+	} else if err != nil {
+		return index, false, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(blob)).Decode(&index)
+	if err != nil {
+		return index, false, err
+	}
+	// indexes persisted before windows were kept apart have no Block flags, they are built again
+	if slices.ContainsFunc(index.Checkpoints, func(c common.Checkpoint) bool { return c.Window != nil }) {
+		return common.SeekIndex{}, false, nil
+	}
+	return index, true, nil
+}
+
+// GetSeekWindow returns the window of the checkpoint of the persisted seek index
+func (duck *DuckDB) GetSeekWindow(file string, checkpoint int) (window []byte, err error) {
+	err = duck.db.QueryRow(
+		`SELECT output FROM seek_windows JOIN files ON files.id = seek_windows.file_id
+		WHERE files.path = ? AND checkpoint = ?`,
+		file, checkpoint,
+	).Scan(&window)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no window of checkpoint %d of %s", checkpoint, file)
+	}
+	return window, err
+}
+
+// PutSeekIndex persists the seek index of the compressed file, it is removed along with the file.
+// Windows are kept in a separate table, so opening the file does not load them all.
+func (duck *DuckDB) PutSeekIndex(file string, index common.SeekIndex) error {
+	fileId, err := duck.getFileIdByPath(file)
+	if err != nil {
+		return err
+	}
+	tx, err := duck.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec("DELETE FROM seek_windows WHERE file_id = ?", fileId)
+	if err != nil {
+		return err
+	}
+	index.Checkpoints = slices.Clone(index.Checkpoints)
+	for i, c := range index.Checkpoints {
+		if c.Window == nil {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO seek_windows (file_id, checkpoint, output) VALUES (?,?,?)", fileId, i, c.Window)
+		if err != nil {
+			return err
+		}
+		index.Checkpoints[i].Window = nil
+	}
+
+	var blob bytes.Buffer
+	err = gob.NewEncoder(&blob).Encode(index)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE files SET seek_index = ? WHERE id = ?", blob.Bytes(), fileId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetIndexOptions returns the options that files of sources were indexed with
+func (duck *DuckDB) GetIndexOptions() (map[string]string, error) {
+	rows, err := duck.db.Query("SELECT source, options FROM index_options")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := make(map[string]string)
+	for rows.Next() {
+		var source, o string
+		if err = rows.Scan(&source, &o); err != nil {
+			return nil, err
+		}
+		options[source] = o
+	}
+	return options, rows.Err()
+}
+
+// PutIndexOptions remembers the options that files of the source are indexed with
+func (duck *DuckDB) PutIndexOptions(source string, options string) error {
+	_, err := duck.db.Exec(
+		"INSERT INTO index_options (source, options) VALUES (?, ?) ON CONFLICT (source) DO UPDATE SET options = excluded.options",
+		source, options,
+	)
+	return err
+}
+
+// RenameFile moves the file with its segments to the new path
+func (duck *DuckDB) RenameFile(from, to string) error {
+	_, err := duck.db.Exec("UPDATE files SET path = ? WHERE path = ?", to, from)
 	return err
 }
 
@@ -345,6 +550,7 @@ func (duck *DuckDB) GetSegments() (map[string][]common.Location, error) {
 		SELECT files.path, segments.pos_from, segments.pos_to 
 		FROM segments
 		JOIN files ON files.id=segments.file_id
+		WHERE segments.committed
 		ORDER BY files.id, segments.pos_from -- sort by pos(!)
 `
 	rows, err := duck.db.Query(q)
@@ -365,11 +571,53 @@ func (duck *DuckDB) GetSegments() (map[string][]common.Location, error) {
 	return result, rows.Err()
 }
 
+// PutSegment adds a segment that spans the given messages
 func (duck *DuckDB) PutSegment(file string, messages []common.Message) (segmentId int, err error) {
 	if len(messages) == 0 {
 		err = fmt.Errorf("no messages in segment")
 		return
 	}
+	segment := common.Segment{
+		Location: common.Location{From: messages[0].Loc.From, To: messages[len(messages)-1].Loc.To},
+	}
+	return duck.PutSegmentAt(file, segment, messages)
+}
+
+// PutSegmentAt adds the segment with its messages, some messages of the segment could be skipped (bad dates),
+// so the segment may have no messages at all. A successfully indexed segment lifts the blacklisting of the file.
+func (duck *DuckDB) PutSegmentAt(file string, segment common.Segment, messages []common.Message) (segmentId int, err error) {
+	return duck.putSegment(file, segment, messages, true)
+}
+
+// PutPendingSegment adds the segment like PutSegmentAt, but it is not visible until CommitSegment.
+func (duck *DuckDB) PutPendingSegment(file string, segment common.Segment, messages []common.Message) (
+	segmentId int,
+	err error,
+) {
+	return duck.putSegment(file, segment, messages, false)
+}
+
+// CommitSegment makes the pending segment visible
+func (duck *DuckDB) CommitSegment(segmentId int) error {
+	_, err := duck.db.Exec("UPDATE segments SET committed = true WHERE id = ?", segmentId)
+	return err
+}
+
+func (duck *DuckDB) putSegment(
+	file string,
+	segment common.Segment,
+	messages []common.Message,
+	committed bool,
+) (segmentId int, err error) {
+	var dateMin, dateMax int64
+	if len(messages) > 0 {
+		dateMin, dateMax = messages[0].Date.UnixMicro(), messages[len(messages)-1].Date.UnixMicro()
+	}
+
+	var shortTerms any // NULL if the segment has no filter
+	if segment.ShortTerms != nil {
+		shortTerms = []byte(segment.ShortTerms)
+	}
 
 	tx, err := duck.db.Begin()
 	if err != nil {
@@ -387,17 +635,29 @@ func (duck *DuckDB) PutSegment(file string, messages []common.Message) (segmentI
 		return
 	}
 	_, err = tx.Exec(
-		"INSERT INTO segments (id, file_id, pos_from, pos_to, date_min, date_max) VALUES (?, ?, ?, ?, ?, ?)",
+		`INSERT INTO segments (
+		    id, file_id, pos_from, pos_to, date_min, date_max, date_errors, date_error_sample, date_error, short_terms, committed
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		segmentId,
 		fileId,
-		messages[0].Loc.From,
-		messages[len(messages)-1].Loc.To,
-		messages[0].Date.UnixMicro(),
-		messages[len(messages)-1].Date.UnixMicro(),
+		segment.From,
+		segment.To,
+		dateMin,
+		dateMax,
+		segment.DateErrors.Count,
+		segment.DateErrors.Sample,
+		segment.DateErrors.Error,
+		shortTerms,
+		committed,
 	)
 	if err != nil {
 		return
 	}
+	_, err = tx.Exec("UPDATE files SET blacklisted = false WHERE id = ? AND blacklisted", fileId)
+	if err != nil {
+		return
+	}
 
 	//for _, msg := range messages {
 	//	_, err = tx.Exec(
@@ -416,11 +676,12 @@ func (duck *DuckDB) PutSegment(file string, messages []common.Message) (segmentI
 	for _, msg := range messages {
 		err = duck.messagesAppender.AppendRow(
 			segmentId,
-			msg.Loc.From-messages[0].Loc.From, // relative to segment's pos
-			msg.Loc.To-messages[0].Loc.From,   // relative to segment's pos
-			msg.DateLoc.From-msg.Loc.From,     // relative to message's pos
-			msg.DateLoc.To-msg.Loc.From,       // relative to message's pos
+			msg.Loc.From-segment.From,     // relative to segment's pos
+			msg.Loc.To-segment.From,       // relative to segment's pos
+			msg.DateLoc.From-msg.Loc.From, // relative to message's pos
+			msg.DateLoc.To-msg.Loc.From,   // relative to message's pos
 			msg.Date.UnixMicro(),
+			fieldsToMap(msg.Fields),
 		)
 		if err != nil {
 			duck.logger.Error("could not append row to messages", zap.Error(err))
@@ -437,6 +698,136 @@ func (duck *DuckDB) PutSegment(file string, messages []common.Message) (segmentI
 	return
 }
 
+// GetSegmentsByShortTerms returns segments whose filters may have the terms.
+// Segments without filters (indexed before filters existed) may have any term.
+// Only filters of the candidate segments (all if empty) of files that belong to given sources (all if empty)
+// and overlap the date range are read.
+func (duck *DuckDB) GetSegmentsByShortTerms(
+	ctx context.Context,
+	terms [][]byte,
+	candidates []int,
+	sources []string,
+	minDate, maxDate *time.Time,
+) (map[string][]int, error) {
+
+	minMicro, maxMicro := int64(0), int64(math.MaxInt64)
+	if minDate != nil {
+		minMicro = minDate.UnixMicro()
+	}
+	if maxDate != nil {
+		maxMicro = maxDate.UnixMicro()
+	}
+
+	q := `
+	SELECT segments.id, segments.short_terms
+	FROM segments
+	JOIN files on files.id=segments.file_id
+	WHERE segments.committed AND segments.date_max >= ? AND segments.date_min <= ? AND %s AND %s
+	`
+	segmentsWhere, sourcesWhere := "1=1", "1=1"
+	if len(candidates) > 0 {
+		segmentsWhere = "segments.id IN (" + strings.Repeat("?,", len(candidates)-1) + "?)"
+	}
+	if len(sources) > 0 {
+		sourcesWhere = "files.source IN (" + strings.Repeat("?,", len(sources)-1) + "?)"
+	}
+	q = fmt.Sprintf(q, segmentsWhere, sourcesWhere)
+
+	args := append([]any{minMicro, maxMicro}, asAny(candidates)...)
+	args = append(args, asAny(sources)...)
+	rows, err := duck.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	segments := make(map[string][]int, len(terms))
+	for rows.Next() {
+		var (
+			id     int
+			filter []byte
+		)
+		err = rows.Scan(&id, &filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, term := range terms {
+			if filter == nil || common.BloomFilter(filter).MayContain(term) {
+				segments[string(term)] = append(segments[string(term)], id)
+			}
+		}
+	}
+	return segments, rows.Err()
+}
+
+// BlacklistFile remembers that indexing of the file stopped at a date parse failure (or a read failure)
+func (duck *DuckDB) BlacklistFile(file string, failure common.DateErrors) error {
+	_, err := duck.getFileIdByPath(file)
+	if err != nil {
+		return err
+	}
+	_, err = duck.db.Exec(
+		"UPDATE files SET blacklisted = true, blacklist_sample = ?, blacklist_error = ? WHERE path = ?",
+		failure.Sample, failure.Error, file,
+	)
+	return err
+}
+
+// GetDegradedFiles returns files that have messages with unparsable dates, ordered by path.
+// Counts of blacklisted files include the date failure that stopped indexing.
+func (duck *DuckDB) GetDegradedFiles() ([]common.DegradedFile, error) {
+	q := `
+		SELECT
+			files.path,
+			files.source,
+			files.blacklisted,
+			files.blacklist_sample,
+			files.blacklist_error,
+			COALESCE(SUM(segments.date_errors), 0)::BIGINT,
+			COALESCE(arg_min(segments.date_error_sample, segments.pos_from) FILTER (WHERE segments.date_errors > 0), ''),
+			COALESCE(arg_min(segments.date_error, segments.pos_from) FILTER (WHERE segments.date_errors > 0), '')
+		FROM files
+		LEFT JOIN segments ON segments.file_id = files.id AND segments.committed
+		GROUP BY files.path, files.source, files.blacklisted, files.blacklist_sample, files.blacklist_error
+		HAVING files.blacklisted OR SUM(segments.date_errors) > 0
+		ORDER BY files.path
+	`
+	rows, err := duck.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []common.DegradedFile
+	for rows.Next() {
+		var (
+			f                               common.DegradedFile
+			blacklistSample, blacklistError string
+		)
+		err = rows.Scan(
+			&f.File,
+			&f.Source,
+			&f.Blacklisted,
+			&blacklistSample,
+			&blacklistError,
+			&f.Count,
+			&f.Sample,
+			&f.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if f.Blacklisted {
+			if blacklistSample != "" { // read failures have no sample
+				f.Count++
+			}
+			f.Sample, f.Error = blacklistSample, blacklistError
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
 func (duck *DuckDB) WipeSegment(file string, segment common.Location) (segmentId int, err error) {
 	tx, err := duck.db.Begin()
 	if err != nil {
@@ -450,7 +841,7 @@ func (duck *DuckDB) WipeSegment(file string, segment common.Location) (segmentId
 	}
 
 	err = duck.db.QueryRow(
-		"SELECT id FROM segments WHERE file_id = ? AND pos_from = ? AND pos_to = ?",
+		"SELECT id FROM segments WHERE file_id = ? AND pos_from = ? AND pos_to = ? AND committed",
 		fileId, segment.From, segment.To,
 	).Scan(&segmentId)
 	if err != nil {
@@ -498,6 +889,8 @@ func (duck *DuckDB) WipeSegments(file string) (segmentIds []int, err error) {
 	return
 }
 
+// _txDeleteSegment deletes the segment and journals its id in wiped_segments,
+// the id stays there until the inverted index removes it too (see Index.Recover).
 func (duck *DuckDB) _txDeleteSegment(tx *sql.Tx, segmentId int) error {
 	_, err := tx.Exec("DELETE FROM segments WHERE id = ?", segmentId)
 	if err != nil {
@@ -507,9 +900,71 @@ func (duck *DuckDB) _txDeleteSegment(tx *sql.Tx, segmentId int) error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("INSERT INTO wiped_segments (id) VALUES (?)", segmentId)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// wipeSegmentsById deletes the segments of any file, pending or not
+func (duck *DuckDB) wipeSegmentsById(segmentIds []int) error {
+	tx, err := duck.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, segmentId := range segmentIds {
+		err = duck._txDeleteSegment(tx, segmentId)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pendingSegments returns segments that were added but never committed
+func (duck *DuckDB) pendingSegments() ([]int, error) {
+	return duck.selectIds("SELECT id FROM segments WHERE NOT committed ORDER BY id")
+}
+
+// wipedSegments returns segments wiped from the db, but maybe not from the inverted index
+func (duck *DuckDB) wipedSegments() ([]int, error) {
+	return duck.selectIds("SELECT DISTINCT id FROM wiped_segments WHERE NOT removed ORDER BY id")
+}
+
+// markWipedSegmentsRemoved remembers that the inverted index removed the segments.
+// The journal keeps them, so values of the inverted index can be told apart from orphans until merging drops them,
+// see Index.PruneWiped.
+func (duck *DuckDB) markWipedSegmentsRemoved(segmentIds []int) error {
+	if len(segmentIds) == 0 {
+		return nil
+	}
+	_, err := duck.db.Exec(
+		"UPDATE wiped_segments SET removed = true WHERE id IN ("+strings.Repeat("?,", len(segmentIds)-1)+"?)",
+		asAny(segmentIds)...,
+	)
+	return err
+}
+
+func (duck *DuckDB) selectIds(q string) (ids []int, err error) {
+	rows, err := duck.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func (duck *DuckDB) WipeFile(file string) error {
 	tx, err := duck.db.Begin()
 	if err != nil {
@@ -521,6 +976,10 @@ func (duck *DuckDB) WipeFile(file string) error {
 	if err != nil {
 		return err
 	}
+	_, err = duck.db.Exec("DELETE FROM seek_windows WHERE file_id IN (SELECT id FROM files WHERE path = ?)", file)
+	if err != nil {
+		return err
+	}
 	_, err = duck.db.Exec("DELETE FROM files WHERE path = ?", file)
 	if err != nil {
 		return err
@@ -529,9 +988,12 @@ func (duck *DuckDB) WipeFile(file string) error {
 }
 
 // Main gateway for getting messages from the database.
+// Empty segments or sources mean all of them, empty fields filter means no filtering by fields.
 func (duck *DuckDB) GetMessages(
 	ctx context.Context,
 	segments []int,
+	sources []string,
+	fields common.FieldsFilter,
 	minDate, maxDate *time.Time,
 ) (iter.Seq[common.FileMessage], error) {
 
@@ -546,6 +1008,7 @@ func (duck *DuckDB) GetMessages(
 	q := `
 	SELECT
 	    files.path,
+	    files.source,
 	    
 	    segments.id,
 	    segments.pos_from,
@@ -555,24 +1018,32 @@ func (duck *DuckDB) GetMessages(
 	    messages.rel_to,
 	    messages.rel_date_from,
 	    messages.rel_date_to,
-	    messages.date
+	    messages.date,
+	    messages.fields
 	
 	FROM messages
 	JOIN segments on segments.id=messages.segment_id 
 	JOIN files on files.id=segments.file_id 
-	WHERE messages.date >= ? AND messages.date <= ? AND %s
+	WHERE segments.committed AND messages.date >= ? AND messages.date <= ? AND %s AND %s AND %s
 	ORDER BY messages.date
 	`
+	segmentsWhere, sourcesWhere, fieldsWhere := "1=1", "1=1", "1=1"
 	if len(segments) > 0 {
-		q = fmt.Sprintf(q, "segments.id IN ("+strings.Repeat("?,", len(segments)-1)+"?)")
-	} else {
-		q = fmt.Sprintf(q, "1=1")
+		segmentsWhere = "segments.id IN (" + strings.Repeat("?,", len(segments)-1) + "?)"
+	}
+	if len(sources) > 0 {
+		sourcesWhere = "files.source IN (" + strings.Repeat("?,", len(sources)-1) + "?)"
 	}
+	fieldsPredicates, fieldsArgs := fieldsWhereClause(fields)
+	if fieldsPredicates != "" {
+		fieldsWhere = fieldsPredicates
+	}
+	q = fmt.Sprintf(q, segmentsWhere, sourcesWhere, fieldsWhere)
 
-	rows, err := duck.db.Query(
-		q,
-		append([]any{minMicro, maxMicro}, asAny(segments)...)...,
-	)
+	args := append([]any{minMicro, maxMicro}, asAny(segments)...)
+	args = append(args, asAny(sources)...)
+	args = append(args, fieldsArgs...)
+	rows, err := duck.db.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -584,6 +1055,7 @@ func (duck *DuckDB) GetMessages(
 
 		var (
 			segmentId, segmentFrom, segmentTo, dateMicro int
+			fields                                       any
 		)
 		for rows.Next() {
 
@@ -596,6 +1068,7 @@ func (duck *DuckDB) GetMessages(
 			cur := common.FileMessage{}
 			err = rows.Scan(
 				&cur.File,
+				&cur.Source,
 
 				&segmentId,
 				&segmentFrom,
@@ -606,12 +1079,14 @@ func (duck *DuckDB) GetMessages(
 				&cur.DateLoc.From,
 				&cur.DateLoc.To,
 				&dateMicro,
+				&fields,
 			)
 			if err != nil {
 				panic(err)
 			}
 
 			cur.Date = time.UnixMicro(int64(dateMicro)).UTC()
+			cur.Fields = fieldsFromMap(fields)
 			cur.Loc.From += segmentFrom
 			cur.Loc.To += segmentFrom
 			cur.DateLoc.From += cur.Loc.From
@@ -623,3 +1098,45 @@ func (duck *DuckDB) GetMessages(
 		}
 	}, nil
 }
+
+// fieldsWhereClause makes SQL predicates for the fields filter: values of a field are OR-ed, fields are AND-ed.
+// Returns an empty clause if there is nothing to filter.
+func fieldsWhereClause(fields common.FieldsFilter) (where string, args []any) {
+	var predicates []string
+	for _, name := range slices.Sorted(maps.Keys(fields)) { // stable query text
+		values := fields[name]
+		if len(values) == 0 {
+			predicates = append(predicates, "messages.fields[?] IS NOT NULL")
+			args = append(args, name)
+			continue
+		}
+		predicates = append(predicates, "messages.fields[?] IN ("+strings.Repeat("?,", len(values)-1)+"?)")
+		args = append(append(args, name), asAny(values)...)
+	}
+	return strings.Join(predicates, " AND "), args
+}
+
+// fieldsToMap prepares message fields for the appender, no fields are stored as NULL
+func fieldsToMap(fields map[string]string) any {
+	if len(fields) == 0 {
+		return nil
+	}
+	m := make(duckdb.Map, len(fields))
+	for k, v := range fields {
+		m[k] = v
+	}
+	return m
+}
+
+// fieldsFromMap reads a scanned MAP column, NULL and empty maps are returned as nil
+func fieldsFromMap(v any) map[string]string {
+	m, ok := v.(duckdb.Map)
+	if !ok || len(m) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(m))
+	for k, v := range m {
+		fields[fmt.Sprint(k)] = fmt.Sprint(v)
+	}
+	return fields
+}
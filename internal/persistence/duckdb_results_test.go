@@ -83,7 +83,8 @@ func TestResults(t *testing.T) {
 
 	messages := []common.FileMessage{
 		{
-			File: "path1",
+			File:   "path1",
+			Source: "default",
 			Message: common.Message{
 				MessageLayout: common.MessageLayout{
 					Loc: common.Location{From: 0, To: 10},
@@ -92,7 +93,8 @@ func TestResults(t *testing.T) {
 			},
 		},
 		{
-			File: "path1",
+			File:   "path1",
+			Source: "default",
 			Message: common.Message{
 				MessageLayout: common.MessageLayout{
 					Loc: common.Location{From: 10, To: 20},
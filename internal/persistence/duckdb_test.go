@@ -340,7 +340,7 @@ func TestGetMessages(t *testing.T) {
 					}
 				}
 
-				messagesSeq, err := db.GetMessages(context.Background(), segmentIds, tt.minDate, tt.maxDate)
+				messagesSeq, err := db.GetMessages(context.Background(), segmentIds, nil, nil, tt.minDate, tt.maxDate)
 				require.NoError(t, err)
 				messages := slices.Collect(messagesSeq)
 
@@ -348,7 +348,7 @@ func TestGetMessages(t *testing.T) {
 				for path, segments := range tt.input {
 					for _, segment := range segments {
 						for _, msg := range segment {
-							expectedMessages = append(expectedMessages, common.FileMessage{File: path, Message: msg})
+							expectedMessages = append(expectedMessages, common.FileMessage{File: path, Source: "default", Message: msg})
 						}
 					}
 				}
@@ -376,3 +376,332 @@ func TestGetMessages(t *testing.T) {
 	}
 
 }
+
+func TestGetMessagesBySources(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	require.NoError(t, db.PutFile("path1", "app", common.Fingerprint{}))
+	require.NoError(t, db.PutFile("path2", "worker", common.Fingerprint{}))
+	for _, path := range []string{"path1", "path2", "path3"} {
+		_, err = db.PutSegment(
+			path, []common.Message{
+				{
+					MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+					Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+				},
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	files, err := db.GetFiles()
+	require.NoError(t, err)
+	require.Equal(
+		t, map[string]common.IndexedFile{
+			"path1": {Source: "app"},
+			"path2": {Source: "worker"},
+			"path3": {Source: "default"},
+		}, files,
+	)
+
+	messagesSeq, err := db.GetMessages(context.Background(), nil, []string{"worker", "default"}, nil, nil, nil)
+	require.NoError(t, err)
+	var messageFiles []string
+	for m := range messagesSeq {
+		messageFiles = append(messageFiles, m.File)
+	}
+	slices.Sort(messageFiles)
+	require.Equal(t, []string{"path2", "path3"}, messageFiles)
+}
+
+func TestGetSegmentsByShortTerms(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	require.NoError(t, db.PutFile("path1", "app", common.Fingerprint{}))
+	require.NoError(t, db.PutFile("path2", "worker", common.Fingerprint{}))
+
+	put := func(file, date string, shortTerms common.BloomFilter) int {
+		messages := []common.Message{
+			{
+				MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+				Date:          common.MakeTimeV(date),
+			},
+		}
+		segment := common.Segment{Location: common.Location{From: 0, To: 10}, ShortTerms: shortTerms}
+		id, err := db.PutSegmentAt(file, segment, messages)
+		require.NoError(t, err)
+		return id
+	}
+	day := "2024-01-01T00:00:00.000000+00:00"
+	jobSegment := put("path1", day, common.NewBloomFilter([][]byte{[]byte("job"), []byte("42")}))
+	put("path1", day, common.NewBloomFilter(nil))
+	oldSegment := put("path1", day, nil) // indexed without a filter
+	nextDaySegment := put("path1", "2024-01-02T00:00:00.000000+00:00", nil)
+	workerSegment := put("path2", day, nil)
+
+	terms := [][]byte{[]byte("job"), []byte("7")}
+	lookup := func(candidates []int, sources []string, minDate, maxDate *time.Time) map[string][]int {
+		segments, err := db.GetSegmentsByShortTerms(context.Background(), terms, candidates, sources, minDate, maxDate)
+		require.NoError(t, err)
+		for _, ids := range segments {
+			slices.Sort(ids)
+		}
+		return segments
+	}
+
+	require.Equal(
+		t,
+		map[string][]int{
+			"job": {jobSegment, oldSegment, nextDaySegment, workerSegment},
+			"7":   {oldSegment, nextDaySegment, workerSegment},
+		},
+		lookup(nil, nil, nil, nil),
+	)
+	// candidates from the inverted index
+	require.Equal(
+		t,
+		map[string][]int{"job": {jobSegment, workerSegment}, "7": {workerSegment}},
+		lookup([]int{jobSegment, workerSegment}, nil, nil, nil),
+	)
+	// sources
+	require.Equal(
+		t,
+		map[string][]int{"job": {jobSegment, oldSegment, nextDaySegment}, "7": {oldSegment, nextDaySegment}},
+		lookup(nil, []string{"app"}, nil, nil),
+	)
+	// dates
+	require.Equal(
+		t,
+		map[string][]int{"7": {nextDaySegment}, "job": {nextDaySegment}},
+		lookup(nil, []string{"app"}, common.MakeTimeP("2024-01-01T12:00:00.000000+00:00"), nil),
+	)
+	require.Equal(
+		t,
+		map[string][]int{"job": {jobSegment, oldSegment}, "7": {oldSegment}},
+		lookup(nil, []string{"app"}, nil, common.MakeTimeP("2024-01-01T12:00:00.000000+00:00")),
+	)
+}
+
+func TestRenameFile(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	fp := common.Fingerprint{Inode: 1, Device: 2, HeadLen: 3, HeadHash: 4}
+	require.NoError(t, db.PutFile("path1", "app", fp))
+	_, err = db.PutSegment(
+		"path1", []common.Message{
+			{
+				MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+				Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, db.RenameFile("path1", "path2"))
+
+	files, err := db.GetFiles()
+	require.NoError(t, err)
+	require.Equal(t, map[string]common.IndexedFile{"path2": {Source: "app", Fingerprint: fp}}, files)
+
+	segments, err := db.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]common.Location{"path2": {{From: 0, To: 10}}}, segments)
+}
+
+func TestSeekIndex(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	_, found, err := db.GetSeekIndex("path1.gz")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	index := common.SeekIndex{
+		Size: 100,
+		Checkpoints: []common.Checkpoint{
+			{},
+			{Compressed: 10, Uncompressed: 50, Block: true, Bits: 3, Window: []byte("window")},
+		},
+		FileSize: 20,
+		ModTime:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, db.PutSeekIndex("path1.gz", index))
+	stored, found, err := db.GetSeekIndex("path1.gz")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Nil(t, stored.Checkpoints[1].Window) // loaded by checkpoint
+	stored.Checkpoints[1].Window = index.Checkpoints[1].Window
+	require.Equal(t, index, stored)
+	window, err := db.GetSeekWindow("path1.gz", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("window"), window)
+
+	// the index goes with the file
+	require.NoError(t, db.WipeFile("path1.gz"))
+	_, found, err = db.GetSeekIndex("path1.gz")
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = db.GetSeekWindow("path1.gz", 1)
+	require.Error(t, err)
+}
+
+func TestGetMessagesByFields(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	messages := []common.Message{
+		{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+			Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+			Fields:        map[string]string{"level": "error", "channel": "billing"},
+		},
+		{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: 10, To: 20}},
+			Date:          common.MakeTimeV("2024-01-01T00:00:01.000000+00:00"),
+			Fields:        map[string]string{"level": "info"},
+		},
+		{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: 20, To: 30}},
+			Date:          common.MakeTimeV("2024-01-01T00:00:02.000000+00:00"),
+		},
+	}
+	_, err = db.PutSegment("path1", messages)
+	require.NoError(t, err)
+
+	tests := []struct {
+		fields   common.FieldsFilter
+		expected []int // indexes of messages
+	}{
+		{nil, []int{0, 1, 2}},
+		{common.FieldsFilter{"level": {"error"}}, []int{0}},
+		{common.FieldsFilter{"level": {"error", "info"}}, []int{0, 1}},
+		{common.FieldsFilter{"level": {"info"}, "channel": {"billing"}}, nil},
+		{common.FieldsFilter{"channel": nil}, []int{0}},
+		{common.FieldsFilter{"unknown": {"error"}}, nil},
+	}
+	for _, tt := range tests {
+		messagesSeq, err := db.GetMessages(context.Background(), nil, nil, tt.fields, nil, nil)
+		require.NoError(t, err)
+		var found []int
+		for m := range messagesSeq {
+			i := slices.IndexFunc(messages, func(e common.Message) bool { return e.Loc == m.Loc })
+			require.Equal(t, messages[i].Fields, m.Fields)
+			found = append(found, i)
+		}
+		require.Equal(t, tt.expected, found, "fields %v", tt.fields)
+	}
+}
+
+func TestResultsKeepFields(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	query := common.UserQuery{Query: "error", Fields: common.FieldsFilter{"level": {"error"}}}
+	messages := []common.FileMessage{
+		{
+			File: "path1",
+			Message: common.Message{
+				MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+				Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+				Fields:        map[string]string{"level": "error"},
+			},
+		},
+	}
+	r, done, err := db.PutResultsAsync(query, slices.Values(messages))
+	require.NoError(t, err)
+	<-done
+
+	results, err := db.GetResults([]int{r.Id})
+	require.NoError(t, err)
+	require.Equal(t, query.Fields, results[r.Id].Fields)
+
+	resultMessages, err := db.GetResultMessages(r.Id, 0, 10)
+	require.NoError(t, err)
+	got := slices.Collect(resultMessages)
+	require.Len(t, got, 1)
+	require.Equal(t, messages[0].Fields, got[0].Fields)
+}
+
+func TestDegradedFiles(t *testing.T) {
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	db, err := NewDuckDB(context.Background(), "", logger)
+	require.NoError(t, err)
+
+	message := func(from, to int) common.Message {
+		return common.Message{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: from, To: to}},
+			Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+		}
+	}
+
+	// path1 has skipped messages in two segments, the sample comes from the first one
+	require.NoError(t, db.PutFile("path1", "app", common.Fingerprint{}))
+	_, err = db.PutSegmentAt(
+		"path1",
+		common.Segment{
+			Location:   common.Location{From: 0, To: 20},
+			DateErrors: common.DateErrors{Count: 2, Sample: "yesterday", Error: "bad date"},
+		},
+		[]common.Message{message(10, 20)},
+	)
+	require.NoError(t, err)
+	_, err = db.PutSegmentAt(
+		"path1",
+		common.Segment{
+			Location:   common.Location{From: 20, To: 30},
+			DateErrors: common.DateErrors{Count: 1, Sample: "today", Error: "bad date"},
+		},
+		nil, // all messages are skipped
+	)
+	require.NoError(t, err)
+	// path2 is healthy
+	_, err = db.PutSegment("path2", []common.Message{message(0, 10)})
+	require.NoError(t, err)
+	// path3 is blacklisted
+	require.NoError(t, db.BlacklistFile("path3", common.DateErrors{Count: 1, Sample: "noon", Error: "bad date"}))
+
+	files, err := db.GetDegradedFiles()
+	require.NoError(t, err)
+	require.Equal(
+		t, []common.DegradedFile{
+			{
+				File:       "path1",
+				Source:     "app",
+				DateErrors: common.DateErrors{Count: 3, Sample: "yesterday", Error: "bad date"},
+			},
+			{
+				File:        "path3",
+				Source:      "default",
+				DateErrors:  common.DateErrors{Count: 1, Sample: "noon", Error: "bad date"},
+				Blacklisted: true,
+			},
+		}, files,
+	)
+
+	segments, err := db.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, []common.Location{{From: 0, To: 20}, {From: 20, To: 30}}, segments["path1"])
+
+	// once indexed, the file is not blacklisted anymore
+	_, err = db.PutSegment("path3", []common.Message{message(0, 10)})
+	require.NoError(t, err)
+	files, err = db.GetDegradedFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
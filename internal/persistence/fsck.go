@@ -0,0 +1,367 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	go_iterators "github.com/lezhnev74/go-iterators"
+
+	"heaplog_2024/internal/common"
+)
+
+// checks of the storage
+const (
+	CheckMessages      = "messages"
+	CheckDates         = "dates"
+	CheckFileSize      = "file size"
+	CheckInvertedIndex = "inverted index"
+)
+
+// Problem is an inconsistency of the storage found by Index.Check
+type Problem struct {
+	Check string
+	// the file is indexed again on repair, empty if the problem is not about a known file
+	File string
+	// 0 if the problem is not about one segment
+	Segment int
+	Detail  string
+}
+
+// Check cross-checks segments against their messages and the inverted index, and messages against files.
+// Files that are gone are not checked, ingestion removes them from the index.
+func (i Index) Check(ctx context.Context) (problems []Problem, err error) {
+	checks := []func(context.Context) ([]Problem, error){
+		i.DuckDB.checkMessages,
+		i.DuckDB.checkDates,
+		i.DuckDB.checkFileSizes,
+		i.checkInvertedIndex,
+	}
+	for _, check := range checks {
+		p, err := check(ctx)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
+
+// Repair removes orphan messages and terms, wipes segments of files with problems,
+// and prunes the journal of wiped segments. Returns the files to index again.
+func (i Index) Repair(ctx context.Context, problems []Problem) (files []string, err error) {
+	var orphanMessages, orphanTerms []int
+	for _, p := range problems {
+		switch {
+		case p.File != "":
+			files = append(files, p.File)
+		case p.Check == CheckMessages:
+			orphanMessages = append(orphanMessages, p.Segment)
+		case p.Check == CheckInvertedIndex:
+			orphanTerms = append(orphanTerms, p.Segment)
+		}
+	}
+
+	err = i.DuckDB.deleteMessages(orphanMessages)
+	if err != nil {
+		return nil, fmt.Errorf("db delete orphan messages: %w", err)
+	}
+	err = i.DuckDB.journalWipedSegments(orphanTerms)
+	if err != nil {
+		return nil, fmt.Errorf("db journal orphan terms: %w", err)
+	}
+	err = i.removeWiped(orphanTerms)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(files)
+	files = slices.Compact(files)
+	for _, file := range files {
+		err = i.WipeSegments(file)
+		if err != nil {
+			return nil, fmt.Errorf("wipe %s: %w", file, err)
+		}
+	}
+
+	_, err = i.PruneWiped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// checkInvertedIndex finds segments in the inverted index that the db never had
+func (i Index) checkInvertedIndex(ctx context.Context) ([]Problem, error) {
+	// wiped segments stay in the inverted index until merging
+	known, err := i.DuckDB.selectIds("SELECT id FROM segments UNION SELECT id FROM wiped_segments ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("db segments: %w", err)
+	}
+	segmentTerms, err := i.indexedSegments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for id, terms := range segmentTerms {
+		if _, ok := slices.BinarySearch(known, id); ok {
+			continue
+		}
+		problems = append(
+			problems, Problem{
+				Check:   CheckInvertedIndex,
+				Segment: id,
+				Detail:  fmt.Sprintf("%d terms point to a missing segment", terms),
+			},
+		)
+	}
+	slices.SortFunc(problems, func(a, b Problem) int { return a.Segment - b.Segment })
+	return problems, nil
+}
+
+// PruneWiped deletes segments from the journal of wiped segments once the inverted index has no terms of them
+// (merging dropped them). Returns how many segments were deleted.
+func (i Index) PruneWiped(ctx context.Context) (int, error) {
+	// segments removed before the scan can't get new terms during it
+	removed, err := i.DuckDB.selectIds("SELECT DISTINCT id FROM wiped_segments WHERE removed ORDER BY id")
+	if err != nil {
+		return 0, fmt.Errorf("db wiped segments: %w", err)
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	segmentTerms, err := i.indexedSegments(ctx)
+	if err != nil {
+		return 0, err
+	}
+	gone := slices.DeleteFunc(removed, func(id int) bool { _, ok := segmentTerms[id]; return ok })
+	err = i.DuckDB.deleteWipedSegments(gone)
+	if err != nil {
+		return 0, fmt.Errorf("db delete wiped segments: %w", err)
+	}
+	return len(gone), nil
+}
+
+// indexedSegments counts terms of every segment in the inverted index
+func (i Index) indexedSegments(ctx context.Context) (map[int]int, error) {
+	it, err := i.ii.Read(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("inverted index read: %w", err)
+	}
+	defer it.Close()
+
+	segmentTerms := make(map[int]int)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		tv, err := it.Next()
+		if errors.Is(err, go_iterators.EmptyIterator) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("inverted index read: %w", err)
+		}
+		for _, v := range tv.Values {
+			segmentTerms[int(v)]++
+		}
+	}
+	return segmentTerms, nil
+}
+
+// checkMessages finds messages of missing segments, segments without messages (that were not skipped),
+// and messages outside their segments
+func (duck *DuckDB) checkMessages(ctx context.Context) (problems []Problem, err error) {
+	orphans := `
+		SELECT segment_id, count(*)
+		FROM messages
+		WHERE segment_id NOT IN (SELECT id FROM segments)
+		GROUP BY segment_id
+		ORDER BY segment_id
+	`
+	err = duck.scanProblems(
+		ctx, orphans, func(scan func(...any) error) (Problem, error) {
+			var id, messages int
+			err := scan(&id, &messages)
+			return Problem{
+				Check:   CheckMessages,
+				Segment: id,
+				Detail:  fmt.Sprintf("%d messages of a missing segment", messages),
+			}, err
+		}, &problems,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := `
+		SELECT files.path, segments.id, count(messages.segment_id), count(*) FILTER (
+			WHERE messages.rel_to > segments.pos_to - segments.pos_from OR messages.rel_from >= messages.rel_to
+		)
+		FROM segments
+		JOIN files ON files.id = segments.file_id
+		LEFT JOIN messages ON messages.segment_id = segments.id
+		WHERE segments.committed
+		GROUP BY files.path, segments.id, segments.date_errors
+		HAVING (count(messages.segment_id) = 0 AND segments.date_errors = 0) OR count(*) FILTER (
+			WHERE messages.rel_to > segments.pos_to - segments.pos_from OR messages.rel_from >= messages.rel_to
+		) > 0
+		ORDER BY files.path, segments.id
+	`
+	err = duck.scanProblems(
+		ctx, segments, func(scan func(...any) error) (Problem, error) {
+			p := Problem{Check: CheckMessages}
+			var messages, outside int
+			err := scan(&p.File, &p.Segment, &messages, &outside)
+			p.Detail = fmt.Sprintf("%d of %d messages are outside the segment", outside, messages)
+			if messages == 0 {
+				p.Detail = "the segment has no messages"
+			}
+			return p, err
+		}, &problems,
+	)
+	return problems, err
+}
+
+// checkDates finds segments with messages out of date order, or with dates different from their messages
+func (duck *DuckDB) checkDates(ctx context.Context) (problems []Problem, err error) {
+	q := `
+		SELECT files.path, segments.id, count(*) FILTER (WHERE m.prev_date > m.date), count(*)
+		FROM (
+			SELECT segment_id, rel_from, date, lag(date) OVER (PARTITION BY segment_id ORDER BY rel_from) AS prev_date
+			FROM messages
+		) m
+		JOIN segments ON segments.id = m.segment_id
+		JOIN files ON files.id = segments.file_id
+		WHERE segments.committed
+		GROUP BY files.path, segments.id, segments.date_min, segments.date_max
+		HAVING count(*) FILTER (WHERE m.prev_date > m.date) > 0
+			OR arg_min(m.date, m.rel_from) <> segments.date_min
+			OR arg_max(m.date, m.rel_from) <> segments.date_max
+		ORDER BY files.path, segments.id
+	`
+	err = duck.scanProblems(
+		ctx, q, func(scan func(...any) error) (Problem, error) {
+			p := Problem{Check: CheckDates}
+			var unordered, messages int
+			err := scan(&p.File, &p.Segment, &unordered, &messages)
+			p.Detail = fmt.Sprintf("%d of %d messages are older than the previous one", unordered, messages)
+			if unordered == 0 {
+				p.Detail = "dates of the segment differ from its messages"
+			}
+			return p, err
+		}, &problems,
+	)
+	return problems, err
+}
+
+// checkFileSizes finds files that are shorter than their indexed segments
+func (duck *DuckDB) checkFileSizes(ctx context.Context) (problems []Problem, err error) {
+	q := `
+		SELECT files.path, max(segments.pos_to)
+		FROM segments
+		JOIN files ON files.id = segments.file_id
+		WHERE segments.committed
+		GROUP BY files.path
+		ORDER BY files.path
+	`
+	rows, err := duck.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	opener := common.NewOpener(duck)
+	for rows.Next() {
+		var (
+			file       string
+			indexedLen int
+		)
+		if err = rows.Scan(&file, &indexedLen); err != nil {
+			return nil, err
+		}
+		f, err := opener.OpenFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("open %s: %w", file, err)
+		}
+		size := f.Len()
+		_ = f.Close()
+		if size < indexedLen {
+			problems = append(
+				problems, Problem{
+					Check:  CheckFileSize,
+					File:   file,
+					Detail: fmt.Sprintf("messages are indexed up to %d bytes, but the file has %d", indexedLen, size),
+				},
+			)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// scanProblems appends a problem for every row of the query
+func (duck *DuckDB) scanProblems(
+	ctx context.Context,
+	q string,
+	scan func(func(...any) error) (Problem, error),
+	problems *[]Problem,
+) error {
+	rows, err := duck.db.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scan(rows.Scan)
+		if err != nil {
+			return err
+		}
+		*problems = append(*problems, p)
+	}
+	return rows.Err()
+}
+
+// deleteMessages deletes messages of the segments
+func (duck *DuckDB) deleteMessages(segmentIds []int) error {
+	if len(segmentIds) == 0 {
+		return nil
+	}
+	_, err := duck.db.Exec(
+		"DELETE FROM messages WHERE segment_id IN ("+strings.Repeat("?,", len(segmentIds)-1)+"?)",
+		asAny(segmentIds)...,
+	)
+	return err
+}
+
+// journalWipedSegments adds segments that are not in the db to the journal of wiped segments
+func (duck *DuckDB) journalWipedSegments(segmentIds []int) error {
+	if len(segmentIds) == 0 {
+		return nil
+	}
+	_, err := duck.db.Exec(
+		"INSERT INTO wiped_segments (id) VALUES "+strings.Repeat("(?),", len(segmentIds)-1)+"(?)",
+		asAny(segmentIds)...,
+	)
+	return err
+}
+
+// deleteWipedSegments deletes removed segments from the journal of wiped segments
+func (duck *DuckDB) deleteWipedSegments(segmentIds []int) error {
+	if len(segmentIds) == 0 {
+		return nil
+	}
+	_, err := duck.db.Exec(
+		"DELETE FROM wiped_segments WHERE removed AND id IN ("+strings.Repeat("?,", len(segmentIds)-1)+"?)",
+		asAny(segmentIds)...,
+	)
+	return err
+}
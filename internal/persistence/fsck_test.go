@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/lezhnev74/inverted_index_2"
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal"
+	"heaplog_2024/internal/common"
+)
+
+func TestIndexCheck(t *testing.T) {
+	ctx := context.Background()
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	duck, err := NewDuckDB(ctx, "", logger)
+	require.NoError(t, err)
+	ii, err := inverted_index_2.NewInvertedIndex(t.TempDir(), false)
+	require.NoError(t, err)
+	index, err := NewPersistentIndex(duck, ii)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	okFile, shortFile, datesFile := filepath.Join(dir, "ok.log"), filepath.Join(dir, "short.log"), filepath.Join(dir, "dates.log")
+	require.NoError(
+		t, common.PopulateFiles(
+			map[string][]byte{
+				okFile:    make([]byte, 20),
+				shortFile: make([]byte, 5),
+				datesFile: make([]byte, 20),
+			},
+		),
+	)
+
+	terms := [][]byte{[]byte("error")}
+	message := func(from, to int, date string) common.Message {
+		return common.Message{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: from, To: to}},
+			Date:          common.MakeTimeV(date),
+		}
+	}
+	put := func(file string, messages ...common.Message) int {
+		segment := common.Segment{Location: common.Location{From: messages[0].Loc.From, To: messages[len(messages)-1].Loc.To}}
+		id, err := index.PutSegment(file, segment, terms, messages)
+		require.NoError(t, err)
+		return id
+	}
+
+	put(okFile, message(0, 20, "2024-01-01T00:00:00.000000+00:00"))
+	put(shortFile, message(0, 10, "2024-01-01T00:00:00.000000+00:00"))
+	datesSegment := put(
+		datesFile,
+		message(0, 10, "2024-01-02T00:00:00.000000+00:00"),
+		message(10, 20, "2024-01-01T00:00:00.000000+00:00"),
+	)
+	_, err = duck.db.Exec(
+		"INSERT INTO messages (segment_id, rel_from, rel_to, rel_date_from, rel_date_to, date) VALUES (999, 0, 1, 0, 0, 0)",
+	)
+	require.NoError(t, err)
+	require.NoError(t, ii.Put(terms, 1000))
+
+	problems, err := index.Check(ctx)
+	require.NoError(t, err)
+	require.Equal(
+		t, []Problem{
+			{Check: CheckMessages, Segment: 999, Detail: "1 messages of a missing segment"},
+			{
+				Check:   CheckDates,
+				File:    datesFile,
+				Segment: datesSegment,
+				Detail:  "1 of 2 messages are older than the previous one",
+			},
+			{
+				Check:  CheckFileSize,
+				File:   shortFile,
+				Detail: "messages are indexed up to 10 bytes, but the file has 5",
+			},
+			{Check: CheckInvertedIndex, Segment: 1000, Detail: "1 terms point to a missing segment"},
+		}, problems,
+	)
+
+	files, err := index.Repair(ctx, problems)
+	require.NoError(t, err)
+	require.Equal(t, []string{datesFile, shortFile}, files)
+
+	problems, err = index.Check(ctx)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	segments, err := index.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]common.Location{okFile: {{From: 0, To: 20}}}, segments)
+}
@@ -2,9 +2,11 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/lezhnev74/inverted_index_2"
+	"go.uber.org/zap"
 
 	"heaplog_2024/internal/common"
 )
@@ -15,11 +17,20 @@ type Index struct {
 	ii *inverted_index_2.InvertedIndex
 }
 
+// NewPersistentIndex recovers the stores from a previous crash, see Recover
 func NewPersistentIndex(duck *DuckDB, ii *inverted_index_2.InvertedIndex) (*Index, error) {
-	return &Index{
+	i := &Index{
 		DuckDB: duck,
 		ii:     ii,
-	}, nil
+	}
+	pending, wiped, err := i.Recover()
+	if err != nil {
+		return nil, fmt.Errorf("recover: %w", err)
+	}
+	if pending > 0 || wiped > 0 {
+		duck.logger.Info("recovered unfinished segment writes", zap.Int("pending", pending), zap.Int("wiped", wiped))
+	}
+	return i, nil
 }
 
 func (i Index) GetRelevantSegments(ctx context.Context, terms [][]byte) (map[string][]int, error) {
@@ -49,11 +60,7 @@ func (i Index) WipeSegments(file string) error {
 	if err != nil {
 		return fmt.Errorf("db wipe segments: %w", err)
 	}
-	idsUint32 := make([]uint32, 0, len(ids))
-	for _, id := range ids {
-		idsUint32 = append(idsUint32, uint32(id))
-	}
-	return i.ii.PutRemoved(idsUint32)
+	return i.removeWiped(ids)
 }
 
 func (i Index) WipeSegment(file string, segment common.Location) error {
@@ -61,26 +68,86 @@ func (i Index) WipeSegment(file string, segment common.Location) error {
 	if err != nil {
 		return fmt.Errorf("db wipe segment: %w", err)
 	}
+	return i.removeWiped([]int{id})
+}
 
-	err = i.ii.PutRemoved([]uint32{uint32(id)})
+// PutSegment writes the segment to both stores, it is visible to search only when both have it:
+// the segment is added to the db as pending, its terms are put to the inverted index, then the segment is committed.
+// On failure the segment is wiped, if that fails too, Recover finishes it on the next start.
+func (i Index) PutSegment(file string, segment common.Segment, terms [][]byte, messages []common.Message) (int, error) {
+	segmentId, err := i.DuckDB.PutPendingSegment(file, segment, messages)
 	if err != nil {
-		return fmt.Errorf("inverted index delete: %w", err)
+		return segmentId, fmt.Errorf("db put segment: %w", err)
 	}
 
-	return nil
+	// no terms if all messages were skipped
+	if len(terms) > 0 {
+		err = i.ii.Put(terms, uint32(segmentId))
+		if err != nil {
+			err = fmt.Errorf("inverted index put terms: %w", err)
+			return segmentId, errors.Join(err, i.rollback(segmentId))
+		}
+	}
+
+	err = i.DuckDB.CommitSegment(segmentId)
+	if err != nil {
+		err = fmt.Errorf("db commit segment: %w", err)
+		return segmentId, errors.Join(err, i.rollback(segmentId))
+	}
+
+	return segmentId, nil
 }
 
-func (i Index) PutSegment(file string, terms [][]byte, messages []common.Message) (int, error) {
-	segmentId, err := i.DuckDB.PutSegment(file, messages)
+// Recover reconciles the stores after a crash in the middle of a write:
+// pending segments are wiped (their terms may be in the inverted index already),
+// and the inverted index removes segments that were wiped from the db.
+// Returns how many segments were found in either state.
+func (i Index) Recover() (pending, wiped int, err error) {
+	pendingIds, err := i.DuckDB.pendingSegments()
 	if err != nil {
-		return segmentId, fmt.Errorf("db put segment: %w", err)
+		return 0, 0, fmt.Errorf("db pending segments: %w", err)
+	}
+	err = i.DuckDB.wipeSegmentsById(pendingIds)
+	if err != nil {
+		return 0, 0, fmt.Errorf("db wipe pending segments: %w", err)
 	}
 
-	err = i.ii.Put(terms, uint32(segmentId))
+	wipedIds, err := i.DuckDB.wipedSegments()
+	if err != nil {
+		return 0, 0, fmt.Errorf("db wiped segments: %w", err)
+	}
+	err = i.removeWiped(wipedIds)
 	if err != nil {
-		_, _ = i.DuckDB.WipeSegment(file, messages[0].Loc)
-		return segmentId, fmt.Errorf("inverted index put terms: %w", err)
+		return 0, 0, err
 	}
 
-	return segmentId, nil
+	return len(pendingIds), len(wipedIds) - len(pendingIds), nil // wiped pending segments are in the journal too
+}
+
+func (i Index) rollback(segmentId int) error {
+	err := i.DuckDB.wipeSegmentsById([]int{segmentId})
+	if err != nil {
+		return fmt.Errorf("db rollback segment: %w", err)
+	}
+	return i.removeWiped([]int{segmentId})
+}
+
+// removeWiped removes segments from the inverted index and marks them removed in the journal of wiped segments
+func (i Index) removeWiped(segmentIds []int) error {
+	if len(segmentIds) == 0 {
+		return nil
+	}
+	idsUint32 := make([]uint32, 0, len(segmentIds))
+	for _, id := range segmentIds {
+		idsUint32 = append(idsUint32, uint32(id))
+	}
+	err := i.ii.PutRemoved(idsUint32)
+	if err != nil {
+		return fmt.Errorf("inverted index delete: %w", err)
+	}
+	err = i.DuckDB.markWipedSegmentsRemoved(segmentIds)
+	if err != nil {
+		return fmt.Errorf("db mark wiped segments: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,135 @@
+package persistence
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/lezhnev74/inverted_index_2"
+	"github.com/stretchr/testify/require"
+
+	"heaplog_2024/internal"
+	"heaplog_2024/internal/common"
+)
+
+func TestIndexRecover(t *testing.T) {
+	ctx := context.Background()
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	duck, err := NewDuckDB(ctx, "", logger)
+	require.NoError(t, err)
+	ii, err := inverted_index_2.NewInvertedIndex(t.TempDir(), false)
+	require.NoError(t, err)
+	index, err := NewPersistentIndex(duck, ii)
+	require.NoError(t, err)
+
+	terms := [][]byte{[]byte("error")}
+	segment := func(from, to int) (common.Segment, []common.Message) {
+		return common.Segment{Location: common.Location{From: from, To: to}}, []common.Message{
+			{
+				MessageLayout: common.MessageLayout{Loc: common.Location{From: from, To: to}},
+				Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+			},
+		}
+	}
+
+	s, m := segment(0, 10)
+	committedId, err := index.PutSegment("path1", s, terms, m)
+	require.NoError(t, err)
+
+	// crash after the terms were put, but before the segment was committed
+	s, m = segment(10, 20)
+	pendingId, err := duck.PutPendingSegment("path1", s, m)
+	require.NoError(t, err)
+	require.NoError(t, ii.Put(terms, uint32(pendingId)))
+
+	// crash after the segment was wiped from the db, but before it was removed from the inverted index
+	s, m = segment(0, 10)
+	_, err = index.PutSegment("path2", s, terms, m)
+	require.NoError(t, err)
+	_, err = duck.WipeSegment("path2", s.Location)
+	require.NoError(t, err)
+
+	// the pending segment is not visible
+	segments, err := index.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]common.Location{"path1": {{From: 0, To: 10}}}, segments)
+	messages, err := index.GetMessages(ctx, []int{committedId, pendingId}, nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, slices.Collect(messages), 1)
+
+	pending, wiped, err := index.Recover()
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.Equal(t, 1, wiped)
+
+	ids, err := duck.pendingSegments()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	ids, err = duck.wipedSegments()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	segments, err = index.GetSegments()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]common.Location{"path1": {{From: 0, To: 10}}}, segments)
+
+	// nothing left to recover
+	pending, wiped, err = index.Recover()
+	require.NoError(t, err)
+	require.Zero(t, pending)
+	require.Zero(t, wiped)
+}
+
+func TestIndexPruneWiped(t *testing.T) {
+	ctx := context.Background()
+	logger, err := internal.NewLogger("test")
+	require.NoError(t, err)
+	duck, err := NewDuckDB(ctx, "", logger)
+	require.NoError(t, err)
+	ii, err := inverted_index_2.NewInvertedIndex(t.TempDir(), false)
+	require.NoError(t, err)
+	index, err := NewPersistentIndex(duck, ii)
+	require.NoError(t, err)
+
+	messages := []common.Message{
+		{
+			MessageLayout: common.MessageLayout{Loc: common.Location{From: 0, To: 10}},
+			Date:          common.MakeTimeV("2024-01-01T00:00:00.000000+00:00"),
+		},
+	}
+	segment := common.Segment{Location: common.Location{From: 0, To: 10}}
+	_, err = index.PutSegment("path1", segment, [][]byte{[]byte("error")}, messages)
+	require.NoError(t, err)
+	_, err = index.PutSegment("path2", segment, [][]byte{[]byte("error")}, messages)
+	require.NoError(t, err)
+	require.NoError(t, index.WipeSegment("path1", segment.Location))
+	journal := func() []int {
+		ids, err := duck.selectIds("SELECT id FROM wiped_segments ORDER BY id")
+		require.NoError(t, err)
+		return ids
+	}
+	require.Len(t, journal(), 1)
+
+	// the inverted index keeps terms of the wiped segment until merging
+	pruned, err := index.PruneWiped(ctx)
+	require.NoError(t, err)
+	require.Zero(t, pruned)
+	require.Len(t, journal(), 1)
+
+	for {
+		merged, err := ii.Merge(2, 2, 1)
+		require.NoError(t, err)
+		if merged == 0 {
+			break
+		}
+	}
+	pruned, err = index.PruneWiped(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	require.Empty(t, journal())
+
+	problems, err := index.Check(ctx)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS source STRING DEFAULT 'default'; -- name of the configured source
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS sources STRING DEFAULT ''; -- comma-separated source names, empty = all
@@ -0,0 +1,4 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS inode UBIGINT DEFAULT 0;     -- zero if unknown
+ALTER TABLE files ADD COLUMN IF NOT EXISTS device UBIGINT DEFAULT 0;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS head_len UINTEGER DEFAULT 0; -- how many bytes of the file are hashed
+ALTER TABLE files ADD COLUMN IF NOT EXISTS head_hash BIGINT DEFAULT 0;   -- bits of the uint64 hash (database/sql rejects uint64 with the high bit)
@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS fields MAP(VARCHAR, VARCHAR);      -- named capture groups, NULL if none
+ALTER TABLE query_results ADD COLUMN IF NOT EXISTS fields MAP(VARCHAR, VARCHAR); -- copied from messages
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS fields STRING DEFAULT '';           -- JSON-encoded fields filter, empty = none
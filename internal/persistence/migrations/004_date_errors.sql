@@ -0,0 +1,6 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS date_errors UINTEGER DEFAULT 0;     -- messages whose dates could not be parsed
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS date_error_sample STRING DEFAULT ''; -- the date of the first failed message
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS date_error STRING DEFAULT '';        -- the parse error of the first failed message
+ALTER TABLE files ADD COLUMN IF NOT EXISTS blacklisted BOOL DEFAULT false;         -- indexing stopped at a date parse failure
+ALTER TABLE files ADD COLUMN IF NOT EXISTS blacklist_sample STRING DEFAULT '';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS blacklist_error STRING DEFAULT '';
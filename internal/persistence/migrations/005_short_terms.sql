@@ -0,0 +1 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS short_terms BLOB; -- Bloom filter of terms too short for the inverted index, NULL if not built
@@ -0,0 +1,5 @@
+ALTER TABLE segments ADD COLUMN IF NOT EXISTS committed BOOL DEFAULT true; -- false until the terms of the segment are in the inverted index
+CREATE TABLE IF NOT EXISTS wiped_segments                                 -- removed from the db, not yet from the inverted index
+(
+    id UINTEGER NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE wiped_segments ADD COLUMN IF NOT EXISTS removed BOOL DEFAULT false; -- the inverted index removed the segment (it may keep its terms until merging)
@@ -0,0 +1 @@
+ALTER TABLE files ADD COLUMN IF NOT EXISTS seek_index BLOB DEFAULT NULL; -- gob of common.SeekIndex of a compressed file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS seek_windows -- windows of checkpoints of seek indexes, loaded when decompression resumes at them
+(
+    file_id    UINTEGER NOT NULL,
+    checkpoint UINTEGER NOT NULL, -- the number of the checkpoint in the seek index
+    output     BLOB     NOT NULL  -- the last 32KB of output before the checkpoint
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS index_options -- how messages of a source were turned into terms
+(
+    source  STRING UNIQUE NOT NULL,
+    options STRING        NOT NULL -- files of the source are indexed again when it changes
+);
@@ -9,14 +9,14 @@ import (
 type Appender struct {
 	a        *duckdb.Appender
 	appendCh chan []driver.Value
-	flushCh  chan struct{}
+	flushCh  chan chan error
 }
 
 func NewAppender(duckAppender *duckdb.Appender) *Appender {
 	a := Appender{
 		a:        duckAppender,
 		appendCh: make(chan []driver.Value),
-		flushCh:  make(chan struct{}),
+		flushCh:  make(chan chan error),
 	}
 	go func() {
 		for {
@@ -29,11 +29,8 @@ func NewAppender(duckAppender *duckdb.Appender) *Appender {
 				if err != nil {
 					panic(err)
 				}
-			case <-a.flushCh:
-				err := a.a.Flush()
-				if err != nil {
-					panic(err)
-				}
+			case done := <-a.flushCh:
+				done <- a.a.Flush()
 			}
 		}
 	}()
@@ -44,9 +41,12 @@ func (a *Appender) AppendRow(args ...driver.Value) error {
 	a.appendCh <- args
 	return nil
 }
+
+// Flush blocks until appended rows are flushed to the database
 func (a *Appender) Flush() error {
-	a.flushCh <- struct{}{}
-	return nil
+	done := make(chan error)
+	a.flushCh <- done
+	return <-done
 }
 
 func (a *Appender) Close() error {
@@ -113,36 +113,43 @@ func setAnd(sets [][]int) (r []int) {
 // exprMapLiteralsToSets transforms string literals and regular expressions in the query expression
 // into segment sets that can be used for evaluation. For string literals, it tokenizes the input
 // and maps each token to its corresponding segment set from the inverted index (termValues),
-// combining them with AND operations. If a string literal produces no tokens, it maps to
-// allSegmentsSuperset indicating a full scan is required. Regular expressions are also mapped
-// to allSegmentsSuperset as they require full scanning of segments.
+// combining them with AND operations. Words too short for the index (shortTerms, nil if segments have no filters)
+// are mapped to segments whose filters may have them (shortTermValues) and combined as well.
+// If a string literal produces no tokens, it maps to allSegmentsSuperset indicating a full scan is required.
+// Regular expressions are also mapped to allSegmentsSuperset as they require full scanning of segments.
 func exprMapLiteralsToSets(
 	expr *query_language.Expression,
 	tokenize func([]byte) [][]byte,
 	termValues map[string][]int,
+	shortTerms func([]byte) [][]byte,
+	shortTermValues map[string][]int,
 ) (exprClone *query_language.Expression) {
+	literalToSets := func(literal string) any {
+		terms := tokenize([]byte(literal))
+		var short [][]byte
+		if shortTerms != nil {
+			short = shortTerms([]byte(literal))
+		}
+		if len(terms) == 0 && len(short) == 0 {
+			return allSegmentsSuperset // no indexed terms => Full-Scan
+		}
+		// otherwise, AND-combine results from II and filters
+		sets := make([]any, 0, len(terms)+len(short))
+		sets = appendTermSets(sets, terms, termValues)
+		sets = appendTermSets(sets, short, shortTermValues)
+		return &query_language.Expression{Operator: query_language.AND, Operands: sets}
+	}
+
 	exprClone = expr.Clone()
 	exprClone.Visit(
 		func(expr *query_language.Expression) {
 			for i, operand := range expr.Operands {
 				switch tl := operand.(type) {
 				case string:
-					// normal term, if short -> Full-Scan
-					terms := tokenize([]byte(tl))
-					if len(terms) == 0 {
-						expr.Operands[i] = allSegmentsSuperset // no long prefix-terms => Full-Scan
-						continue
-					}
-					// otherwise, AND-combine results from II
-					sets := make([]any, 0, len(terms))
-					for _, term := range terms {
-						termSet, ok := termValues[string(term)]
-						if !ok {
-							continue
-						}
-						sets = append(sets, termSet)
-					}
-					expr.Operands[i] = &query_language.Expression{Operator: query_language.AND, Operands: sets}
+					expr.Operands[i] = literalToSets(tl)
+				case query_language.FieldLiteral:
+					// values of fields are indexed as plain text
+					expr.Operands[i] = literalToSets(tl.Value)
 				case query_language.RegExpLiteral:
 					expr.Operands[i] = allSegmentsSuperset // Full-Scan
 				case query_language.RegExpLiteralCs:
@@ -153,3 +160,15 @@ func exprMapLiteralsToSets(
 	)
 	return
 }
+
+// appendTermSets appends segments of the terms found in termValues
+func appendTermSets(sets []any, terms [][]byte, termValues map[string][]int) []any {
+	for _, term := range terms {
+		termSet, ok := termValues[string(term)]
+		if !ok {
+			continue
+		}
+		sets = append(sets, termSet)
+	}
+	return sets
+}
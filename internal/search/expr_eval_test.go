@@ -149,6 +149,22 @@ func TestExprEval(t *testing.T) {
 			},
 			expectedSegments: []int{allSegmentsMarker},
 		},
+		{ // Test field literal matches segments of the value
+			query: `level:"error"`,
+			termSegments: map[string][]int{
+				"level": {1, 4},
+				"error": {2, 3, 4},
+			},
+			expectedSegments: []int{2, 3, 4},
+		},
+		{ // Test unquoted words with a colon are text
+			query: "level:error",
+			termSegments: map[string][]int{
+				"level": {1, 4},
+				"error": {2, 3, 4},
+			},
+			expectedSegments: []int{4},
+		},
 		{ // Test complex expression with negation, regex, OR and AND
 			query: "!(~error OR error) AND failure",
 			termSegments: map[string][]int{
@@ -164,7 +180,7 @@ func TestExprEval(t *testing.T) {
 			fmt.Sprintf("Test %d", i), func(t *testing.T) {
 				expr, err := query_language.ParseUserQuery(tt.query)
 				require.NoError(t, err)
-				mappedExpr := exprMapLiteralsToSets(expr, tokenize, tt.termSegments)
+				mappedExpr := exprMapLiteralsToSets(expr, tokenize, tt.termSegments, nil, nil)
 				log.Printf("%s", mappedExpr.String())
 				segments := exprEval(mappedExpr)
 				require.Equal(t, tt.expectedSegments, segments)
@@ -176,3 +192,36 @@ func TestExprEval(t *testing.T) {
 		)
 	}
 }
+
+func TestExprEvalShortTerms(t *testing.T) {
+	tokenizer, err := common.NewTokenizer(common.TokenizerOptions{Strategy: common.TermsPrefix, MinSize: 4, MaxSize: 8})
+	require.NoError(t, err)
+
+	termSegments := map[string][]int{"fail": {1, 2, 3, 4}}
+	shortTermSegments := map[string][]int{"job": {2, 3, 5}, "42": {3, 5}}
+
+	tests := map[string][]int{
+		"job 42 fail":  {3},                 // short words prune segments of the term
+		`"job 42"`:     {3, 5},              // only short words
+		"job OR ~fail": {allSegmentsMarker}, // regular expression
+		"job !42":      {2, 3, 5},           // negation does not prune
+		"job OR fail":  {1, 2, 3, 4, 5},     // union of the filter and the index
+		"ab":           nil,                 // no segment has the word
+	}
+	for query, expected := range tests {
+		t.Run(
+			query, func(t *testing.T) {
+				expr, err := query_language.ParseUserQuery(query)
+				require.NoError(t, err)
+				mappedExpr := exprMapLiteralsToSets(
+					expr,
+					tokenizer.Query,
+					termSegments,
+					tokenizer.ShortQuery,
+					shortTermSegments,
+				)
+				require.Equal(t, expected, exprEval(mappedExpr))
+			},
+		)
+	}
+}
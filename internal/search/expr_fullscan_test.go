@@ -49,3 +49,30 @@ func TestFullScanDetection(t *testing.T) {
 		)
 	}
 }
+
+func TestFullScanDetectionEntities(t *testing.T) {
+	tokenizer, err := common.NewTokenizer(
+		common.TokenizerOptions{Strategy: common.TermsPrefix, Entities: true, MinSize: 4, MaxSize: 8},
+	)
+	require.NoError(t, err)
+
+	tests := map[string]bool{
+		"502":                false, // numeric code
+		"10.0.12.7":          false, // IP
+		"/api/v1":            false, // path
+		"status:502":         false,
+		"12":                 true, // too short for a code
+		"10.0.12":            true, // not an IP
+		"!502":               true,
+		"6f85c55a-2b1e-4c7d": false, // the beginning of a UUID is a valid term
+	}
+	for query, isFullScan := range tests {
+		t.Run(
+			query, func(t *testing.T) {
+				expr, err := query_language.ParseUserQuery(query)
+				require.NoError(t, err)
+				require.Equal(t, isFullScan, shouldFullScan(expr, tokenizer.Query))
+			},
+		)
+	}
+}
@@ -22,6 +22,9 @@ type MatchFunc func(*CachedString) bool
 type CachedString struct {
 	origin string
 	low    string
+	// values of fields of a JSON message
+	fields map[string][]string
+	isJSON bool
 }
 
 func (c *CachedString) toLower() string {
@@ -32,6 +35,21 @@ func (c *CachedString) toLower() string {
 	return c.low
 }
 
+// jsonFields returns lowercased values of fields if the string is a JSON object (parsed on demand and cached)
+func (c *CachedString) jsonFields() (map[string][]string, bool) {
+	if c.fields == nil {
+		c.fields = make(map[string][]string)
+		input := []byte(c.origin)
+		c.isJSON = common.IsJSONObject(input) && common.ScanJSONFields(
+			input, func(f common.JSONField) bool {
+				c.fields[f.Path] = append(c.fields[f.Path], strings.ToLower(f.Value(input)))
+				return true
+			},
+		)
+	}
+	return c.fields, c.isJSON
+}
+
 func NewCachedString(s string) *CachedString {
 	if len(s) == 0 {
 		panic("empty string")
@@ -160,14 +178,17 @@ func (qe *Expression) Visit(visit func(*Expression)) {
 	visit(qe)
 }
 
-// FindKeywords returns all leaf strings (= literals), except RE
+// FindKeywords returns all leaf strings (= literals), except RE. Field literals give their values.
 func (qe *Expression) FindKeywords() []string {
 	ret := make([]string, 0)
 	qe.Visit(
 		func(expr *Expression) {
 			for _, operand := range expr.Operands {
-				if literal, ok := operand.(string); ok {
+				switch literal := operand.(type) {
+				case string:
 					ret = append(ret, literal)
+				case FieldLiteral:
+					ret = append(ret, literal.Value)
 				}
 			}
 		},
@@ -204,6 +225,8 @@ func (qe *Expression) Hash() string {
 					return
 				} else if _, ok := operand.(RegExpLiteral); ok {
 					qeString += "~" // regexp literal must not be equal to a normal literal
+				} else if _, ok := operand.(FieldLiteral); ok {
+					qeString += "=" // field literal must not be equal to a normal literal
 				}
 				qeString += fmt.Sprintf("%v", operand) // assume all literals are strings
 			}
@@ -268,6 +291,20 @@ func (qe *Expression) GetMatcher() MatchFunc {
 						o,
 					) // case-insensitive matching is expensive, but greatly improves UX...
 				}
+			case FieldLiteral:
+				field, value := o.Field, strings.ToLower(o.Value)
+				operandFunc = func(s *CachedString) bool {
+					fields, ok := s.jsonFields()
+					if !ok {
+						return false // not a structured message
+					}
+					for _, v := range fields[field] {
+						if strings.Contains(v, value) {
+							return true
+						}
+					}
+					return false
+				}
 			case RegExpLiteralCs:
 				p := regexp.MustCompile(string(o)) // RE match
 				operandFunc = func(s *CachedString) bool { return p.MatchString(s.origin) }
@@ -327,6 +364,8 @@ func (qe *Expression) String() string {
 			sOps = append(sOps, op)
 		case RegExpLiteral:
 			sOps = append(sOps, fmt.Sprintf("~%s", op))
+		case FieldLiteral:
+			sOps = append(sOps, op.String())
 		case *Expression:
 			sOps = append(sOps, op.String())
 		default:
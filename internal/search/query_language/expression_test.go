@@ -128,6 +128,41 @@ BING ADS response (recorded):
 	}
 }
 
+func TestMatchFields(t *testing.T) {
+	jsonMessage := `{"time":"","level":"ERROR","ctx":{"user_id":42,"tags":["billing","retry"]},"msg":"card declined","url":"https://api.host/v1"}`
+	textMessage := `[2023-01-05 23:46:22] level:error card declined at https://api.host/v1`
+
+	type test struct {
+		query                      string
+		expectedJSON, expectedText bool
+	}
+	tests := []test{
+		{`level:"error"`, true, false},
+		{`level:'err'`, true, false},
+		{`level:"warning"`, false, false},
+		{`msg:"declined"`, true, false},
+		{`msg:"card declined"`, true, false},
+		{`ctx.user_id:"42"`, true, false},
+		{`ctx.tags:"retry"`, true, false},
+		{`user_id:"42"`, false, false}, // the path is complete
+		{`msg:"error"`, false, false},  // the value is in another field
+		{`declined !level:"info"`, true, true},
+		{"level:error", false, true}, // unquoted, the literal is text
+		{"https://api.host", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.query, func(t *testing.T) {
+				expr, err := ParseUserQuery(tt.query)
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedJSON, expr.GetMatcher()(NewCachedString(jsonMessage)))
+				require.Equal(t, tt.expectedText, expr.GetMatcher()(NewCachedString(textMessage)))
+			},
+		)
+	}
+}
+
 func TestFindKeywords(t *testing.T) {
 	t.Run(
 		"sort expr", func(t *testing.T) {
@@ -2,6 +2,7 @@ package query_language
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -18,6 +19,57 @@ type RegExpLiteral string
 // RegExpLiteralCs is a string that contains case-sensitive regular expression as given from the user
 type RegExpLiteralCs string
 
+// FieldLiteral matches the value of a field in structured (JSON) messages, written as field:"value" or field:'value'.
+// Unquoted words with a colon (e.g. "https://host", "error:timeout") are plain text.
+type FieldLiteral struct {
+	Field string
+	Value string
+}
+
+func (f FieldLiteral) String() string { return f.Field + `:"` + f.Value + `"` }
+
+// fieldLiteralRe recognizes field literals, the field is a dot-separated JSON path and the value is quoted
+var fieldLiteralRe = regexp.MustCompile(`^([A-Za-z_@$][\w@$-]*(?:\.[\w@$-]+)*):(?:"([^"]+)"|'([^']+)')$`)
+
+// openFieldLiteralRe recognizes the first word of a field literal whose quoted value has spaces
+var openFieldLiteralRe = regexp.MustCompile(`^[A-Za-z_@$][\w@$-]*(?:\.[\w@$-]+)*:("[^"]*|'[^']*)$`)
+
+// fieldLexer joins words of a field literal whose value has spaces (msg:"card declined") into one literal,
+// unquoted literals of the grammar end at spaces.
+type fieldLexer struct {
+	*query_antlr.QueryLanguageLexer
+	input antlr.CharStream
+	ahead []antlr.Token // tokens read ahead that turned out not to close a field literal
+}
+
+func (l *fieldLexer) NextToken() antlr.Token {
+	if len(l.ahead) > 0 {
+		t := l.ahead[0]
+		l.ahead = l.ahead[1:]
+		return t
+	}
+	t := l.QueryLanguageLexer.NextToken()
+	m := openFieldLiteralRe.FindStringSubmatch(t.GetText())
+	if t.GetTokenType() != query_antlr.QueryLanguageLexerLITERAL || m == nil {
+		return t
+	}
+	quote := m[1][:1]
+	for {
+		next := l.QueryLanguageLexer.NextToken()
+		l.ahead = append(l.ahead, next)
+		if next.GetTokenType() == antlr.TokenEOF {
+			return t // the value is not closed
+		}
+		if i := strings.Index(next.GetText(), quote); i >= 0 {
+			if i == len(next.GetText())-1 {
+				t.SetText(l.input.GetText(t.GetStart(), next.GetStop()))
+				l.ahead = nil
+			}
+			return t
+		}
+	}
+}
+
 type AntlrListener struct {
 	query_antlr.BaseQueryLanguageListener
 	qe *Expression
@@ -51,9 +103,12 @@ func (s *AntlrListener) handle(node any) (ret any) {
 	case *query_antlr.ExprLiteralContext:
 		literal := c.GetText() // final LITERAL
 		if len(literal) > 1 && literal[0] == literal[len(literal)-1] && strings.ContainsAny(literal[0:1], `"'`) {
-			literal = strings.Trim(literal, string(literal[0])) // remove quotes if any
+			ret = strings.Trim(literal, string(literal[0])) // remove quotes if any, quoted literals are plain text
+		} else if m := fieldLiteralRe.FindStringSubmatch(literal); m != nil {
+			ret = FieldLiteral{Field: m[1], Value: m[2] + m[3]}
+		} else {
+			ret = literal
 		}
-		ret = literal
 	case *query_antlr.ExprAndContext:
 		ret = &Expression{
 			Operator: AND,
@@ -126,7 +181,7 @@ func ParseUserQuery(query string) (*Expression, error) {
 	listener := &AntlrListener{}
 
 	input := antlr.NewInputStream(query)
-	lexer := query_antlr.NewQueryLanguageLexer(input)
+	lexer := &fieldLexer{QueryLanguageLexer: query_antlr.NewQueryLanguageLexer(input), input: input}
 	stream := antlr.NewCommonTokenStream(lexer, 0)
 
 	errorListener := new(AntlrErrorListener)
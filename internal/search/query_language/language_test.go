@@ -32,6 +32,18 @@ func TestAntlrQueryParser(t *testing.T) {
 		{"A OR (B AND C)", &Expression{OR, []any{"A", &Expression{AND, []any{"B", "C"}}}}, nil},
 		{"A B OR C", &Expression{AND, []any{"A", &Expression{OR, []any{"B", "C"}}}}, nil},
 		{"A !B !C", &Expression{AND, []any{"A", &Expression{NOT, []any{"B"}}, &Expression{NOT, []any{"C"}}}}, nil},
+		// fields:
+		{`level:"error"`, &Expression{AND, []any{FieldLiteral{"level", "error"}}}, nil},
+		{`ctx.user_id:'42' A`, &Expression{AND, []any{FieldLiteral{"ctx.user_id", "42"}, "A"}}, nil},
+		{`msg:"card (declined) OR not" A`, &Expression{AND, []any{FieldLiteral{"msg", "card (declined) OR not"}, "A"}}, nil},
+		{`!msg:'card declined'`, &Expression{NOT, []any{FieldLiteral{"msg", "card declined"}}}, nil},
+		{`"level:error"`, &Expression{AND, []any{"level:error"}}, nil},
+		// words with a colon are text:
+		{"level:error", &Expression{AND, []any{"level:error"}}, nil},
+		{"https://api.host/v1", &Expression{AND, []any{"https://api.host/v1"}}, nil},
+		{"error:timeout OR IP: 10.0.0.1", &Expression{AND, []any{&Expression{OR, []any{"error:timeout", "IP:"}}, "10.0.0.1"}}, nil},
+		{`msg:"card declined`, &Expression{AND, []any{`msg:"card`, "declined"}}, nil}, // not closed
+		{`url:"http://a b":c`, &Expression{AND, []any{`url:"http://a`, `b":c`}}, nil},
 		// RE:
 		{"~a", &Expression{AND, []any{RegExpLiteral("a")}}, nil},
 		{`@a`, &Expression{AND, []any{RegExpLiteralCs("a")}}, nil},
@@ -18,31 +18,99 @@ import (
 type ReadableIndex interface {
 	// GetRelevantSegments uses Inverted Index to get potential segments
 	GetRelevantSegments(ctx context.Context, terms [][]byte) (map[string][]int, error)
-	// GetMessages streams all messages within given segments
-	GetMessages(ctx context.Context, segments []int, minDate, maxDate *time.Time) (iter.Seq[common.FileMessage], error)
+	// GetSegmentsByShortTerms uses filters of segments to get potential segments for terms too short for the index,
+	// only candidate segments (all if empty) of files that belong to given sources and overlap the dates are considered
+	GetSegmentsByShortTerms(
+		ctx context.Context,
+		terms [][]byte,
+		candidates []int,
+		sources []string,
+		minDate, maxDate *time.Time,
+	) (map[string][]int, error)
+	// GetMessages streams all messages within given segments of files that belong to given sources
+	// and whose fields match the filter
+	GetMessages(
+		ctx context.Context,
+		segments []int,
+		sources []string,
+		fields common.FieldsFilter,
+		minDate, maxDate *time.Time,
+	) (iter.Seq[common.FileMessage], error)
 }
 
 type Search struct {
 	ctx      context.Context
 	tokenize func([]byte) [][]byte
+	// words of literals that are too short for the index, they are looked up in filters of segments (nil = no filters)
+	shortTerms func([]byte) [][]byte
+	// decoders of sources whose files keep messages encoded (e.g. Docker's json-file logs)
+	decoders map[string]func([]byte) []byte
+	opener   *common.Opener
 	index    ReadableIndex
 	logger   *zap.Logger
 }
 
-func NewSearch(ctx context.Context, tokenize func([]byte) [][]byte, index ReadableIndex, logger *zap.Logger) *Search {
+func NewSearch(
+	ctx context.Context,
+	tokenize func([]byte) [][]byte,
+	shortTerms func([]byte) [][]byte,
+	decoders map[string]func([]byte) []byte,
+	opener *common.Opener,
+	index ReadableIndex,
+	logger *zap.Logger,
+) *Search {
 	return &Search{
-		ctx:      ctx,
-		tokenize: tokenize,
-		index:    index,
-		logger:   logger,
+		ctx:        ctx,
+		tokenize:   tokenize,
+		shortTerms: shortTerms,
+		decoders:   decoders,
+		opener:     opener,
+		index:      index,
+		logger:     logger,
 	}
 }
 
+// Decode returns the body of the message as users see it, encoded messages are decoded by their source.
+func (s *Search) Decode(m common.FileMessageBody) []byte {
+	if decode, ok := s.decoders[m.Source]; ok {
+		return decode(m.Body)
+	}
+	return m.Body
+}
+
+// indexedTerms returns all terms of the literal that tell which segments have it
+func (s *Search) indexedTerms(literal []byte) [][]byte {
+	terms := s.tokenize(literal)
+	if s.shortTerms != nil {
+		terms = append(terms, s.shortTerms(literal)...)
+	}
+	return terms
+}
+
+// getSegments looks up unique terms with the given lookup
+func (s *Search) getSegments(
+	terms [][]byte,
+	lookup func(ctx context.Context, terms [][]byte) (map[string][]int, error),
+) (map[string][]int, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(terms, bytes.Compare)
+	terms = slices.CompactFunc(terms, bytes.Equal)
+	return lookup(s.ctx, terms)
+}
+
 // Search is the main gateway to the message-matching functionality.
 // Given the user query expression, it decides if the inverted index can be used
 // to reduce the amount of messages to test.
-// It streams out matched messages.
-func (s *Search) Search(expr *query_language.Expression, minDate, maxDate *time.Time) (
+// It streams out matched messages of files that belong to given sources (all if empty)
+// and whose fields match the filter (no filtering if empty).
+func (s *Search) Search(
+	expr *query_language.Expression,
+	sources []string,
+	fields common.FieldsFilter,
+	minDate, maxDate *time.Time,
+) (
 	iter.Seq[common.FileMessageBody],
 	error,
 ) {
@@ -52,26 +120,50 @@ func (s *Search) Search(expr *query_language.Expression, minDate, maxDate *time.
 		pos := func(pos int) int { return pos - m.Loc.From }
 		body := append([]byte{}, m.Body[:pos(m.DateLoc.From)]...)
 		body = append(body, m.Body[pos(m.DateLoc.To):]...)
+		if decode, ok := s.decoders[m.Source]; ok {
+			body = decode(body)
+		}
+		if len(body) == 0 {
+			return false
+		}
 		bodyString := unsafe.String(unsafe.SliceData(body), len(body))
 		result := exprMatcher(query_language.NewCachedString(bodyString))
 		return result
 	}
 
 	segments := []int(nil) // segments to look into for messages (nil = All)
-	if !shouldFullScan(expr, s.tokenize) {
-		terms := make([][]byte, 0)
+	if !shouldFullScan(expr, s.indexedTerms) {
+		var terms, shortTerms [][]byte
 		for _, t := range expr.FindKeywords() {
 			terms = append(terms, s.tokenize([]byte(t))...)
+			if s.shortTerms != nil {
+				shortTerms = append(shortTerms, s.shortTerms([]byte(t))...)
+			}
 		}
-		slices.SortFunc(terms, bytes.Compare)
-		terms = slices.CompactFunc(terms, bytes.Equal)
 
-		termSegments, err := s.index.GetRelevantSegments(s.ctx, terms)
+		termSegments, err := s.getSegments(terms, s.index.GetRelevantSegments)
 		if err != nil {
 			return nil, fmt.Errorf("get segments by terms: %w", err)
 		}
 
-		setsExpr := exprMapLiteralsToSets(expr, s.tokenize, termSegments)
+		// segments that the index alone leaves possible, only their filters are worth reading
+		candidates := exprEval(exprMapLiteralsToSets(expr, s.tokenize, termSegments, nil, nil))
+		if slices.Equal(candidates, allSegmentsSuperset) {
+			candidates = nil
+		} else if len(candidates) == 0 {
+			s.logger.Debug("No relevant segments found for the query", zap.String("query", expr.String()))
+			return common.Empty[common.FileMessageBody](), nil
+		}
+		shortTermSegments, err := s.getSegments(
+			shortTerms, func(ctx context.Context, terms [][]byte) (map[string][]int, error) {
+				return s.index.GetSegmentsByShortTerms(ctx, terms, candidates, sources, minDate, maxDate)
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("get segments by short terms: %w", err)
+		}
+
+		setsExpr := exprMapLiteralsToSets(expr, s.tokenize, termSegments, s.shortTerms, shortTermSegments)
 		segments = exprEval(setsExpr)
 		if slices.Equal(segments, allSegmentsSuperset) {
 			segments = nil // full-scan
@@ -85,7 +177,7 @@ func (s *Search) Search(expr *query_language.Expression, minDate, maxDate *time.
 		s.logger.Debug("Selected segments\n", zap.Int("len", len(segments)), zap.String("query", expr.String()))
 	}
 
-	fileMessages, err := s.index.GetMessages(s.ctx, segments, minDate, maxDate)
+	fileMessages, err := s.index.GetMessages(s.ctx, segments, sources, fields, minDate, maxDate)
 	if err != nil {
 		return nil, fmt.Errorf("get messages: %w", err)
 	}
@@ -100,7 +192,7 @@ func (s *Search) Search(expr *query_language.Expression, minDate, maxDate *time.
 		// close the pool (when exhausted)
 		defer close(needsMatching)
 		// provide tasks for the pool
-		for m, err := range common.ReadMessages(s.ctx, messageBuf, fileMessages) {
+		for m, err := range common.ReadMessages(s.ctx, s.opener, messageBuf, fileMessages) {
 			if err != nil {
 				break
 			}
@@ -115,6 +207,7 @@ func (s *Search) Search(expr *query_language.Expression, minDate, maxDate *time.
 	}()
 	return func(yield func(body common.FileMessageBody) bool) {
 		for matched := range matchedMessages {
+			matched.Body = s.Decode(matched)
 			if !yield(matched) {
 				break
 			}
@@ -3,41 +3,376 @@ package ui
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
+
+	"heaplog_2024/internal/ingest"
 )
 
 var errNoConfigFile = fmt.Errorf("no config file loaded")
 
+// defaultSourceName is the name of the source made of top-level config values (when "sources" are not set)
+const defaultSourceName = "default"
+
+// heapsDir is the directory in the storage where streamed logs are written (one directory per heap)
+const heapsDir = "heaps"
+
+// Heaps of received messages, each makes up a source of the same name in a built-in format
+const (
+	syslogHeap = "syslog"
+	otlpHeap   = "otlp"
+)
+
+var builtInHeaps = []string{syslogHeap, otlpHeap}
+
+// heapNameRE restricts heap names, as they name directories and files
+var heapNameRE = regexp.MustCompile(`^\w[\w.-]*$`)
+
 type Config struct {
 	// where to look for log files? example: "./*.log"
-	FilesGlobPattern string `validate:"required" yaml:"files_glob_pattern"`
+	FilesGlobPattern string `validate:"required_without=Sources" yaml:"files_glob_pattern"`
+	// files to skip even if they match the glob pattern, example: ["*.tmp", "debug-*.log"]
+	Exclude []string `yaml:"exclude"`
+	// follow symlinked directories when matching recursive ("**") glob patterns
+	FollowSymlinks bool `yaml:"follow_symlinks"`
 	// where to store the index and other data (relative to cwd supported)
 	StoragePath string `validate:"path_exists" yaml:"storage_path"`
 	// a regular expression to find the start of messages in a heap file,
 	// it must contain the date pattern in the first matching group
 	// example: "^\[(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2})\]"
-	MessageStartRE string `validate:"required,regexp" yaml:"message_start_re"`
+	MessageStartRE string `validate:"omitempty,regexp" yaml:"message_start_re"`
 	// the pattern of a date in a message
 	// see https://go.dev/src/time/format.go
-	DateFormat string `validate:"required" yaml:"date_format"`
+	// built-in epoch layouts: "unix", "unix_ms", "unix_us", "unix_ns"
+	DateFormat string `validate:"required_without_all=Sources DateFormats" yaml:"date_format"`
+	// more date patterns tried in order after date_format (for files that mix formats)
+	DateFormats []string `validate:"dive,required" yaml:"date_formats"`
+	// how messages are laid out in files: "text" (found by message_start_re),
+	// "json" (one JSON object per line, the date is in date_field)
+	// or "docker" (Docker's json-file logs, messages are found by message_start_re in the decoded output)
+	Format string `validate:"omitempty,oneof=text json docker" yaml:"format"`
+	// dot-separated path to the date in JSON messages, example: "meta.time"
+	DateField string `yaml:"date_field"`
+	// index key names of JSON messages (only values are indexed by default)
+	IndexKeys bool `yaml:"index_keys"`
+	// dot-separated paths of JSON values extracted as fields of messages, example: ["level", "ctx.user_id"],
+	// "prefix.*" extracts all values under the prefix (they are named without it)
+	Fields []string `validate:"dive,required" yaml:"fields"`
+	// timezone of dates that have no offset in them (IANA name, example: "Europe/Berlin"), UTC if omitted
+	Timezone string `validate:"omitempty,timezone" yaml:"timezone"`
+	// what to do with a message whose date can't be parsed: "skip" the message,
+	// "inherit" the date of the previous message, or "blacklist" the file until it changes or restart
+	OnDateError string `validate:"oneof=skip inherit blacklist" yaml:"on_date_error"`
+	// Sources describe groups of log files in different formats.
+	// If set, top-level files_glob_pattern, exclude, follow_symlinks, message_start_re, date_format, date_formats,
+	// format, date_field, index_keys, fields and timezone are ignored.
+	Sources []SourceConfig `validate:"dive" yaml:"sources"`
+	// how to find messages in files: "native" (built-in, Go regexp syntax)
+	// or "ug" (requires ugrep installed, PCRE syntax)
+	Scanner string `validate:"oneof=native ug" yaml:"scanner"`
+	// how often to look for new data in all files (seconds)
+	IngestIntervalSec int `validate:"min=1" yaml:"ingest_interval_sec"`
+	// watch files for changes and ingest them as soon as they change
+	// (periodic ingestion still runs to catch missed changes)
+	Watch bool `yaml:"watch"`
+	// changes that happen within this window are ingested together (milliseconds)
+	WatchDebounceMs int `validate:"min=1" yaml:"watch_debounce_ms"`
 	// sets the degree of concurrency in the service (affects ingestion and search),
 	// defaults to the number of cores if omitted or <1.
 	Concurrency int `yaml:"concurrency"`
+	// how many segments of a file are tokenized in parallel, files are indexed in parallel by "concurrency".
+	// Up to twice as many segments of a source are kept in memory. Defaults to the number of cores if omitted or <1.
+	IndexConcurrency int `yaml:"index_concurrency"`
 	// Terms are extracted from messages and indexed.
 	// These control how fast ingestion goes (and space taken for the inverted index),
 	// as well as how fast search goes (as shorter terms may duplicate in the index).
 	MinTermLen int `yaml:"min_term_len"`
 	MaxTermLen int `yaml:"max_term_len"`
+	// which terms are indexed, it applies to all sources (rebuild the index after changing it)
+	Tokenizer TokenizerConfig `yaml:"tokenizer"`
+	// how many messages of a file are indexed together, the index points to segments of files
+	Segments SegmentsConfig `yaml:"segments"`
 	// Max memory the duckdb instance is allowed to allocate.
 	// Increase if you see related errors on big data sets. (default: 500)
 	DuckdbMaxMemMb int `yaml:"duckdb_max_mem_mb"`
+	// streamed logs are written to heap files in the storage, a new file starts after this size (default: 100)
+	HeapMaxFileMb int `validate:"min=1" yaml:"heap_max_file_mb"`
+	// receive syslog messages (RFC 3164 and RFC 5424) on these addresses, example: ":514".
+	// Messages are written to heap files per host and searched as the "syslog" source.
+	SyslogUDP string `yaml:"syslog_udp"`
+	SyslogTCP string `yaml:"syslog_tcp"`
+	// accept logs pushed over HTTP with the run command (POST /api/ingest/:source)
+	Push PushConfig `yaml:"push"`
+	// accept OpenTelemetry logs over HTTP with the run command (POST /v1/logs)
+	OTLP OTLPConfig `yaml:"otlp"`
+}
+
+// TokenizerConfig describes how messages are split into indexed terms
+type TokenizerConfig struct {
+	// "prefix": words are indexed by their first max_term_len letters, queries find words by their beginning;
+	// "suffix": suffixes of words are indexed too, so queries find any part of a word (the index is larger);
+	// "ngram": n-grams of words are indexed, queries find any part of a word that is at least ngram letters long
+	Strategy string `validate:"oneof=prefix suffix ngram" yaml:"strategy"`
+	// characters that separate words, whitespace and punctuation if empty
+	Separators string `yaml:"separators"`
+	// length of n-grams (default: 3)
+	Ngram int `validate:"min=2" yaml:"ngram"`
+	// index IPs, UUIDs, URLs (hosts and paths) and numeric codes like "502" as whole terms,
+	// so queries for them use the index (default: true)
+	Entities bool `yaml:"entities"`
+}
+
+// SegmentsConfig describes sizes of indexed segments.
+// Smaller segments make searches read and match fewer bytes, but the index is larger.
+type SegmentsConfig struct {
+	// target size of a segment in Kb, segments end at message boundaries (default: 5000)
+	SizeKb int `validate:"min=1" yaml:"size_kb"`
+	// max messages in a segment, 0 is unlimited (default: 0)
+	MaxMessages int `validate:"min=0" yaml:"max_messages"`
+	// size segments of every file by how fast it grows: files that grow slower than 1Kb/s get segments of max_size_kb,
+	// files that grow faster than 1Mb/s get segments of min_size_kb (size_kb until the growth is measured)
+	Adaptive  bool `yaml:"adaptive"`
+	MinSizeKb int  `validate:"min=1" yaml:"min_size_kb"`
+	MaxSizeKb int  `validate:"gtefield=MinSizeKb" yaml:"max_size_kb"`
+}
+
+// SegmentSize returns sizes of segments in bytes
+func (s SegmentsConfig) SegmentSize() ingest.SegmentSize {
+	return ingest.SegmentSize{
+		Bytes:    s.SizeKb * 1024,
+		Messages: s.MaxMessages,
+		Adaptive: s.Adaptive,
+		MinBytes: s.MinSizeKb * 1024,
+		MaxBytes: s.MaxSizeKb * 1024,
+	}
+}
+
+// PushConfig describes sources that accept pushed logs, lines are appended to heap files of the source
+type PushConfig struct {
+	// names of sources that accept pushed logs with the max size of their heap files (Mb, 0 is unlimited),
+	// the oldest files are removed beyond it
+	Sources map[string]int `validate:"dive,min=0" yaml:"sources"`
+	// pushed lines are buffered in memory up to this size, pushes are rejected with 429 beyond it (Mb, default: 16)
+	BufferMb int `validate:"min=1" yaml:"buffer_mb"`
+}
+
+// OTLPConfig describes the receiver of OpenTelemetry logs (OTLP/HTTP, protobuf or JSON).
+// Log records are buffered along with pushed logs and searched as the "otlp" source.
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// max size of heap files of received logs (Mb, 0 is unlimited), the oldest files are removed beyond it
+	MaxSizeMb int `validate:"min=0" yaml:"max_size_mb"`
+}
+
+// SourceConfig describes a group of log files that share the same format
+type SourceConfig struct {
+	// unique name of the source, searches can be narrowed down to certain sources
+	Name string `validate:"required" yaml:"name"`
+	// where to look for log files? "**" matches nested directories, example: ["./logs/**/*.log"]
+	FilesGlobPatterns []string `validate:"required,min=1,dive,required" yaml:"files_glob_patterns"`
+	// files to skip even if they match glob patterns, relative patterns match at any depth
+	Exclude []string `yaml:"exclude"`
+	// follow symlinked directories when matching recursive ("**") glob patterns
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+	// a regular expression to find the start of messages in a heap file,
+	// it must contain the date pattern in the first matching group
+	MessageStartRE string `validate:"omitempty,regexp" yaml:"message_start_re"`
+	// the pattern of a date in a message
+	DateFormat string `validate:"required_without=DateFormats" yaml:"date_format"`
+	// more date patterns tried in order after date_format
+	DateFormats []string `validate:"dive,required" yaml:"date_formats"`
+	// "text" (default), "json" or "docker"
+	Format string `validate:"omitempty,oneof=text json docker" yaml:"format"`
+	// dot-separated path to the date in JSON messages
+	DateField string `yaml:"date_field"`
+	// index key names of JSON messages
+	IndexKeys bool `yaml:"index_keys"`
+	// dot-separated paths of JSON values extracted as fields of messages
+	Fields []string `validate:"dive,required" yaml:"fields"`
+	// timezone of dates that have no offset in them, UTC if omitted
+	Timezone string `validate:"omitempty,timezone" yaml:"timezone"`
+	// what to do with a message whose date can't be parsed, the top-level value is used if omitted
+	OnDateError string `validate:"omitempty,oneof=skip inherit blacklist" yaml:"on_date_error"`
+	// indexed term lengths for this source, top-level values are used if omitted
+	MinTermLen int `yaml:"min_term_len"`
+	MaxTermLen int `yaml:"max_term_len"`
+}
+
+// GetDateFormats returns date patterns in the order they are tried
+func (s SourceConfig) GetDateFormats() []string {
+	var formats []string
+	if s.DateFormat != "" {
+		formats = append(formats, s.DateFormat)
+	}
+	return append(formats, s.DateFormats...)
+}
+
+// IsJSON tells if messages of the source are JSON objects (one per line)
+func (s SourceConfig) IsJSON() bool {
+	return s.Format == "json"
+}
+
+// IsDocker tells if files of the source are written by Docker's json-file logging driver
+func (s SourceConfig) IsDocker() bool {
+	return s.Format == "docker"
+}
+
+// GetSources returns configured sources with omitted values taken from the top-level config.
+// If no sources are configured, the top-level config makes up the single "default" source.
+// Heaps found in the storage are added to the source of the same name, or make up a source of top-level values.
+func (cfg Config) GetSources() []SourceConfig {
+	var sources []SourceConfig
+	if len(cfg.Sources) == 0 {
+		sources = append(sources, cfg.topLevelSource(defaultSourceName, []string{cfg.FilesGlobPattern}))
+	}
+
+	for _, s := range cfg.Sources {
+		if s.MinTermLen == 0 {
+			s.MinTermLen = cfg.MinTermLen
+		}
+		if s.MaxTermLen == 0 {
+			s.MaxTermLen = cfg.MaxTermLen
+		}
+		if s.OnDateError == "" {
+			s.OnDateError = cfg.OnDateError
+		}
+		sources = append(sources, s)
+	}
+
+	for _, heap := range cfg.GetHeaps() {
+		glob := filepath.Join(cfg.HeapDir(heap), "*.log")
+		if source, ok := cfg.builtInSource(heap, glob); ok {
+			sources = append(sources, source)
+			continue
+		}
+		i := slices.IndexFunc(sources, func(s SourceConfig) bool { return s.Name == heap })
+		if i < 0 {
+			sources = append(sources, cfg.topLevelSource(heap, []string{glob}))
+			continue
+		}
+		sources[i].FilesGlobPatterns = append(slices.Clone(sources[i].FilesGlobPatterns), glob)
+	}
+	return sources
+}
+
+// topLevelSource makes a source of top-level config values
+func (cfg Config) topLevelSource(name string, globs []string) SourceConfig {
+	return SourceConfig{
+		Name:              name,
+		FilesGlobPatterns: globs,
+		Exclude:           cfg.Exclude,
+		FollowSymlinks:    cfg.FollowSymlinks,
+		MessageStartRE:    cfg.MessageStartRE,
+		DateFormat:        cfg.DateFormat,
+		DateFormats:       cfg.DateFormats,
+		Format:            cfg.Format,
+		DateField:         cfg.DateField,
+		IndexKeys:         cfg.IndexKeys,
+		Fields:            cfg.Fields,
+		Timezone:          cfg.Timezone,
+		OnDateError:       cfg.OnDateError,
+		MinTermLen:        cfg.MinTermLen,
+		MaxTermLen:        cfg.MaxTermLen,
+	}
+}
+
+// builtInSource makes a source of received messages, they are written to the heap in a built-in format
+func (cfg Config) builtInSource(heap string, glob string) (SourceConfig, bool) {
+	source := SourceConfig{
+		Name:              heap,
+		FilesGlobPatterns: []string{glob},
+		OnDateError:       cfg.OnDateError,
+		MinTermLen:        cfg.MinTermLen,
+		MaxTermLen:        cfg.MaxTermLen,
+	}
+	switch heap {
+	case syslogHeap:
+		source.MessageStartRE, source.DateFormat = ingest.SyslogMessageStartRE, ingest.SyslogDateFormat
+	case otlpHeap:
+		source.Format, source.DateField, source.DateFormat = "json", ingest.OTLPDateField, ingest.OTLPDateFormat
+		source.Fields = ingest.OTLPFields
+	default:
+		return SourceConfig{}, false
+	}
+	return source, true
+}
+
+// HeapDir is where streamed logs of the heap are written
+func (cfg Config) HeapDir(name string) string {
+	return filepath.Join(cfg.StoragePath, heapsDir, name)
+}
+
+// PushCaps returns max sizes (in megabytes) of heaps that accept pushed logs (and received OpenTelemetry logs)
+func (cfg Config) PushCaps() map[string]int {
+	caps := maps.Clone(cfg.Push.Sources)
+	if cfg.OTLP.Enabled {
+		if caps == nil {
+			caps = make(map[string]int)
+		}
+		caps[otlpHeap] = cfg.OTLP.MaxSizeMb
+	}
+	return caps
+}
+
+// GetHeaps returns names of heaps found in the storage
+func (cfg Config) GetHeaps() []string {
+	entries, err := os.ReadDir(filepath.Join(cfg.StoragePath, heapsDir))
+	if err != nil {
+		return nil // no heaps yet
+	}
+	var heaps []string
+	for _, e := range entries {
+		if e.IsDir() && heapNameRE.MatchString(e.Name()) {
+			heaps = append(heaps, e.Name())
+		}
+	}
+	return heaps
+}
+
+// validateHeapName checks that the name can be used for a heap of streamed logs
+func validateHeapName(name string) error {
+	if !heapNameRE.MatchString(name) {
+		return fmt.Errorf("invalid heap name %q: use letters, digits, \"_\", \".\" and \"-\"", name)
+	}
+	if slices.Contains(builtInHeaps, name) {
+		return fmt.Errorf("heap name %q is reserved for received messages", name)
+	}
+	return nil
+}
+
+// GetGlobs returns glob patterns of all sources
+func (cfg Config) GetGlobs() []string {
+	var globs []string
+	for _, s := range cfg.GetSources() {
+		globs = append(globs, s.FilesGlobPatterns...)
+	}
+	return globs
+}
+
+// SearchTermLen returns term lengths to tokenize search queries.
+// The query terms must be found in the index of every source,
+// so it takes the longest min length and the shortest max length among sources.
+func (cfg Config) SearchTermLen() (minTermLen, maxTermLen int) {
+	for i, s := range cfg.GetSources() {
+		if i == 0 {
+			minTermLen, maxTermLen = s.MinTermLen, s.MaxTermLen
+			continue
+		}
+		minTermLen = max(minTermLen, s.MinTermLen)
+		maxTermLen = min(maxTermLen, s.MaxTermLen)
+	}
+	return
 }
 
 // Validate is the final check after all overrides are done (file load, command arguments substituted)
@@ -51,6 +386,14 @@ func (cfg Config) Validate() error {
 			return "value is empty"
 		case "regexp":
 			return "invalid regular expression"
+		case "min":
+			return fmt.Sprintf("must be at least %s", e.Param())
+		case "oneof":
+			return fmt.Sprintf("must be one of: %s", e.Param())
+		case "timezone":
+			return fmt.Sprintf("unknown timezone \"%v\"", e.Value())
+		case "required_without", "required_without_all":
+			return "value is empty"
 		default:
 			return fmt.Sprintf("invalid value (%s)", e.Tag())
 		}
@@ -83,11 +426,21 @@ func (cfg Config) Validate() error {
 		return err
 	}
 
+	err = cfgValidate.RegisterValidation(
+		"timezone", func(fl validator.FieldLevel) bool {
+			_, err := time.LoadLocation(fl.Field().String())
+			return err == nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
 	err = cfgValidate.Struct(cfg)
 	if err != nil {
 		message := "Invalid config values:\n"
 		for _, err := range err.(validator.ValidationErrors) {
-			message += fmt.Sprintf("> %v: %s\n", err.StructField(), translateError(err))
+			message += fmt.Sprintf("> %v: %s\n", strings.TrimPrefix(err.Namespace(), "Config."), translateError(err))
 		}
 		return errors.New(message)
 	}
@@ -96,16 +449,65 @@ func (cfg Config) Validate() error {
 		return errors.New("min term length cannot be greater than max term length")
 	}
 
+	names := make(map[string]struct{})
+	for _, s := range cfg.GetSources() {
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("source name %q is not unique", s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		if s.IsJSON() && s.DateField == "" {
+			return fmt.Errorf("source %q: date_field is required for json format", s.Name)
+		} else if !s.IsJSON() && s.MessageStartRE == "" {
+			return fmt.Errorf("source %q: message_start_re is required", s.Name)
+		}
+
+		if s.MinTermLen < 1 || s.MinTermLen > s.MaxTermLen {
+			return fmt.Errorf("source %q: invalid term length: min %d, max %d", s.Name, s.MinTermLen, s.MaxTermLen)
+		}
+	}
+
+	minTermLen, maxTermLen := cfg.SearchTermLen()
+	if minTermLen > maxTermLen {
+		return errors.New("term lengths of sources do not overlap, so one query can't search all sources")
+	}
+
+	for name := range cfg.Push.Sources {
+		if err := validateHeapName(name); err != nil {
+			return fmt.Errorf("push source: %w", err)
+		}
+	}
+
+	if cfg.SyslogUDP != "" {
+		if _, err := net.ResolveUDPAddr("udp", cfg.SyslogUDP); err != nil {
+			return fmt.Errorf("invalid syslog_udp address: %w", err)
+		}
+	}
+	if cfg.SyslogTCP != "" {
+		if _, err := net.ResolveTCPAddr("tcp", cfg.SyslogTCP); err != nil {
+			return fmt.Errorf("invalid syslog_tcp address: %w", err)
+		}
+	}
+
 	return nil
 }
 
 var DefaultCfg = Config{
-	StoragePath:      "./",
-	FilesGlobPattern: "./*.log",
-	MinTermLen:       4,
-	MaxTermLen:       8,
-	DuckdbMaxMemMb:   500,
-	Concurrency:      runtime.NumCPU(),
+	StoragePath:       "./",
+	FilesGlobPattern:  "./*.log",
+	Scanner:           "native",
+	IngestIntervalSec: 60,
+	WatchDebounceMs:   500,
+	OnDateError:       "skip",
+	MinTermLen:        4,
+	MaxTermLen:        8,
+	DuckdbMaxMemMb:    500,
+	Tokenizer:         TokenizerConfig{Strategy: "prefix", Ngram: 3, Entities: true},
+	Segments:          SegmentsConfig{SizeKb: 5000, MinSizeKb: 500, MaxSizeKb: 20000},
+	HeapMaxFileMb:     100,
+	Push:              PushConfig{BufferMb: 16},
+	Concurrency:       runtime.NumCPU(),
+	IndexConcurrency:  runtime.NumCPU(),
 }
 
 func LoadConfig() (cfg Config, err error) {
@@ -140,6 +542,9 @@ func LoadConfig() (cfg Config, err error) {
 	if cfg.Concurrency < 1 {
 		cfg.Concurrency = DefaultCfg.Concurrency
 	}
+	if cfg.IndexConcurrency < 1 {
+		cfg.IndexConcurrency = DefaultCfg.IndexConcurrency
+	}
 
 	return cfg, cfg.Validate()
 }
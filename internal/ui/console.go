@@ -2,10 +2,17 @@ package ui
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"maps"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/urfave/cli/v3"
@@ -13,6 +20,8 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"heaplog_2024/internal/common"
+	"heaplog_2024/internal/ingest"
+	"heaplog_2024/internal/persistence"
 	"heaplog_2024/internal/search/query_language"
 )
 
@@ -29,9 +38,24 @@ func overrideConfig(cfg Config, cmd *cli.Command) Config {
 	if cmd.String("DateFormat") != "" {
 		cfg.DateFormat = cmd.String("DateFormat")
 	}
+	if cmd.String("Scanner") != "" {
+		cfg.Scanner = cmd.String("Scanner")
+	}
+	if cmd.Int("IngestIntervalSec") != 0 {
+		cfg.IngestIntervalSec = cmd.Int("IngestIntervalSec")
+	}
+	if cmd.Bool("Watch") {
+		cfg.Watch = true
+	}
+	if cmd.Int("WatchDebounceMs") != 0 {
+		cfg.WatchDebounceMs = cmd.Int("WatchDebounceMs")
+	}
 	if cmd.Int("Concurrency") != 0 {
 		cfg.Concurrency = cmd.Int("Concurrency")
 	}
+	if cmd.Int("IndexConcurrency") != 0 {
+		cfg.IndexConcurrency = cmd.Int("IndexConcurrency")
+	}
 	if cmd.Int("MinTermLen") != 0 {
 		cfg.MinTermLen = cmd.Int("MinTermLen")
 	}
@@ -41,6 +65,15 @@ func overrideConfig(cfg Config, cmd *cli.Command) Config {
 	if cmd.Int("DuckdbMaxMemMb") != 0 {
 		cfg.DuckdbMaxMemMb = cmd.Int("DuckdbMaxMemMb")
 	}
+	if cmd.Int("HeapMaxFileMb") != 0 {
+		cfg.HeapMaxFileMb = cmd.Int("HeapMaxFileMb")
+	}
+	if cmd.String("SyslogUDP") != "" {
+		cfg.SyslogUDP = cmd.String("SyslogUDP")
+	}
+	if cmd.String("SyslogTCP") != "" {
+		cfg.SyslogTCP = cmd.String("SyslogTCP")
+	}
 
 	return cfg
 }
@@ -75,11 +108,33 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 			Aliases: []string{"date"},
 			Usage:   "the pattern of a date in a message (go-format, see https://go.dev/src/time/format.go)",
 		},
+		&cli.StringFlag{
+			Name:  "Scanner",
+			Usage: "how to find messages in files: \"native\" or \"ug\" (requires ugrep installed)",
+		},
+		&cli.IntFlag{
+			Name:    "IngestIntervalSec",
+			Aliases: []string{"interval"},
+			Usage:   "how often to look for new data in all files (seconds)",
+		},
+		&cli.BoolFlag{
+			Name:    "Watch",
+			Aliases: []string{"watch"},
+			Usage:   "watch files for changes and ingest them as soon as they change",
+		},
+		&cli.IntFlag{
+			Name:  "WatchDebounceMs",
+			Usage: "changes that happen within this window are ingested together (milliseconds)",
+		},
 		&cli.IntFlag{
 			Name:    "Concurrency",
 			Aliases: []string{"c"},
 			Usage:   "sets the degree of concurrency in the service (affects ingestion and search)",
 		},
+		&cli.IntFlag{
+			Name:  "IndexConcurrency",
+			Usage: "how many segments of a file are tokenized in parallel",
+		},
 		&cli.IntFlag{
 			Name:    "MinTermLen",
 			Aliases: []string{"minterm"},
@@ -95,6 +150,18 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 			Aliases: []string{"duckdb"},
 			Usage:   "Max memory the duckdb instance is allowed to allocate (Mb)",
 		},
+		&cli.IntFlag{
+			Name:  "HeapMaxFileMb",
+			Usage: "streamed logs are written to heap files in the storage, a new file starts after this size (Mb)",
+		},
+		&cli.StringFlag{
+			Name:  "SyslogUDP",
+			Usage: "receive syslog messages over UDP on this address, example: \":514\"",
+		},
+		&cli.StringFlag{
+			Name:  "SyslogTCP",
+			Usage: "receive syslog messages over TCP on this address, example: \":514\"",
+		},
 		&cli.BoolFlag{
 			Name:    "Profile",
 			Aliases: []string{"profile"},
@@ -102,6 +169,93 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 		},
 	}
 
+	// serve runs ingestion and index maintenance in the background and serves the web UI until the context is done
+	serve := func(ctx context.Context, cfg Config, heaplog Heaplog) error {
+		// INGESTION
+		ingestionInFlight := false
+		common.RepeatEvery(
+			ctx, time.Duration(cfg.IngestIntervalSec)*time.Second, func() {
+				if ingestionInFlight {
+					return
+				}
+				ingestionInFlight = true
+				defer func() { ingestionInFlight = false }()
+
+				err := heaplog.Ingestor.Run()
+				if err != nil {
+					heaplog.Logger.Error("Ingestor failed", zap.Error(err))
+				}
+			},
+		)
+
+		// EVENT-DRIVEN INGESTION (periodic ingestion above catches missed events)
+		if cfg.Watch {
+			watcher := ingest.NewWatcher(
+				cfg.GetGlobs(),
+				time.Duration(cfg.WatchDebounceMs)*time.Millisecond,
+				logger,
+			)
+			go func() {
+				err := watcher.Watch(
+					ctx, func(files []string) {
+						err := heaplog.Ingestor.RunFiles(files)
+						if err != nil {
+							heaplog.Logger.Error("Ingestor failed", zap.Error(err))
+						}
+					},
+				)
+				if err != nil {
+					heaplog.Logger.Error("Watcher failed", zap.Error(err))
+				}
+			}()
+		}
+
+		// II MERGING
+		mergingInFlight := false
+		lastPruned := time.Time{}
+		common.RepeatEvery(
+			ctx, 60*time.Second, func() {
+				if mergingInFlight {
+					return
+				}
+				mergingInFlight = true
+				defer func() { mergingInFlight = false }()
+
+				totalMerged := int64(0)
+				for {
+					merged, err := heaplog.II.Merge(20, 40, cfg.Concurrency)
+					if err != nil {
+						heaplog.Logger.Error("II merging failed", zap.Error(err))
+					}
+					if merged == 0 {
+						break
+					}
+					totalMerged += merged
+					heaplog.Logger.Info(fmt.Sprintf("Merged %d segments in II", merged))
+				}
+
+				// merging drops terms of wiped segments, then the journal can forget them
+				if totalMerged == 0 || time.Since(lastPruned) < pruneWipedEvery {
+					return
+				}
+				lastPruned = time.Now()
+				pruned, err := heaplog.Storage.PruneWiped(ctx)
+				if err != nil {
+					heaplog.Logger.Error("pruning wiped segments failed", zap.Error(err))
+				} else if pruned > 0 {
+					heaplog.Logger.Info(fmt.Sprintf("Pruned %d wiped segments", pruned))
+				}
+			},
+		)
+
+		httpApp := NewHttpApp(c, http.FS(frontendPublic), heaplog)
+		go func() {
+			<-ctx.Done()
+			_ = httpApp.ShutdownWithTimeout(3 * time.Second)
+		}()
+		return httpApp.Listen(":3000")
+	}
+
 	cmd := &cli.Command{
 		Commands: []*cli.Command{
 			{
@@ -123,60 +277,148 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 					} else if err != nil {
 						return err
 					}
+					cfg = overrideConfig(cfg, cmd)
+					lock, err := lockStorage(cfg.StoragePath)
+					if err != nil {
+						return err
+					}
+					defer lock.Close()
+
+					// heaps of syslog and pushed logs become sources once their directories exist
+					var syslog *ingest.SyslogHeaps
+					if cfg.SyslogUDP != "" || cfg.SyslogTCP != "" {
+						syslog, err = ingest.NewSyslogHeaps(cfg.HeapDir(syslogHeap), cfg.HeapMaxFileMb*1024*1024)
+						if err != nil {
+							return err
+						}
+					}
+
+					var push *ingest.PushBuffer
+					if len(cfg.Push.Sources) > 0 || cfg.OTLP.Enabled {
+						heaps, err := ingest.OpenPushHeaps(
+							cfg.PushCaps(),
+							func(name string) (*ingest.HeapWriter, error) { return newHeapWriter(cfg, name) },
+						)
+						if err != nil {
+							return err
+						}
+						push = ingest.NewPushBuffer(heaps, cfg.Push.BufferMb*1024*1024, logger)
+					}
 
 					heaplog := NewHeaplog(c, logger, cfg)
 
-					// INGESTION
-					ingestionInFlight := false
-					common.RepeatEvery(
-						ctx, 60*time.Second, func() {
-							if ingestionInFlight {
-								return
-							}
-							ingestionInFlight = true
-							defer func() { ingestionInFlight = false }()
+					if syslog != nil {
+						err = ingest.ListenSyslog(ctx, cfg.SyslogUDP, cfg.SyslogTCP, syslog, heaplog.Ingestor, heaplog.Logger)
+						if err != nil {
+							return err
+						}
+					}
 
-							err := heaplog.Ingestor.Run()
+					// PUSHED LOGS (new lines are searchable within a second)
+					if push != nil {
+						heaplog.Push = push
+						go func() {
+							err := push.Run(ctx)
 							if err != nil {
-								heaplog.Logger.Error("Ingestor failed", zap.Error(err))
+								heaplog.Logger.Error("Push buffer failed", zap.Error(err))
 							}
-						},
-					)
+						}()
+						heaplog.Ingestor.RunTouched(ctx, push.Touched)
+					}
 
-					// II MERGING
-					mergingInFlight := false
-					common.RepeatEvery(
-						ctx, 60*time.Second, func() {
-							if mergingInFlight {
-								return
-							}
-							mergingInFlight = true
-							defer func() { mergingInFlight = false }()
-
-							for {
-								merged, err := heaplog.II.Merge(20, 40, cfg.Concurrency)
-								if err != nil {
-									heaplog.Logger.Error("II merging failed", zap.Error(err))
-								}
-								if merged == 0 {
-									break
-								}
-								heaplog.Logger.Info(fmt.Sprintf("Merged %d segments in II", merged))
-							}
-						},
-					)
+					return serve(ctx, cfg, heaplog)
+				},
+			},
+			{
+				Name: "ingest",
+				Flags: append(
+					flags,
+					&cli.StringFlag{
+						Name:     "name",
+						Required: true,
+						Usage:    "the heap to append the stream to, it is searched as a source of the same name",
+					},
+					&cli.BoolFlag{
+						Name:  "stdin",
+						Usage: "read the stream from stdin",
+					},
+					&cli.StringFlag{
+						Name:  "pipe",
+						Usage: "read the stream from a named pipe, it is reopened when writers close it",
+					},
+				),
+				Description: "Appends a stream of logs to heap files in the storage, indexes them and starts a web UI " +
+					"(instead of \"run\", both can't use the same storage)",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					name := cmd.String("name")
+					err := validateHeapName(name)
+					if err != nil {
+						return err
+					}
+					if cmd.Bool("stdin") == (cmd.String("pipe") != "") {
+						return errors.New("either --stdin or --pipe is required")
+					}
 
-					httpApp := NewHttpApp(c, http.FS(frontendPublic), heaplog)
+					cfg, err := LoadConfig()
+					if err != nil && errors.Is(err, errNoConfigFile) {
+						logger.Info("No config file found, using default config")
+					} else if err != nil {
+						return err
+					}
+					cfg = overrideConfig(cfg, cmd)
+					err = cfg.Validate()
+					if err != nil {
+						return err
+					}
+					lock, err := lockStorage(cfg.StoragePath)
+					if err != nil {
+						return err
+					}
+					defer lock.Close()
+
+					// the heap becomes a source once its directory exists
+					writer, err := newHeapWriter(cfg, name)
+					if err != nil {
+						return err
+					}
+					defer writer.Close()
+
+					heaplog := NewHeaplog(c, logger, cfg)
+
+					// STREAM INGESTION (new lines are searchable within a second)
+					heaplog.Ingestor.RunTouched(ctx, writer.Touched)
 					go func() {
-						<-ctx.Done()
-						_ = httpApp.ShutdownWithTimeout(3 * time.Second)
+						var err error
+						if cmd.Bool("stdin") {
+							err = writer.Consume(os.Stdin)
+						} else {
+							err = writer.ConsumePipe(ctx, cmd.String("pipe"))
+						}
+						if err != nil {
+							heaplog.Logger.Error("Stream failed", zap.String("heap", name), zap.Error(err))
+							return
+						}
+						heaplog.Logger.Info("Stream ended", zap.String("heap", name))
 					}()
-					return httpApp.Listen(":3000")
+
+					return serve(ctx, cfg, heaplog)
 				},
 			},
 			{
-				Name:        "search",
-				Flags:       flags,
+				Name: "search",
+				Flags: append(
+					flags,
+					&cli.StringSliceFlag{
+						Name:    "Source",
+						Aliases: []string{"source"},
+						Usage:   "search only files of the source (repeat for many sources)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "Field",
+						Aliases: []string{"field"},
+						Usage:   "search only messages with the field value, example: level=error (repeat for many values)",
+					},
+				),
 				Description: "Search via the console",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					if cmd.Bool("Profile") {
@@ -201,7 +443,15 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 					}
 
 					heaplog := NewHeaplog(c, logger, cfg)
-					msgs, err := heaplog.Searcher.Search(expr, nil, nil)
+					sources := cmd.StringSlice("Source")
+					if err = validateSources(heaplog, sources); err != nil {
+						return err
+					}
+					fields, err := parseFieldsFilter(cmd.StringSlice("Field"))
+					if err != nil {
+						return err
+					}
+					msgs, err := heaplog.Searcher.Search(expr, sources, fields, nil, nil)
 					if err != nil {
 						return err
 					}
@@ -234,19 +484,182 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 				},
 			},
 			{
-				Name:        "test",
+				Name:      "detect",
+				ArgsUsage: "[file]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "lines",
+						Value: 300,
+						Usage: "how many lines of the file to analyze",
+					},
+				},
+				Description: "Infers the message pattern and the date format from a log file " +
+					"(the first file that matches the config if omitted)",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					file := cmd.Args().First()
+					if file == "" {
+						cfg, err := LoadConfig()
+						if err != nil {
+							return fmt.Errorf("no file given and no usable config: %w", err)
+						}
+						file, err = detectSample(cfg)
+						if err != nil {
+							return err
+						}
+					}
+
+					detection, err := DetectFormat(file, int(cmd.Int("lines")), 3)
+					if err != nil {
+						return err
+					}
+					yamlData, err := yaml.Marshal(
+						struct {
+							MessageStartRE string `yaml:"message_start_re"`
+							DateFormat     string `yaml:"date_format"`
+						}{detection.MessageStartRE, detection.Shape.Layout},
+					)
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf(
+						"Detected %q dates in %d of %d lines of %s:\n\n%s\n",
+						detection.Shape.Name, detection.Matched, detection.Lines, file, yamlData,
+					)
+					fmt.Println("The first messages split with this config:")
+					for _, m := range detection.Preview {
+						fmt.Printf("--- %s\n%s\n", m.Date.Format(time.RFC3339Nano), strings.TrimRight(string(m.Body), "\n"))
+					}
+					return nil
+				},
+			},
+			{
+				Name:        "degraded",
 				Flags:       flags,
-				Description: "Tests config and tries to extract a single message from one of the log files",
+				Description: "Lists indexed files with messages whose dates could not be parsed",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfg, err := prepareCfg(cmd)
+					if err != nil {
+						return err
+					}
+					heaplog := NewHeaplog(c, logger, cfg)
+					files, err := heaplog.Files.GetDegradedFiles()
+					if err != nil {
+						return err
+					}
+					if len(files) == 0 {
+						fmt.Println("No degraded files found")
+						return nil
+					}
+					for _, f := range files {
+						status := fmt.Sprintf("%d messages with bad dates", f.Count)
+						if f.Blacklisted {
+							status = "blacklisted"
+						}
+						fmt.Printf("%s (source %q): %s\n", f.File, f.Source, status)
+						fmt.Printf("  sample %q: %s\n", f.Sample, f.Error)
+					}
+					return nil
+				},
+			},
+			{
+				Name: "fsck",
+				Flags: append(
+					flags,
+					&cli.BoolFlag{
+						Name:  "repair",
+						Usage: "remove orphans, wipe files with problems and index them again",
+					},
+				),
+				Description: "Checks consistency of the storage, exits with 1 if problems were repaired, 4 if not",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfg, err := prepareCfg(cmd)
+					if err != nil {
+						return err
+					}
+					// a running server changes the storage during the check and would index wiped files on its own
+					lock, err := lockStorage(cfg.StoragePath)
+					if err != nil {
+						return cli.Exit(err.Error(), fsckUncorrected)
+					}
+					defer lock.Close()
+					heaplog := NewHeaplog(c, logger, cfg)
+					problems, err := heaplog.Storage.Check(ctx)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("check failed: %s", err), fsckUncorrected)
+					}
+					if len(problems) == 0 {
+						fmt.Println("No problems found")
+						return nil
+					}
+					for _, p := range problems {
+						printProblem(os.Stdout, p)
+					}
+					if !cmd.Bool("repair") {
+						return cli.Exit(fmt.Sprintf("%d problems found", len(problems)), fsckUncorrected)
+					}
+
+					files, err := heaplog.Storage.Repair(ctx, problems)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("repair failed: %s", err), fsckUncorrected)
+					}
+					err = heaplog.Ingestor.RunFiles(files)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("index repaired files: %s", err), fsckUncorrected)
+					}
+					return cli.Exit(
+						fmt.Sprintf("%d problems repaired, %d files indexed again", len(problems), len(files)),
+						fsckCorrected,
+					)
+				},
+			},
+			{
+				Name: "test",
+				Flags: append(
+					flags,
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print results as JSON",
+					},
+					&cli.IntFlag{
+						Name:  "sample",
+						Usage: "check at most this many files per source (0 checks all files)",
+					},
+				),
+				Description: "Tests config: scans all files of each source and parses dates of all messages",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					cfg, err := prepareCfg(cmd)
 					if err != nil {
 						return err
 					}
-					file, err := TestConfig(cfg)
+					results, err := TestConfig(cfg, int(cmd.Int("sample")))
 					if err != nil {
 						return err
 					}
-					fmt.Printf("Great! Found a message in %s\n", file)
+
+					problems := 0
+					for _, result := range results {
+						problems += result.Problems()
+					}
+
+					if cmd.Bool("json") {
+						out, err := json.MarshalIndent(results, "", "  ")
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+					} else {
+						for _, result := range results {
+							printSourceTest(os.Stdout, result)
+						}
+					}
+
+					if problems > 0 {
+						return fmt.Errorf("%d files have problems", problems)
+					}
+					if !cmd.Bool("json") {
+						fmt.Println("Great! All messages are found and their dates are parsed")
+					}
 					return nil
 				},
 			},
@@ -255,3 +668,85 @@ func NewConsole(c context.Context, logger *zap.Logger, frontendPublic fs.FS) *cl
 
 	return cmd
 }
+
+// largeMessage is the size of a message that the test command reports as suspicious
+const largeMessage = 1 << 20
+
+// pruneWipedEvery limits pruning of the journal of wiped segments, as it reads the entire inverted index
+const pruneWipedEvery = time.Hour
+
+// exit codes of the fsck command (as of fsck(8))
+const (
+	fsckCorrected   = 1
+	fsckUncorrected = 4
+)
+
+// printProblem prints a problem of the storage found by the fsck command
+func printProblem(out io.Writer, p persistence.Problem) {
+	where := p.File
+	if p.Segment > 0 {
+		where = strings.TrimSpace(fmt.Sprintf("%s segment %d", p.File, p.Segment))
+	}
+	fmt.Fprintf(out, "%s: %s: %s\n", p.Check, where, p.Detail)
+}
+
+// printSourceTest prints checked files of the source as a table followed by their problems
+func printSourceTest(out io.Writer, result SourceTest) {
+	fmt.Fprintf(out, "Source %q matched %d files:\n", result.Source, len(result.Matched))
+	for _, file := range slices.Sorted(maps.Keys(result.Excluded)) {
+		fmt.Fprintf(out, "  %s (excluded by %q)\n", file, result.Excluded[file])
+	}
+	if len(result.Files) < len(result.Matched) {
+		fmt.Fprintf(out, "Checked a sample of %d files:\n", len(result.Files))
+	}
+
+	formatDate := func(d *time.Time) string {
+		if d == nil {
+			return "-"
+		}
+		return d.Format(time.RFC3339)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  FILE\tMESSAGES\tFROM\tTO\tLARGEST\tDATE ERRORS")
+	for _, c := range result.Files {
+		fmt.Fprintf(
+			w, "  %s\t%d\t%s\t%s\t%d\t%d\n",
+			c.File, c.Messages, formatDate(c.MinDate), formatDate(c.MaxDate), c.LargestMessage, c.DateFailures,
+		)
+	}
+	w.Flush()
+
+	for _, c := range result.Files {
+		if c.Error != "" {
+			fmt.Fprintf(out, "%s: %s\n", c.File, c.Error)
+		}
+		if c.DateFailures > 0 {
+			fmt.Fprintf(out, "%s: %d messages have unparsable dates:\n", c.File, c.DateFailures)
+			for _, f := range c.FailureSamples {
+				fmt.Fprintf(out, "  line %d (offset %d): date %q: %s\n", f.Line, f.Offset, f.Date, f.Error)
+			}
+		}
+		if c.LargestMessage > largeMessage {
+			fmt.Fprintf(
+				out, "%s: the largest message is %d bytes, the message pattern may match too few lines\n",
+				c.File, c.LargestMessage,
+			)
+		}
+	}
+}
+
+// parseFieldsFilter reads "name=value" pairs, values of the same field are alternatives
+func parseFieldsFilter(pairs []string) (common.FieldsFilter, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	fields := make(common.FieldsFilter)
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid field filter %q, expected name=value", pair)
+		}
+		fields[name] = append(fields[name], value)
+	}
+	return fields, nil
+}
@@ -2,7 +2,10 @@ package ui
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
@@ -20,70 +23,285 @@ import (
 	"heaplog_2024/internal/search"
 )
 
+// storageLockFile is locked by the process that indexes the storage and serves the web UI
+const storageLockFile = "heaplog.lock"
+
+var errStorageLocked = errors.New("the storage is used by another heaplog process")
+
 type Heaplog struct {
-	Logger   *zap.Logger
+	Logger *zap.Logger
+	// opens log files, seek indexes of compressed files are kept in the storage
+	Opener   *common.Opener
 	Ingestor *ingest.Ingestor
 	Searcher *search.Search
 	Results  search.ResultsStorage
+	Files    ingest.FilesReport
 	II       *inverted_index_2.InvertedIndex
+	// checks and repairs the storage
+	Storage *persistence.Index
+	// accepts pushed logs, nil if no sources accept them
+	Push *ingest.PushBuffer
+	// names of configured sources
+	Sources []string
 }
 
-// TestConfig	performs basic config test and tries to find a single message in a single file.
-// If no error is found, it means that mostly all is set up correctly.
-func TestConfig(cfg Config) (string, error) {
-	files, err := filepath.Glob(cfg.FilesGlobPattern)
-	if err != nil {
-		return "", fmt.Errorf("unable to find files at %s: %w", cfg.FilesGlobPattern, err)
+// SourceTest reports how files of a source were found and tested
+type SourceTest struct {
+	Source string `json:"source"`
+	// files that match glob patterns of the source
+	Matched []string `json:"matched"`
+	// files skipped by exclude patterns (file -> pattern)
+	Excluded map[string]string `json:"excluded"`
+	// checks of matched files (or a sample of them)
+	Files []ingest.FileCheck `json:"files"`
+}
+
+// Problems counts files that failed the check
+func (t SourceTest) Problems() int {
+	n := 0
+	for _, f := range t.Files {
+		if !f.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// TestConfig checks every source: it finds its files and scans them entirely, parsing dates of all messages.
+// If maxFiles > 0, only a sample of that many files is checked per source.
+// Returns test results in the order of sources, problems of files are reported in the results.
+func TestConfig(cfg Config, maxFiles int) ([]SourceTest, error) {
+	var results []SourceTest
+	for _, source := range cfg.GetSources() {
+		result, err := testSource(cfg, source, maxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", source.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// testSource finds files of the source and checks them (or a sample of them).
+func testSource(cfg Config, source SourceConfig, maxFiles int) (SourceTest, error) {
+	result := SourceTest{Source: source.Name, Excluded: make(map[string]string)}
+	for fm, err := range ingest.DiscoverFiles(source.FilesGlobPatterns, source.Exclude, source.FollowSymlinks) {
+		if err != nil {
+			return result, fmt.Errorf("unable to find files at %s: %w", fm.Path, err)
+		}
+		if fm.ExcludedBy != "" {
+			result.Excluded[fm.Path] = fm.ExcludedBy
+			continue
+		}
+		if !slices.Contains(result.Matched, fm.Path) {
+			result.Matched = append(result.Matched, fm.Path)
+		}
 	}
+	slices.Sort(result.Matched)
+	files := result.Matched
 	if len(files) == 0 {
-		return "", fmt.Errorf("unable to find files at %s: no files found", cfg.FilesGlobPattern)
+		return result, fmt.Errorf("unable to find files at %v: no files found", source.FilesGlobPatterns)
+	}
+
+	// the sample is spread evenly over the sorted files
+	if maxFiles > 0 && len(files) > maxFiles {
+		sample := make([]string, 0, maxFiles)
+		for i := range maxFiles {
+			sample = append(sample, files[i*len(files)/maxFiles])
+		}
+		files = sample
+	}
+
+	opener := common.NewOpener(nil)
+	scanner, dateParser := newScanner(cfg.Scanner, source, opener), newDateParser(source)
+	for _, file := range files {
+		file, err := filepath.Abs(file)
+		if err != nil {
+			return result, fmt.Errorf("unable to find the file at %s: %w", file, err)
+		}
+		result.Files = append(result.Files, ingest.CheckFile(file, opener, scanner, dateParser()))
 	}
+	return result, nil
+}
+
+// Detection is the format of messages inferred from a file
+type Detection struct {
+	File string
+	ingest.DetectedFormat
+	// the first messages of the file split with the detected format
+	Preview []common.FileMessageBody
+}
+
+// detectSample finds the first non-empty file of the sources, excludes and symlinks are handled per source.
+// Sources of JSON and Docker files are skipped, they have no message pattern to detect.
+func detectSample(cfg Config) (string, error) {
+	var globs []string
+	for _, source := range cfg.GetSources() {
+		if source.IsJSON() || source.IsDocker() {
+			continue
+		}
+		globs = append(globs, source.FilesGlobPatterns...)
+		for fm, err := range ingest.DiscoverFiles(source.FilesGlobPatterns, source.Exclude, source.FollowSymlinks) {
+			if err == nil && fm.ExcludedBy == "" && fm.Size > 0 {
+				return fm.Path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no files found at %v", globs)
+}
 
-	var file string
-	file, err = filepath.Abs(files[0])
+// DetectFormat reads up to maxLines lines of the file and infers the message start pattern and the date layout.
+// The first messages of the file are split with the detected format for a preview.
+func DetectFormat(file string, maxLines, previewMessages int) (Detection, error) {
+	result := Detection{File: file}
+	opener := common.NewOpener(nil)
+	f, err := opener.OpenFile(file)
 	if err != nil {
-		return "", fmt.Errorf("unable to find the file at %s: %w", file, err)
+		return result, fmt.Errorf("unable to open the file at %s: %w", file, err)
 	}
+	defer f.Close()
 
-	fileInfo, err := os.Stat(file)
+	result.DetectedFormat, err = ingest.DetectFormat(io.NewSectionReader(f, 0, int64(f.Len())), maxLines)
 	if err != nil {
-		return "", fmt.Errorf("unable to get file info at %s: %w", file, err)
+		return result, fmt.Errorf("unable to detect the format of %s: %w", file, err)
 	}
-	fileSize := fileInfo.Size()
 
-	_, scannedMessages, err := ingest.Scan(
+	_, scannedMessages, err := ingest.NewNativeScanner(regexp.MustCompile(result.MessageStartRE), opener).Scan(
 		file,
-		int(fileSize),
-		cfg.MessageStartRE,
+		f.Len(),
 		[]common.Location{{From: 0, To: 100_000}},
 	)
 	if err != nil {
-		return "", fmt.Errorf("unable to test the file at %s: %w", file, err)
+		return result, fmt.Errorf("unable to preview the file at %s: %w", file, err)
 	}
+	parser, err := common.NewDateParser([]string{result.Shape.Layout}, nil)
+	if err != nil {
+		return result, err
+	}
+	for m := range scannedMessages {
+		if len(result.Preview) == previewMessages {
+			break
+		}
+		body := make([]byte, m.Loc.Len())
+		if _, err = f.ReadAt(body, int64(m.Loc.From)); err != nil && err != io.EOF {
+			return result, fmt.Errorf("unable to preview the file at %s: %w", file, err)
+		}
+		date, _ := parser.Parse(body[m.DateLoc.From-m.Loc.From : m.DateLoc.To-m.Loc.From])
+		preview := common.FileMessageBody{Body: body}
+		preview.File, preview.MessageLayout, preview.Date = file, m.MessageLayout, date
+		result.Preview = append(result.Preview, preview)
+	}
+	return result, nil
+}
 
-	layouts := slices.Collect(scannedMessages)
+// validateSources checks that all requested sources are configured
+func validateSources(heaplog Heaplog, sources []string) error {
+	for _, s := range sources {
+		if !slices.Contains(heaplog.Sources, s) {
+			return fmt.Errorf("unknown source %q", s)
+		}
+	}
+	return nil
+}
 
-	if len(layouts) == 0 {
-		return "", fmt.Errorf("no messages found in %s (check regular expression again)", file)
+// newScanner picks the configured implementation of the message scanner.
+func newScanner(scanner string, source SourceConfig, opener *common.Opener) ingest.MessageScanner {
+	if source.IsJSON() {
+		return ingest.NewJSONScanner(source.DateField, source.Fields, opener)
+	}
+	if source.IsDocker() {
+		return ingest.NewDockerScanner(regexp.MustCompile(source.MessageStartRE), opener)
 	}
-	ml := layouts[0]
+	if scanner == "ug" {
+		return ingest.NewUgScanner(source.MessageStartRE, opener)
+	}
+	return ingest.NewNativeScanner(regexp.MustCompile(source.MessageStartRE), opener)
+}
 
-	// test date extraction:
-	f, err := os.Open(file)
-	if err != nil {
-		return "", fmt.Errorf("unable to test the file at %s: %w", file, err)
+// newTokenizer applies tokenize to messages of the source, JSON messages are tokenized by their values
+// and Docker's logs by the decoded output.
+func newTokenizer(source SourceConfig, tokenize func([]byte) [][]byte) func([]byte) [][]byte {
+	if source.IsJSON() {
+		indexKeys := source.IndexKeys
+		return func(b []byte) [][]byte { return common.TokenizeJSONWith(b, tokenize, indexKeys) }
+	}
+	if source.IsDocker() {
+		return func(b []byte) [][]byte { return tokenize(ingest.DecodeDocker(b)) }
 	}
-	dateBuf := make([]byte, ml.DateLoc.To-ml.DateLoc.From)
-	_, err = f.ReadAt(dateBuf, int64(ml.DateLoc.From))
+	return tokenize
+}
+
+// newTermTokenizer makes the configured tokenizer for the term lengths
+func newTermTokenizer(cfg Config, minTermLen, maxTermLen int) *common.Tokenizer {
+	tokenizer, err := common.NewTokenizer(
+		common.TokenizerOptions{
+			Strategy:   common.TermStrategy(cfg.Tokenizer.Strategy),
+			Separators: cfg.Tokenizer.Separators,
+			Ngram:      cfg.Tokenizer.Ngram,
+			Entities:   cfg.Tokenizer.Entities,
+			MinSize:    minTermLen,
+			MaxSize:    maxTermLen,
+		},
+	)
 	if err != nil {
-		return "", fmt.Errorf("unable to test the file at %s: %w", file, err)
+		panic(err) // validated
 	}
-	_, err = time.Parse(cfg.DateFormat, string(dateBuf))
+	return tokenizer
+}
+
+// indexOptions describes how messages of the source are turned into terms and short terms,
+// files of the source are indexed again when it changes.
+func indexOptions(source SourceConfig, terms, shortTerms *common.Tokenizer) string {
+	b, err := json.Marshal(
+		struct {
+			Terms, ShortTerms common.TokenizerOptions
+			JSON, Docker      bool
+			IndexKeys         bool
+		}{terms.TokenizerOptions, shortTerms.TokenizerOptions, source.IsJSON(), source.IsDocker(), source.IndexKeys},
+	)
 	if err != nil {
-		return "", fmt.Errorf("unable to test the file at %s: parse date: %w", file, err)
+		panic(err) // plain values
 	}
+	return string(b)
+}
 
-	return file, nil
+// newDateParser makes parsers of dates in the source's formats, dates without an offset are in the source's timezone.
+// Every file gets its own parser, so files of the source in different formats don't share the last matched one.
+func newDateParser(source SourceConfig) func() func([]byte) (time.Time, error) {
+	loc := time.UTC
+	if source.Timezone != "" {
+		loc, _ = time.LoadLocation(source.Timezone) // validated
+	}
+	return func() func([]byte) (time.Time, error) {
+		p, err := common.NewDateParser(source.GetDateFormats(), loc)
+		if err != nil {
+			panic(err) // validated
+		}
+		return p.Parse
+	}
+}
+
+// newHeapWriter appends streamed logs to the heap in the storage.
+// Heap files roll over at message starts of the heap's source (any line starts a JSON message).
+func newHeapWriter(cfg Config, name string) (*ingest.HeapWriter, error) {
+	source, ok := cfg.builtInSource(name, "")
+	if !ok {
+		source = cfg.topLevelSource(name, nil)
+		sources := cfg.GetSources()
+		if i := slices.IndexFunc(sources, func(s SourceConfig) bool { return s.Name == name }); i >= 0 {
+			source = sources[i]
+		}
+	}
+
+	var isMessageStart func([]byte) bool
+	if !source.IsJSON() && source.MessageStartRE != "" {
+		re, err := regexp.Compile(source.MessageStartRE)
+		if err != nil {
+			return nil, fmt.Errorf("source %q: invalid message_start_re: %w", name, err)
+		}
+		isMessageStart = re.Match
+	}
+	return ingest.NewHeapWriter(cfg.HeapDir(name), name, cfg.HeapMaxFileMb*1024*1024, isMessageStart)
 }
 
 func NewHeaplog(ctx context.Context, logger *zap.Logger, cfg Config) Heaplog {
@@ -105,36 +323,82 @@ func NewHeaplog(ctx context.Context, logger *zap.Logger, cfg Config) Heaplog {
 		log.Fatal(err)
 	}
 
+	// seek indexes of compressed files are kept next to their segments
+	opener := common.NewOpener(duck)
+
 	persistentIndex, err := persistence.NewPersistentIndex(duck, ii)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tokenize := func(b []byte) [][]byte { return common.Tokenize(b, cfg.MinTermLen, cfg.MaxTermLen) }
-	indexer := ingest.NewIndexer(
-		ctx,
-		logger,
-		tokenize,
-		func(b []byte) (time.Time, error) { return time.Parse(cfg.DateFormat, string(b)) },
+	// query terms must be found in the index of any source,
+	// so short terms of all sources are indexed up to the length of query terms
+	minTermLen, maxTermLen := cfg.SearchTermLen()
+	searchTokenizer := newTermTokenizer(cfg, minTermLen, maxTermLen)
+
+	var (
+		sources     []ingest.Source
+		sourceNames []string
+		decoders    = make(map[string]func([]byte) []byte)
 	)
+	for _, sourceCfg := range cfg.GetSources() {
+		termTokenizer := newTermTokenizer(cfg, sourceCfg.MinTermLen, sourceCfg.MaxTermLen)
+		indexer := ingest.NewIndexer(
+			ctx,
+			logger,
+			newTokenizer(sourceCfg, termTokenizer.Index),
+			newTokenizer(sourceCfg, searchTokenizer.ShortIndex),
+			newDateParser(sourceCfg),
+			ingest.DateErrorPolicy(sourceCfg.OnDateError),
+			cfg.IndexConcurrency,
+			opener,
+		)
+		source := ingest.Source{
+			Name:           sourceCfg.Name,
+			Globs:          sourceCfg.FilesGlobPatterns,
+			Excludes:       sourceCfg.Exclude,
+			FollowSymlinks: sourceCfg.FollowSymlinks,
+			Scanner:        newScanner(cfg.Scanner, sourceCfg, opener),
+			Indexer:        indexer,
+			IndexOptions:   indexOptions(sourceCfg, termTokenizer, searchTokenizer),
+		}
+		if sourceCfg.IsDocker() {
+			// messages show the container's output with the container name as a field
+			source.FileFields = ingest.DockerFileFields
+			decoders[sourceCfg.Name] = ingest.DecodeDocker
+		}
+		sources = append(sources, source)
+		sourceNames = append(sourceNames, sourceCfg.Name)
+	}
 
 	ingestor := ingest.NewIngestor(
-		[]string{cfg.FilesGlobPattern},
-		regexp.MustCompile(cfg.MessageStartRE),
-		5_000_000,
+		sources,
+		cfg.Segments.SegmentSize(),
 		cfg.Concurrency,
 		persistentIndex,
+		opener,
 		logger,
-		indexer,
 	)
 
-	searcher := search.NewSearch(ctx, tokenize, persistentIndex, logger)
+	searcher := search.NewSearch(
+		ctx,
+		searchTokenizer.Query,
+		searchTokenizer.ShortQuery,
+		decoders,
+		opener,
+		persistentIndex,
+		logger,
+	)
 
 	return Heaplog{
 		Logger:   logger,
+		Opener:   opener,
 		Ingestor: ingestor,
 		Searcher: searcher,
 		Results:  duck,
+		Files:    duck,
 		II:       ii,
+		Storage:  persistentIndex,
+		Sources:  sourceNames,
 	}
 }
@@ -2,9 +2,11 @@ package ui
 
 import (
 	"context"
+	"errors"
 	"maps"
 	"net/http"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,6 +18,7 @@ import (
 	"go.uber.org/zap"
 
 	"heaplog_2024/internal/common"
+	"heaplog_2024/internal/ingest"
 	"heaplog_2024/internal/search/query_language"
 )
 
@@ -132,6 +135,50 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 		},
 	)
 
+	app.Get(
+		"/api/files/degraded", func(c *fiber.Ctx) error {
+			// files with messages whose dates could not be parsed
+			files, err := heaplog.Files.GetDegradedFiles()
+			if err != nil {
+				heaplog.Logger.Error("failed to get degraded files", zap.Error(err))
+				return c.Status(fiber.StatusInternalServerError).JSON(
+					fiber.Map{
+						"error": "Error.",
+					},
+				)
+			}
+
+			return c.Status(fiber.StatusOK).JSON(
+				fiber.Map{
+					"files": append([]common.DegradedFile{}, files...),
+				},
+			)
+		},
+	)
+
+	app.Post(
+		"/api/ingest/:source", func(c *fiber.Ctx) error {
+			// pushed lines are appended to heap files of the source
+			return pushLines(c, heaplog, c.Params("source"), readPushedLines)
+		},
+	)
+
+	app.Post(
+		"/v1/logs", func(c *fiber.Ctx) error {
+			// OTLP/HTTP: log records are appended as NDJSON lines to heap files of the "otlp" source
+			err := pushLines(c, heaplog, otlpHeap, readOTLPLines)
+			if err != nil || c.Response().StatusCode() != fiber.StatusAccepted {
+				return err
+			}
+			// an empty ExportLogsServiceResponse in the encoding of the request
+			if strings.HasPrefix(c.Get(fiber.HeaderContentType), "application/x-protobuf") {
+				c.Set(fiber.HeaderContentType, "application/x-protobuf")
+				return c.Status(fiber.StatusOK).Send(nil)
+			}
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{})
+		},
+	)
+
 	app.Get(
 		"/api/query", func(c *fiber.Ctx) error {
 			// List all queries
@@ -155,6 +202,7 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 			return c.Status(fiber.StatusOK).JSON(
 				fiber.Map{
 					"queries": list,
+					"sources": heaplog.Sources,
 				},
 			)
 		},
@@ -198,22 +246,22 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 			}
 
 			pool := common.NewBufferPool([]int{1000})
-			bodies := func(yield func(string) bool) {
-				for mf, err := range common.ReadMessages(ctx, pool, results) {
-					if err != nil {
-						yield("read message failed:" + err.Error())
-						break
-					}
-					if !yield(string(mf.Body)) {
-						break
-					}
+			bodies, fields := []string{}, []map[string]string{} // fields[i] belong to bodies[i]
+			for mf, err := range common.ReadMessages(ctx, heaplog.Opener, pool, results) {
+				if err != nil {
+					bodies = append(bodies, "read message failed:"+err.Error())
+					fields = append(fields, nil)
+					break
 				}
+				bodies = append(bodies, string(heaplog.Searcher.Decode(mf)))
+				fields = append(fields, mf.Fields)
 			}
 
 			return c.JSON(
 				fiber.Map{
 					"query":    query,
-					"messages": append([]string{}, slices.Collect(bodies)...),
+					"messages": bodies,
+					"fields":   fields,
 				},
 			)
 		},
@@ -223,9 +271,11 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 		"/api/query", func(c *fiber.Ctx) error {
 
 			type QueryRequest struct {
-				Query string `json:"query"`
-				From  string `json:"fromDate"`
-				To    string `json:"toDate"`
+				Query   string              `json:"query"`
+				Sources []string            `json:"sources"`
+				Fields  common.FieldsFilter `json:"fields"`
+				From    string              `json:"fromDate"`
+				To      string              `json:"toDate"`
 			}
 
 			var (
@@ -248,6 +298,14 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 				)
 			}
 
+			if err := validateSources(heaplog, req.Sources); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(
+					fiber.Map{
+						"error": err.Error(),
+					},
+				)
+			}
+
 			if req.From != "" {
 				d, err := time.Parse(time.RFC3339, req.From)
 				if err != nil {
@@ -292,7 +350,7 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 				)
 			}
 
-			messages, err := heaplog.Searcher.Search(expr, from, to)
+			messages, err := heaplog.Searcher.Search(expr, req.Sources, req.Fields, from, to)
 			if err != nil {
 				heaplog.Logger.Warn("search failed", zap.Error(err))
 				return c.Status(fiber.StatusBadRequest).JSON(
@@ -304,6 +362,8 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 
 			query := common.UserQuery{
 				Query:    req.Query,
+				Sources:  req.Sources,
+				Fields:   req.Fields,
 				FromDate: from,
 				ToDate:   to,
 			}
@@ -328,3 +388,87 @@ func NewHttpApp(ctx context.Context, frontendPublic http.FileSystem, heaplog Hea
 
 	return app
 }
+
+// pushLines buffers lines read from the request to the heap, the response tells if the buffer is full (429).
+func pushLines(c *fiber.Ctx, heaplog Heaplog, heap string, read func(c *fiber.Ctx, limit int) ([][]byte, error)) error {
+	if heaplog.Push == nil {
+		return c.Status(fiber.StatusNotFound).JSON(
+			fiber.Map{
+				"error": "No sources accept pushed logs.",
+			},
+		)
+	}
+
+	lines, err := read(c, heaplog.Push.Size())
+	if errors.Is(err, ingest.ErrPushTooLarge) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	} else if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	err = heaplog.Push.Push(heap, lines)
+	switch {
+	case errors.Is(err, ingest.ErrUnknownHeap):
+		return c.Status(fiber.StatusNotFound).JSON(
+			fiber.Map{
+				"error": "The source does not accept pushed logs.",
+			},
+		)
+	case errors.Is(err, ingest.ErrPushTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	case errors.Is(err, ingest.ErrPushBufferFull):
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return c.Status(fiber.StatusTooManyRequests).JSON(
+			fiber.Map{
+				"error": "Too many logs pushed, retry later.",
+			},
+		)
+	case err != nil:
+		heaplog.Logger.Error("failed to push lines", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(
+			fiber.Map{
+				"error": "Error.",
+			},
+		)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(
+		fiber.Map{
+			"lines": len(lines),
+		},
+	)
+}
+
+// readPushedLines reads non-empty lines of the request body (plain text or NDJSON), the body may be gzipped.
+// The decompressed body can't be larger than limit.
+func readPushedLines(c *fiber.Ctx, limit int) ([][]byte, error) {
+	body, err := ingest.DecodePushedBody(c.Request().Body(), c.Get(fiber.HeaderContentEncoding), limit)
+	if err != nil {
+		return nil, err
+	}
+	contentType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	ndjson := slices.Contains([]string{"application/x-ndjson", "application/jsonl"}, strings.TrimSpace(contentType))
+	return ingest.SplitPushedLines(body, ndjson)
+}
+
+// readOTLPLines decodes OpenTelemetry log records of the request (protobuf or JSON) into NDJSON lines.
+func readOTLPLines(c *fiber.Ctx, limit int) ([][]byte, error) {
+	body, err := ingest.DecodePushedBody(c.Request().Body(), c.Get(fiber.HeaderContentEncoding), limit)
+	if err != nil {
+		return nil, err
+	}
+	contentType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	return ingest.OTLPLines(body, strings.TrimSpace(contentType), time.Now())
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package ui
+
+import (
+	"errors"
+	"os"
+)
+
+// lockStorage is not supported on this OS, so a server can't make sure it is the only one of the storage
+func lockStorage(storagePath string) (*os.File, error) {
+	return nil, errors.New("locking the storage is not supported on this OS")
+}
@@ -0,0 +1,25 @@
+package ui
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockStorage(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := lockStorage(dir)
+	require.NoError(t, err)
+
+	// the lock outlives garbage collection while the file is referenced
+	runtime.GC()
+	runtime.GC()
+	_, err = lockStorage(dir)
+	require.ErrorIs(t, err, errStorageLocked)
+
+	require.NoError(t, lock.Close())
+	lock, err = lockStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, lock.Close())
+}
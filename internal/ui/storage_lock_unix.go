@@ -0,0 +1,33 @@
+//go:build unix
+
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockStorage makes the process the only server of the storage.
+// The lock is held until the returned file is closed, the caller keeps it open while it serves the storage.
+func lockStorage(storagePath string) (*os.File, error) {
+	err := os.MkdirAll(storagePath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(storagePath, storageLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("lock the storage: %w", err)
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		_ = f.Close()
+		return nil, fmt.Errorf("%w at %s, stop it or push logs to it over HTTP", errStorageLocked, storagePath)
+	} else if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock the storage: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockStorage makes the process the only server of the storage.
+// The lock is held until the returned file is closed, the caller keeps it open while it serves the storage.
+func lockStorage(storagePath string) (*os.File, error) {
+	err := os.MkdirAll(storagePath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(storagePath, storageLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("lock the storage: %w", err)
+	}
+	err = windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{},
+	)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		_ = f.Close()
+		return nil, fmt.Errorf("%w at %s, stop it or push logs to it over HTTP", errStorageLocked, storagePath)
+	} else if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("lock the storage: %w", err)
+	}
+	return f, nil
+}